@@ -0,0 +1,396 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sjain93/web-crawler-go/src/crawler"
+	"github.com/sjain93/web-crawler-go/src/util"
+	"go.uber.org/zap"
+)
+
+// Exit codes of the subcommands, a partial crawl finished and was saved but
+// some of its pages could not be fetched
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+	exitPartial = 3
+)
+
+// output path meaning stdout
+const stdoutPath = "-"
+
+var (
+	errUsage = errors.New("invalid usage")
+	// the flag set has already printed the error along with its usage
+	errBadFlags = errors.New("invalid flags")
+)
+
+// What every subcommand runs against
+type commandEnv struct {
+	crawlerSvc crawler.CrawlerServiceManager
+	reports    reportOutput
+	stdout     io.Writer
+}
+
+// A subcommand run with the arguments following its name, it returns the
+// exit code once its output is written
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(env commandEnv, fs *flag.FlagSet, args []string) (int, error)
+}
+
+var commands = []command{
+	{"crawl", "<url>", "crawl a site and write its report", crawlCommand},
+	{"get", "<id>", "write the report of a stored crawl", getCommand},
+	{"list", "", "list the stored crawls, newest first", listCommand},
+	{"diff", "<base id> <target id>", "compare two stored crawls", diffCommand},
+	{"export", "[id ...]", "write stored crawls to an archive, every crawl when no ID is given", exportCommand},
+}
+
+// Prints the global flags followed by every subcommand
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command] [command flags] [args]\n\n", os.Args[0])
+	fmt.Fprintf(out, "Without a command the interactive menu is shown.\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-8s %-22s %s\n", cmd.name, cmd.args, cmd.summary)
+	}
+	fmt.Fprintf(out, "\nExit codes: %d success, %d failure, %d usage error, %d partial crawl\n\nFlags:\n",
+		exitOK, exitFailure, exitUsage, exitPartial)
+	flag.PrintDefaults()
+}
+
+// Runs the subcommand named by the first argument, errors are logged and
+// turned into an exit code
+func runCommand(
+	crawlerSvc crawler.CrawlerServiceManager,
+	reports reportOutput,
+	args []string,
+	stdout io.Writer,
+	stderr io.Writer,
+	logger *zap.Logger,
+) int {
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+
+		fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+		fs.SetOutput(stderr)
+		fs.Usage = func() {
+			fmt.Fprintf(fs.Output(), "Usage: %s %s [flags] %s\n\n%s\n\nFlags:\n",
+				os.Args[0], cmd.name, cmd.args, cmd.summary)
+			fs.PrintDefaults()
+		}
+
+		env := commandEnv{crawlerSvc: crawlerSvc, reports: reports, stdout: stdout}
+		code, err := cmd.run(env, fs, args[1:])
+		switch {
+		case errors.Is(err, flag.ErrHelp):
+			return exitOK
+		case errors.Is(err, errBadFlags):
+			return exitUsage
+		case errors.Is(err, errUsage):
+			fmt.Fprintf(fs.Output(), "%v\n", err)
+			fs.Usage()
+			return exitUsage
+		case err != nil:
+			logger.Sugar().Errorf("Error running %v: %v", cmd.name, err.Error())
+		}
+		return code
+	}
+
+	fmt.Fprintf(stderr, "unknown command %q, run %s -h for the list of commands\n", args[0], os.Args[0])
+	return exitUsage
+}
+
+// Parses flags wherever they appear among the arguments, so flags can follow
+// the URL or IDs, and returns the remaining arguments
+func parseArgs(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, errBadFlags
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	if len(positional) < min || (max >= 0 && len(positional) > max) {
+		return nil, errors.Wrapf(errUsage, "unexpected number of arguments %v", len(positional))
+	}
+	return positional, nil
+}
+
+// Rejects formats the command can't write
+func checkFormat(format string, formats ...string) error {
+	for _, f := range formats {
+		if format == f {
+			return nil
+		}
+	}
+	return errors.Wrapf(errUsage, "unknown format %q, expected one of %v", format, strings.Join(formats, ", "))
+}
+
+// Opens the output path for writing, "-" writes to stdout. An existing file
+// is only replaced when overwriting, the returned function is called with the
+// outcome of the write
+func openOutput(path string, stdout io.Writer, overwrite bool) (io.Writer, func(err error) error, error) {
+	if path == stdoutPath {
+		return stdout, func(err error) error { return err }, nil
+	}
+
+	file, err := createOutput(path, overwrite)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, file.finish, nil
+}
+
+// Writes the report to the output path, "-" writes to stdout and no path
+// uses the report settings. The path of a written file is printed, partial
+// crawls give their exit code
+func writeCrawlReport(env commandEnv, report []crawler.Metadata, path string) (int, error) {
+	var err error
+	switch path {
+	case "":
+		path, err = env.reports.write(env.crawlerSvc, report, env.stdout)
+	case stdoutPath:
+		path, err = "", env.reports.writeTo(env.crawlerSvc, env.stdout, report)
+	default:
+		err = env.reports.writeFile(env.crawlerSvc, path, report)
+	}
+	if err != nil {
+		return exitFailure, err
+	}
+	if path != "" {
+		fmt.Fprintln(env.stdout, path)
+	}
+
+	for _, crawlRec := range report {
+		if crawlRec.Status() == crawler.CrawlPartial {
+			return exitPartial, nil
+		}
+	}
+	return exitOK, nil
+}
+
+func crawlCommand(env commandEnv, fs *flag.FlagSet, args []string) (int, error) {
+	var opts crawler.CrawlOptions
+	fs.UintVar(&opts.Workers, "workers", 0, "number of concurrent workers (default from CRAWLER_WORKERS)")
+	fs.DurationVar(&opts.Timeout, "timeout", 0, "HTTP timeout per request (default from CRAWLER_TIMEOUT)")
+	fs.StringVar(&opts.Scope, "scope", "", `only follow links whose path starts with this prefix, "" follows every link (default from CRAWLER_SCOPE)`)
+	fs.IntVar(&opts.MaxDepth, "max-depth", 0, "maximum depth from the initial URL, 0 is unlimited (default from CRAWLER_MAX_DEPTH)")
+	fs.IntVar(&opts.MaxPages, "max-pages", 0, "maximum number of pages fetched, 0 is unlimited (default from CRAWLER_MAX_PAGES)")
+	fs.BoolVar(&opts.ForceRefresh, "force", false, "run a new crawl even if a cached crawl exists")
+	output := fs.String("o", "", `file the report is written to, "-" for stdout (default from the report settings)`)
+	format := fs.String("format", string(env.reports.format), `report format, "json", "csv" or "ndjson"`)
+
+	positional, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return exitUsage, err
+	}
+	if err = checkReportFormat(*format); err != nil {
+		return exitUsage, err
+	}
+	env.reports.format = crawler.ReportFormat(*format)
+	if _, err = util.GetHost(positional[0]); err != nil {
+		return exitUsage, errors.Wrap(errUsage, err.Error())
+	}
+	// a limit or scope given as 0 or "" overrides the default rather than
+	// leaving it in place
+	fs.Visit(func(f *flag.Flag) {
+		switch {
+		case f.Name == "scope" && opts.Scope == "":
+			opts.Scope = crawler.NoScope
+		case f.Name == "max-depth" && opts.MaxDepth == 0:
+			opts.MaxDepth = crawler.NoLimit
+		case f.Name == "max-pages" && opts.MaxPages == 0:
+			opts.MaxPages = crawler.NoLimit
+		}
+	})
+
+	report, err := env.crawlerSvc.CrawlSite(crawler.Metadata{InitialURL: positional[0]}, opts)
+	if err != nil {
+		return exitFailure, err
+	}
+	return writeCrawlReport(env, report, *output)
+}
+
+func getCommand(env commandEnv, fs *flag.FlagSet, args []string) (int, error) {
+	output := fs.String("o", "", `file the report is written to, "-" for stdout (default from the report settings)`)
+	format := fs.String("format", string(env.reports.format), `report format, "json", "csv" or "ndjson"`)
+
+	positional, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return exitUsage, err
+	}
+	if err = checkReportFormat(*format); err != nil {
+		return exitUsage, err
+	}
+	env.reports.format = crawler.ReportFormat(*format)
+
+	report, err := env.crawlerSvc.GetCrawl(positional[0])
+	if err != nil {
+		return exitFailure, err
+	}
+	return writeCrawlReport(env, report, *output)
+}
+
+func listCommand(env commandEnv, fs *flag.FlagSet, args []string) (int, error) {
+	query := crawler.CrawlQuery{SummaryOnly: true}
+	fs.StringVar(&query.Host, "host", "", "only crawls of this host")
+	status := fs.String("status", "", `only crawls with this status, "complete" or "partial"`)
+	since := fs.String("since", "", "only crawls created at or after this RFC 3339 time or date")
+	until := fs.String("until", "", "only crawls created before this RFC 3339 time or date")
+	sortBy := fs.String("sort", "created_at", `sort field, "created_at", "host" or "page_count"`)
+	fs.BoolVar(&query.Ascending, "asc", false, "sort in ascending order")
+	fs.IntVar(&query.Limit, "limit", 0, "maximum number of crawls listed, 0 lists every crawl")
+	fs.IntVar(&query.Offset, "offset", 0, "number of crawls skipped")
+	output := fs.String("o", stdoutPath, `file the list is written to, "-" for stdout`)
+	format := fs.String("format", "text", `list format, "text" or "json"`)
+
+	if _, err := parseArgs(fs, args, 0, 0); err != nil {
+		return exitUsage, err
+	}
+	query.Status = crawler.CrawlStatus(*status)
+	query.SortBy = crawler.CrawlSortField(*sortBy)
+
+	var err error
+	if query.From, err = parseTime(*since); err != nil {
+		return exitUsage, err
+	}
+	if query.To, err = parseTime(*until); err != nil {
+		return exitUsage, err
+	}
+	if err = checkFormat(*format, "text", "json"); err != nil {
+		return exitUsage, err
+	}
+
+	page, err := env.crawlerSvc.QueryCrawls(query)
+	if errors.Is(err, crawler.ErrSvcInvalidQuery) {
+		return exitUsage, errors.Wrap(errUsage, err.Error())
+	} else if err != nil {
+		return exitFailure, err
+	}
+
+	w, closeFn, err := openOutput(*output, env.stdout, env.reports.overwrite)
+	if err != nil {
+		return exitFailure, err
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", " ")
+		err = enc.Encode(page.Summaries)
+	} else {
+		for _, summary := range page.Summaries {
+			if _, err = fmt.Fprintln(w, summaryLine(summary)); err != nil {
+				break
+			}
+		}
+	}
+	if err = closeFn(err); err != nil {
+		return exitFailure, err
+	}
+	return exitOK, nil
+}
+
+// Accepts a full RFC 3339 time or a plain date, empty is the zero time
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Wrapf(errUsage, "invalid time %q, expected RFC 3339 or YYYY-MM-DD", value)
+}
+
+func diffCommand(env commandEnv, fs *flag.FlagSet, args []string) (int, error) {
+	output := fs.String("o", stdoutPath, `file the diff is written to, "-" for stdout`)
+	format := fs.String("format", "json", `diff format, "json" or "markdown"`)
+
+	positional, err := parseArgs(fs, args, 2, 2)
+	if err != nil {
+		return exitUsage, err
+	}
+	if err = checkFormat(*format, "json", "markdown"); err != nil {
+		return exitUsage, err
+	}
+
+	diff, err := env.crawlerSvc.DiffCrawls(positional[0], positional[1])
+	if err != nil {
+		return exitFailure, err
+	}
+
+	w, closeFn, err := openOutput(*output, env.stdout, env.reports.overwrite)
+	if err != nil {
+		return exitFailure, err
+	}
+	if *format == "markdown" {
+		_, err = io.WriteString(w, diff.Markdown())
+	} else {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", " ")
+		err = enc.Encode(diff)
+	}
+	if err = closeFn(err); err != nil {
+		return exitFailure, err
+	}
+	return exitOK, nil
+}
+
+func exportCommand(env commandEnv, fs *flag.FlagSet, args []string) (int, error) {
+	output := fs.String("o", defaultArchivePath, `file the archive is written to, "-" for stdout`)
+
+	ids, err := parseArgs(fs, args, 0, -1)
+	if err != nil {
+		return exitUsage, err
+	}
+
+	w, closeFn, err := openOutput(*output, env.stdout, env.reports.overwrite)
+	if err != nil {
+		return exitFailure, err
+	}
+	n, err := env.crawlerSvc.ExportCrawls(w, ids...)
+	// a failed export doesn't leave a partial archive behind
+	if err = closeFn(err); err != nil {
+		return exitFailure, err
+	}
+
+	if *output != stdoutPath {
+		fmt.Fprintf(fs.Output(), "exported %d crawl(s) to %s\n", n, *output)
+	}
+	return exitOK, nil
+}
+
+// One line per crawl, shared by the list command and the menu
+func summaryLine(summary crawler.CrawlSummary) string {
+	return strings.Join([]string{
+		summary.ID,
+		summary.CreatedAt.Format(time.RFC3339),
+		fmt.Sprintf("%-8s", summary.Status),
+		fmt.Sprintf("pages %d", summary.PageCount),
+		fmt.Sprintf("errors %d", summary.ErrorCount),
+		summary.InitialURL,
+	}, "  ")
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sjain93/web-crawler-go/config"
+	"github.com/sjain93/web-crawler-go/src/crawler"
+	"github.com/sjain93/web-crawler-go/src/crawler/instance/instancetest"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func newTestService(t *testing.T, factory *instancetest.Factory) crawler.CrawlerServiceManager {
+	crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+	assert.NoError(t, err)
+
+	crawlerSvc, err := crawler.NewCrawlerService(
+		crawler.WithRepository(crawlerRepo),
+		crawler.WithCrawlerFactory(factory),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(crawlerSvc.Close)
+	return crawlerSvc
+}
+
+// Reports named after the crawl ID in a temporary directory
+func testReports(t *testing.T) reportOutput {
+	return reportOutput{
+		dir:    t.TempDir(),
+		name:   "report-{id}",
+		format: crawler.ReportJSON,
+		now:    func() time.Time { return time.Date(2023, 11, 21, 1, 21, 6, 0, time.UTC) },
+	}
+}
+
+func TestCrawlCommand(t *testing.T) {
+	testCases := map[string]struct {
+		args     []string
+		factory  *instancetest.Factory
+		expected int
+	}{
+		"Complete crawl": {
+			args:     []string{"crawl", "https://monzo.com/"},
+			factory:  &instancetest.Factory{},
+			expected: exitOK,
+		},
+		"Flags after the URL": {
+			args:     []string{"crawl", "https://monzo.com/", "-max-depth", "2", "-scope", "/blog/"},
+			factory:  &instancetest.Factory{},
+			expected: exitOK,
+		},
+		"Partial crawl": {
+			args:     []string{"crawl", "https://monzo.com/"},
+			factory:  &instancetest.Factory{Errors: []error{errors.New("error fetching page")}},
+			expected: exitPartial,
+		},
+		"Failed crawl": {
+			args:     []string{"crawl", "https://monzo.com/"},
+			factory:  &instancetest.Factory{Err: errors.New("unable to start crawler")},
+			expected: exitFailure,
+		},
+		"Missing URL": {
+			args:     []string{"crawl"},
+			factory:  &instancetest.Factory{},
+			expected: exitUsage,
+		},
+		"Invalid URL": {
+			args:     []string{"crawl", "monzo"},
+			factory:  &instancetest.Factory{},
+			expected: exitUsage,
+		},
+		"Unknown format": {
+			args:     []string{"crawl", "-format", "xml", "https://monzo.com/"},
+			factory:  &instancetest.Factory{},
+			expected: exitUsage,
+		},
+		"Unknown command": {
+			args:     []string{"recrawl", "https://monzo.com/"},
+			factory:  &instancetest.Factory{},
+			expected: exitUsage,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			stdout := &bytes.Buffer{}
+			code := runCommand(newTestService(t, tc.factory), testReports(t), tc.args, stdout, io.Discard, zap.NewNop())
+			assert.Equal(t, tc.expected, code)
+
+			if tc.expected == exitOK || tc.expected == exitPartial {
+				// the path of the report is printed
+				data, err := os.ReadFile(strings.TrimSpace(stdout.String()))
+				assert.NoError(t, err)
+				// errors don't survive a JSON round trip, only the IDs are read
+				var report []struct{ ID string }
+				assert.NoError(t, json.Unmarshal(data, &report))
+				assert.Len(t, report, 1)
+			}
+		})
+	}
+
+	t.Run("CSV report", func(t *testing.T) {
+		factory := &instancetest.Factory{Links: []string{"https://monzo.com/", "https://monzo.com/pricing/"}}
+		stdout := &bytes.Buffer{}
+		args := []string{"crawl", "-format", "csv", "https://monzo.com/"}
+		code := runCommand(newTestService(t, factory), testReports(t), args, stdout, io.Discard, zap.NewNop())
+		assert.Equal(t, exitOK, code)
+
+		path := strings.TrimSpace(stdout.String())
+		assert.Equal(t, ".csv", filepath.Ext(path))
+		report, err := os.Open(path)
+		assert.NoError(t, err)
+		defer report.Close()
+		rows, err := csv.NewReader(report).ReadAll()
+		assert.NoError(t, err)
+		// a header and a row per page
+		assert.Len(t, rows, 3)
+	})
+
+	t.Run("Zero limits override the defaults", func(t *testing.T) {
+		crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+		assert.NoError(t, err)
+		cfg := crawler.NewDefaultServiceConfig()
+		cfg.DefaultOptions = crawler.CrawlOptions{Scope: "/blog/", MaxDepth: 3, MaxPages: 100}
+		factory := &instancetest.Factory{}
+		crawlerSvc, err := crawler.NewCrawlerService(
+			crawler.WithRepository(crawlerRepo),
+			crawler.WithCrawlerFactory(factory),
+			crawler.WithConfig(*cfg),
+		)
+		assert.NoError(t, err)
+		defer crawlerSvc.Close()
+
+		args := []string{"crawl", "-max-depth", "0", "-scope", "", "https://monzo.com/"}
+		code := runCommand(crawlerSvc, testReports(t), args, &bytes.Buffer{}, io.Discard, zap.NewNop())
+		assert.Equal(t, exitOK, code)
+
+		configs := factory.Configs()
+		if assert.Len(t, configs, 1) {
+			assert.Equal(t, 0, configs[0].MaxDepth)
+			assert.Equal(t, "", configs[0].Scope)
+			// flags that aren't given keep the default
+			assert.Equal(t, 100, configs[0].MaxPages)
+		}
+	})
+
+	t.Run("Crawl options are passed through", func(t *testing.T) {
+		factory := &instancetest.Factory{}
+		args := []string{"crawl", "-workers", "4", "-timeout", "5s", "-max-pages", "10", "https://monzo.com/"}
+		code := runCommand(newTestService(t, factory), testReports(t), args, &bytes.Buffer{}, io.Discard, zap.NewNop())
+		assert.Equal(t, exitOK, code)
+
+		configs := factory.Configs()
+		if assert.Len(t, configs, 1) {
+			assert.Equal(t, 10, configs[0].MaxPages)
+			assert.Equal(t, 5*time.Second, configs[0].HttpClient.Timeout)
+		}
+	})
+}
+
+func TestStoredCrawlCommands(t *testing.T) {
+	factory := &instancetest.Factory{}
+	crawlerSvc := newTestService(t, factory)
+	reports := testReports(t)
+
+	ids := []string{}
+	for _, links := range [][]string{
+		{"https://monzo.com/", "https://monzo.com/isa/"},
+		{"https://monzo.com/", "https://monzo.com/pricing/"},
+	} {
+		factory.Links = links
+		crawls, err := crawlerSvc.CrawlSite(
+			crawler.Metadata{InitialURL: "https://monzo.com/"},
+			crawler.CrawlOptions{ForceRefresh: true},
+		)
+		assert.NoError(t, err)
+		ids = append(ids, crawls[0].ID)
+	}
+
+	t.Run("Get", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "report.json")
+		stdout := &bytes.Buffer{}
+		code := runCommand(crawlerSvc, reports, []string{"get", ids[0], "-o", path}, stdout, io.Discard, zap.NewNop())
+		assert.Equal(t, exitOK, code)
+		assert.Equal(t, path+"\n", stdout.String())
+
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), ids[0])
+
+		// the report is written to stdout with "-"
+		stdout.Reset()
+		code = runCommand(crawlerSvc, reports, []string{"get", "-o", "-", ids[0]}, stdout, io.Discard, zap.NewNop())
+		assert.Equal(t, exitOK, code)
+		assert.Contains(t, stdout.String(), `"ID": "`+ids[0]+`"`)
+
+		// one page record per line
+		stdout.Reset()
+		code = runCommand(crawlerSvc, reports, []string{"get", "-format", "ndjson", "-o", "-", ids[0]}, stdout, io.Discard, zap.NewNop())
+		assert.Equal(t, exitOK, code)
+		lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+		assert.Len(t, lines, 2)
+		assert.Contains(t, lines[1], `"URL":"https://monzo.com/isa/"`)
+
+		code = runCommand(crawlerSvc, reports, []string{"get", "5b1e9c3d-missing"}, &bytes.Buffer{}, io.Discard, zap.NewNop())
+		assert.Equal(t, exitFailure, code)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		stdout := &bytes.Buffer{}
+		code := runCommand(crawlerSvc, reports, []string{"list", "-host", "monzo.com"}, stdout, io.Discard, zap.NewNop())
+		assert.Equal(t, exitOK, code)
+		lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+		assert.Len(t, lines, 2)
+
+		stdout.Reset()
+		code = runCommand(crawlerSvc, reports, []string{"list", "-format", "json", "-limit", "1"}, stdout, io.Discard, zap.NewNop())
+		assert.Equal(t, exitOK, code)
+		var summaries []crawler.CrawlSummary
+		assert.NoError(t, json.Unmarshal(stdout.Bytes(), &summaries))
+		assert.Len(t, summaries, 1)
+
+		code = runCommand(crawlerSvc, reports, []string{"list", "-since", "yesterday"}, stdout, io.Discard, zap.NewNop())
+		assert.Equal(t, exitUsage, code)
+	})
+
+	t.Run("Diff", func(t *testing.T) {
+		stdout := &bytes.Buffer{}
+		code := runCommand(crawlerSvc, reports, []string{"diff", ids[0], ids[1]}, stdout, io.Discard, zap.NewNop())
+		assert.Equal(t, exitOK, code)
+		var diff crawler.CrawlDiff
+		assert.NoError(t, json.Unmarshal(stdout.Bytes(), &diff))
+		assert.Equal(t, []string{"https://monzo.com/pricing/"}, diff.Added)
+
+		stdout.Reset()
+		code = runCommand(crawlerSvc, reports, []string{"diff", "-format", "markdown", ids[0], ids[1]}, stdout, io.Discard, zap.NewNop())
+		assert.Equal(t, exitOK, code)
+		assert.Contains(t, stdout.String(), "# Crawl diff")
+
+		code = runCommand(crawlerSvc, reports, []string{"diff", ids[0]}, stdout, io.Discard, zap.NewNop())
+		assert.Equal(t, exitUsage, code)
+	})
+
+	t.Run("Export", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "crawls.jsonl.gz")
+		code := runCommand(crawlerSvc, reports, []string{"export", "-o", path, ids[1]}, &bytes.Buffer{}, io.Discard, zap.NewNop())
+		assert.Equal(t, exitOK, code)
+
+		archive, err := os.Open(path)
+		assert.NoError(t, err)
+		defer archive.Close()
+		target := newTestService(t, &instancetest.Factory{})
+		result, err := target.ImportCrawls(archive, crawler.CollisionFail)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{ids[1]}, result.Imported)
+
+		// a failed export leaves no archive behind
+		missing := filepath.Join(t.TempDir(), "missing.jsonl.gz")
+		code = runCommand(crawlerSvc, reports, []string{"export", "-o", missing, "5b1e9c3d-missing"}, &bytes.Buffer{}, io.Discard, zap.NewNop())
+		assert.Equal(t, exitFailure, code)
+		assert.NoFileExists(t, missing)
+
+		// an existing file is kept, even by a failed export that may
+		// overwrite it
+		existing := filepath.Join(t.TempDir(), "existing.jsonl.gz")
+		assert.NoError(t, os.WriteFile(existing, []byte("keep me"), 0o644))
+		code = runCommand(crawlerSvc, reports, []string{"export", "-o", existing, ids[1]}, &bytes.Buffer{}, io.Discard, zap.NewNop())
+		assert.Equal(t, exitFailure, code)
+		overwriting := reports
+		overwriting.overwrite = true
+		code = runCommand(crawlerSvc, overwriting, []string{"export", "-o", existing, "5b1e9c3d-missing"}, &bytes.Buffer{}, io.Discard, zap.NewNop())
+		assert.Equal(t, exitFailure, code)
+		data, err := os.ReadFile(existing)
+		assert.NoError(t, err)
+		assert.Equal(t, "keep me", string(data))
+
+		code = runCommand(crawlerSvc, overwriting, []string{"export", "-o", existing, ids[1]}, &bytes.Buffer{}, io.Discard, zap.NewNop())
+		assert.Equal(t, exitOK, code)
+		data, err = os.ReadFile(existing)
+		assert.NoError(t, err)
+		assert.NotEqual(t, "keep me", string(data))
+	})
+}
+
+func TestReportOutput(t *testing.T) {
+	report := []crawler.Metadata{{ID: "085eeb21-4737-4b21-a501-680c8dc23e95", Host: "localhost:8080"}}
+	writtenAt := time.Date(2023, 11, 21, 1, 21, 6, 0, time.FixedZone("EST", -5*3600))
+
+	t.Run("Name template", func(t *testing.T) {
+		reports := reportOutput{
+			dir:    "reports",
+			name:   "{host}-{timestamp}-{id}",
+			format: crawler.ReportJSON,
+			now:    func() time.Time { return writtenAt },
+		}
+		assert.Equal(
+			t,
+			filepath.Join("reports", "localhost_8080-20231121T062106Z-085eeb21-4737-4b21-a501-680c8dc23e95.json"),
+			reports.path(report),
+		)
+
+		// the format gives the extension
+		reports.format = crawler.ReportNDJSON
+		assert.Equal(t, ".ndjson", filepath.Ext(reports.path(report)))
+	})
+
+	t.Run("Existing reports are kept", func(t *testing.T) {
+		crawlerSvc := newTestService(t, &instancetest.Factory{})
+		reports := reportOutput{
+			dir:    filepath.Join(t.TempDir(), "nested"),
+			name:   "report-{id}",
+			format: crawler.ReportJSON,
+			now:    time.Now,
+		}
+
+		path, err := reports.write(crawlerSvc, report, io.Discard)
+		assert.NoError(t, err)
+		assert.FileExists(t, path)
+		assert.NoError(t, os.WriteFile(path, []byte("keep me"), 0o644))
+
+		_, err = reports.write(crawlerSvc, report, io.Discard)
+		assert.ErrorIs(t, err, errOutputExists)
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "keep me", string(data))
+
+		reports.overwrite = true
+		_, err = reports.write(crawlerSvc, report, io.Discard)
+		assert.NoError(t, err)
+		data, err = os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), report[0].ID)
+	})
+
+	t.Run("Stdout", func(t *testing.T) {
+		dir := t.TempDir()
+		reports := reportOutput{dir: dir, name: "report", stdout: true, format: crawler.ReportJSON, now: time.Now}
+
+		stdout := &bytes.Buffer{}
+		path, err := reports.write(newTestService(t, &instancetest.Factory{}), report, stdout)
+		assert.NoError(t, err)
+		assert.Empty(t, path)
+		assert.Contains(t, stdout.String(), report[0].ID)
+		assert.NoFileExists(t, filepath.Join(dir, "report.json"))
+	})
+}
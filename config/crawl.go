@@ -0,0 +1,62 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// environment variables holding the default settings for every crawl
+const (
+	EnvCrawlWorkers  = "CRAWLER_WORKERS"
+	EnvCrawlTimeout  = "CRAWLER_TIMEOUT"
+	EnvCrawlScope    = "CRAWLER_SCOPE"
+	EnvCrawlMaxDepth = "CRAWLER_MAX_DEPTH"
+	EnvCrawlMaxPages = "CRAWLER_MAX_PAGES"
+)
+
+// Default crawl settings, zero values leave the crawler's own defaults in place
+type CrawlDefaults struct {
+	Workers  uint
+	Timeout  time.Duration
+	Scope    string
+	MaxDepth int
+	MaxPages int
+}
+
+// Reads the crawl defaults from the environment, unset variables are left at
+// their zero value
+func GetCrawlDefaults() (CrawlDefaults, error) {
+	var (
+		d   CrawlDefaults
+		err error
+	)
+
+	if v := os.Getenv(EnvCrawlWorkers); v != "" {
+		workers, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return d, errors.Wrapf(err, "invalid %s", EnvCrawlWorkers)
+		}
+		d.Workers = uint(workers)
+	}
+	if v := os.Getenv(EnvCrawlTimeout); v != "" {
+		if d.Timeout, err = time.ParseDuration(v); err != nil {
+			return d, errors.Wrapf(err, "invalid %s", EnvCrawlTimeout)
+		}
+	}
+	if v := os.Getenv(EnvCrawlMaxDepth); v != "" {
+		if d.MaxDepth, err = strconv.Atoi(v); err != nil {
+			return d, errors.Wrapf(err, "invalid %s", EnvCrawlMaxDepth)
+		}
+	}
+	if v := os.Getenv(EnvCrawlMaxPages); v != "" {
+		if d.MaxPages, err = strconv.Atoi(v); err != nil {
+			return d, errors.Wrapf(err, "invalid %s", EnvCrawlMaxPages)
+		}
+	}
+	d.Scope = os.Getenv(EnvCrawlScope)
+
+	return d, nil
+}
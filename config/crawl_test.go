@@ -0,0 +1,68 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sjain93/web-crawler-go/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCrawlDefaults(t *testing.T) {
+	testCases := map[string]struct {
+		env       map[string]string
+		expected  config.CrawlDefaults
+		wantError bool
+	}{
+		"Nothing set": {
+			env:      map[string]string{},
+			expected: config.CrawlDefaults{},
+		},
+		"Every setting": {
+			env: map[string]string{
+				config.EnvCrawlWorkers:  "50",
+				config.EnvCrawlTimeout:  "15s",
+				config.EnvCrawlScope:    "/blog/",
+				config.EnvCrawlMaxDepth: "3",
+				config.EnvCrawlMaxPages: "500",
+			},
+			expected: config.CrawlDefaults{
+				Workers:  50,
+				Timeout:  15 * time.Second,
+				Scope:    "/blog/",
+				MaxDepth: 3,
+				MaxPages: 500,
+			},
+		},
+		"Invalid timeout": {
+			env:       map[string]string{config.EnvCrawlTimeout: "15"},
+			wantError: true,
+		},
+		"Invalid workers": {
+			env:       map[string]string{config.EnvCrawlWorkers: "-1"},
+			wantError: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			for _, key := range []string{
+				config.EnvCrawlWorkers,
+				config.EnvCrawlTimeout,
+				config.EnvCrawlScope,
+				config.EnvCrawlMaxDepth,
+				config.EnvCrawlMaxPages,
+			} {
+				t.Setenv(key, tc.env[key])
+			}
+
+			d, err := config.GetCrawlDefaults()
+			if tc.wantError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, d)
+		})
+	}
+}
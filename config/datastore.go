@@ -1,7 +1,72 @@
 package config
 
-type MemoryStore map[string]interface{}
+import "sync"
 
-func GetInMemoryStore() MemoryStore {
-	return MemoryStore{}
+// A concurrency safe in memory store of records keyed by ID. Records are
+// stored by value, but the copies are shallow: slices and maps in a record
+// are shared with the caller, who copies them when they can be modified
+type MemoryStore[T any] struct {
+	mu      sync.RWMutex
+	records map[string]T
+}
+
+func GetInMemoryStore[T any]() *MemoryStore[T] {
+	return &MemoryStore[T]{records: map[string]T{}}
+}
+
+func (s *MemoryStore[T]) Get(id string) (T, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.records[id]
+	return rec, ok
+}
+
+// Adds a record, false if a record with the same ID is already stored
+func (s *MemoryStore[T]) Insert(id string, rec T) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.records[id]; ok {
+		return false
+	}
+	s.records[id] = rec
+	return true
+}
+
+// Adds or replaces a record
+func (s *MemoryStore[T]) Put(id string, rec T) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[id] = rec
+}
+
+// Removes a record and returns it, false if it was not stored
+func (s *MemoryStore[T]) Delete(id string) (T, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.records[id]
+	delete(s.records, id)
+	return rec, ok
+}
+
+// Returns every record, in no particular order
+func (s *MemoryStore[T]) Values() []T {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	recs := make([]T, 0, len(s.records))
+	for _, rec := range s.records {
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func (s *MemoryStore[T]) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.records)
 }
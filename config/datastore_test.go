@@ -0,0 +1,42 @@
+package config_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/sjain93/web-crawler-go/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := config.GetInMemoryStore[string]()
+
+	assert.True(t, store.Insert("a", "first"))
+	assert.False(t, store.Insert("a", "second"))
+	rec, ok := store.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "first", rec)
+
+	store.Put("a", "second")
+	rec, _ = store.Get("a")
+	assert.Equal(t, "second", rec)
+
+	rec, ok = store.Delete("a")
+	assert.True(t, ok)
+	assert.Equal(t, "second", rec)
+	_, ok = store.Delete("a")
+	assert.False(t, ok)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			store.Put(fmt.Sprint(i), "value")
+			store.Values()
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 50, store.Len())
+}
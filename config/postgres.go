@@ -0,0 +1,101 @@
+package config
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// environment variables configuring the Postgres connection, a DSN replaces
+// the individual settings
+const (
+	EnvPostgresDriver   = "CRAWLER_POSTGRES_DRIVER"
+	EnvPostgresDSN      = "CRAWLER_POSTGRES_DSN"
+	EnvPostgresHost     = "CRAWLER_POSTGRES_HOST"
+	EnvPostgresPort     = "CRAWLER_POSTGRES_PORT"
+	EnvPostgresUser     = "CRAWLER_POSTGRES_USER"
+	EnvPostgresPassword = "CRAWLER_POSTGRES_PASSWORD"
+	EnvPostgresDatabase = "CRAWLER_POSTGRES_DB"
+	EnvPostgresSSLMode  = "CRAWLER_POSTGRES_SSLMODE"
+	EnvPostgresMaxConns = "CRAWLER_POSTGRES_MAX_CONNS"
+)
+
+// Connection details of the Postgres crawl store. Driver is the name the
+// database/sql driver linked into the binary registers itself under
+type PostgresSettings struct {
+	Driver   string
+	DSN      string
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Database string
+	SSLMode  string
+	// zero leaves the pool unbounded
+	MaxConns int
+}
+
+// Reads the Postgres settings from the environment, anything not set points
+// at a local instance
+func GetPostgresSettings() (PostgresSettings, error) {
+	p := PostgresSettings{
+		Driver:   "postgres",
+		DSN:      os.Getenv(EnvPostgresDSN),
+		Host:     "localhost",
+		Port:     5432,
+		User:     "postgres",
+		Password: os.Getenv(EnvPostgresPassword),
+		Database: "crawler",
+		SSLMode:  "disable",
+	}
+
+	for env, field := range map[string]*string{
+		EnvPostgresDriver:   &p.Driver,
+		EnvPostgresHost:     &p.Host,
+		EnvPostgresUser:     &p.User,
+		EnvPostgresDatabase: &p.Database,
+		EnvPostgresSSLMode:  &p.SSLMode,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*field = v
+		}
+	}
+
+	var err error
+	if v := os.Getenv(EnvPostgresPort); v != "" {
+		if p.Port, err = strconv.Atoi(v); err != nil || p.Port <= 0 {
+			return p, errors.Errorf("invalid %s %q", EnvPostgresPort, v)
+		}
+	}
+	if v := os.Getenv(EnvPostgresMaxConns); v != "" {
+		if p.MaxConns, err = strconv.Atoi(v); err != nil || p.MaxConns < 0 {
+			return p, errors.Errorf("invalid %s %q", EnvPostgresMaxConns, v)
+		}
+	}
+
+	return p, nil
+}
+
+// Returns the connection string, the DSN when one is set or a postgres://
+// URL built from the individual settings
+func (p PostgresSettings) ConnString() string {
+	if p.DSN != "" {
+		return p.DSN
+	}
+
+	u := url.URL{
+		Scheme:   "postgres",
+		Host:     net.JoinHostPort(p.Host, strconv.Itoa(p.Port)),
+		Path:     "/" + p.Database,
+		RawQuery: url.Values{"sslmode": {p.SSLMode}}.Encode(),
+	}
+	if p.Password != "" {
+		u.User = url.UserPassword(p.User, p.Password)
+	} else {
+		u.User = url.User(p.User)
+	}
+	return u.String()
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// environment variables deciding where crawl reports are written
+const (
+	EnvReportDir       = "CRAWLER_REPORT_DIR"
+	EnvReportName      = "CRAWLER_REPORT_NAME"
+	EnvReportOverwrite = "CRAWLER_REPORT_OVERWRITE"
+	EnvReportFormat    = "CRAWLER_REPORT_FORMAT"
+)
+
+// report file name used when none is set, every crawl gets its own report
+const defaultReportName = "report-{host}-{timestamp}"
+
+// reports are JSON unless another format is set
+const defaultReportFormat = "json"
+
+// The directory reports are written to and the template of their file name.
+// The template may hold {host}, {id} and {timestamp}, the extension of the
+// report format is added to it
+type ReportSettings struct {
+	Dir       string
+	Name      string
+	Overwrite bool
+	Format    string
+}
+
+// Reads the report settings from the environment
+func GetReportSettings() (ReportSettings, error) {
+	var (
+		r = ReportSettings{
+			Dir:    os.Getenv(EnvReportDir),
+			Name:   os.Getenv(EnvReportName),
+			Format: os.Getenv(EnvReportFormat),
+		}
+		err error
+	)
+
+	if r.Dir == "" {
+		r.Dir = "."
+	}
+	if r.Name == "" {
+		r.Name = defaultReportName
+	}
+	if r.Format == "" {
+		r.Format = defaultReportFormat
+	}
+	if strings.ContainsAny(r.Name, `/\`) {
+		return r, errors.Errorf("invalid %s, the name can't hold a path", EnvReportName)
+	}
+	if v := os.Getenv(EnvReportOverwrite); v != "" {
+		if r.Overwrite, err = strconv.ParseBool(v); err != nil {
+			return r, errors.Wrapf(err, "invalid %s", EnvReportOverwrite)
+		}
+	}
+
+	return r, nil
+}
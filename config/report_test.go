@@ -0,0 +1,62 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/sjain93/web-crawler-go/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetReportSettings(t *testing.T) {
+	testCases := map[string]struct {
+		env       map[string]string
+		expected  config.ReportSettings
+		wantError bool
+	}{
+		"Nothing set": {
+			env:      map[string]string{},
+			expected: config.ReportSettings{Dir: ".", Name: "report-{host}-{timestamp}", Format: "json"},
+		},
+		"Every setting": {
+			env: map[string]string{
+				config.EnvReportDir:       "reports",
+				config.EnvReportName:      "{host}-{id}",
+				config.EnvReportOverwrite: "true",
+				config.EnvReportFormat:    "csv",
+			},
+			expected: config.ReportSettings{Dir: "reports", Name: "{host}-{id}", Overwrite: true, Format: "csv"},
+		},
+		"Name with a path": {
+			env:       map[string]string{config.EnvReportName: "reports/{id}"},
+			wantError: true,
+		},
+		"Invalid overwrite": {
+			env:       map[string]string{config.EnvReportOverwrite: "sometimes"},
+			wantError: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			for _, k := range []string{
+				config.EnvReportDir,
+				config.EnvReportName,
+				config.EnvReportOverwrite,
+				config.EnvReportFormat,
+			} {
+				t.Setenv(k, "")
+			}
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+
+			res, err := config.GetReportSettings()
+			if tc.wantError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, res)
+		})
+	}
+}
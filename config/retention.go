@@ -0,0 +1,52 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// environment variables limiting how many crawls are kept
+const (
+	EnvRetentionKeepLast = "CRAWLER_RETENTION_KEEP_LAST"
+	EnvRetentionMaxAge   = "CRAWLER_RETENTION_MAX_AGE"
+	EnvRetentionInterval = "CRAWLER_RETENTION_INTERVAL"
+)
+
+// how often the retention limits are applied when no interval is set
+const defaultRetentionInterval = time.Hour
+
+// Limits on the stored crawls, zero values disable a limit
+type RetentionSettings struct {
+	KeepLast int
+	MaxAge   time.Duration
+	Interval time.Duration
+}
+
+// Reads the retention settings from the environment
+func GetRetentionSettings() (RetentionSettings, error) {
+	var (
+		r   = RetentionSettings{Interval: defaultRetentionInterval}
+		err error
+	)
+
+	if v := os.Getenv(EnvRetentionKeepLast); v != "" {
+		if r.KeepLast, err = strconv.Atoi(v); err != nil {
+			return r, errors.Wrapf(err, "invalid %s", EnvRetentionKeepLast)
+		}
+	}
+	if v := os.Getenv(EnvRetentionMaxAge); v != "" {
+		if r.MaxAge, err = time.ParseDuration(v); err != nil {
+			return r, errors.Wrapf(err, "invalid %s", EnvRetentionMaxAge)
+		}
+	}
+	if v := os.Getenv(EnvRetentionInterval); v != "" {
+		if r.Interval, err = time.ParseDuration(v); err != nil {
+			return r, errors.Wrapf(err, "invalid %s", EnvRetentionInterval)
+		}
+	}
+
+	return r, nil
+}
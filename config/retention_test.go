@@ -0,0 +1,61 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sjain93/web-crawler-go/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetRetentionSettings(t *testing.T) {
+	testCases := map[string]struct {
+		env       map[string]string
+		expected  config.RetentionSettings
+		wantError bool
+	}{
+		"Nothing set": {
+			env:      map[string]string{},
+			expected: config.RetentionSettings{Interval: time.Hour},
+		},
+		"Every setting": {
+			env: map[string]string{
+				config.EnvRetentionKeepLast: "10",
+				config.EnvRetentionMaxAge:   "720h",
+				config.EnvRetentionInterval: "15m",
+			},
+			expected: config.RetentionSettings{
+				KeepLast: 10,
+				MaxAge:   720 * time.Hour,
+				Interval: 15 * time.Minute,
+			},
+		},
+		"Invalid max age": {
+			env:       map[string]string{config.EnvRetentionMaxAge: "30 days"},
+			wantError: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			for _, k := range []string{
+				config.EnvRetentionKeepLast,
+				config.EnvRetentionMaxAge,
+				config.EnvRetentionInterval,
+			} {
+				t.Setenv(k, "")
+			}
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+
+			res, err := config.GetRetentionSettings()
+			if tc.wantError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, res)
+		})
+	}
+}
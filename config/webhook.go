@@ -0,0 +1,35 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// environment variables configuring the webhooks notified of finished crawls
+const (
+	EnvWebhookURLs   = "CRAWLER_WEBHOOK_URLS"
+	EnvWebhookSecret = "CRAWLER_WEBHOOK_SECRET"
+)
+
+// Every URL is notified, each request signed with the shared secret
+type WebhookSettings struct {
+	URLs   []string
+	Secret string
+}
+
+// Reads the webhook settings from the environment, the URLs are a comma
+// separated list
+func GetWebhookSettings() WebhookSettings {
+	settings := WebhookSettings{
+		URLs:   []string{},
+		Secret: os.Getenv(EnvWebhookSecret),
+	}
+
+	for _, url := range strings.Split(os.Getenv(EnvWebhookURLs), ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			settings.URLs = append(settings.URLs, url)
+		}
+	}
+
+	return settings
+}
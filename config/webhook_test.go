@@ -0,0 +1,42 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/sjain93/web-crawler-go/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetWebhookSettings(t *testing.T) {
+	testCases := map[string]struct {
+		env      map[string]string
+		expected config.WebhookSettings
+	}{
+		"Nothing set": {
+			env:      map[string]string{},
+			expected: config.WebhookSettings{URLs: []string{}},
+		},
+		"Several URLs": {
+			env: map[string]string{
+				config.EnvWebhookURLs:   "https://example.com/hook, https://example.org/hook,",
+				config.EnvWebhookSecret: "s3cret",
+			},
+			expected: config.WebhookSettings{
+				URLs:   []string{"https://example.com/hook", "https://example.org/hook"},
+				Secret: "s3cret",
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(config.EnvWebhookURLs, "")
+			t.Setenv(config.EnvWebhookSecret, "")
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+
+			assert.Equal(t, tc.expected, config.GetWebhookSettings())
+		})
+	}
+}
@@ -4,6 +4,7 @@ go 1.19
 
 require (
 	github.com/google/uuid v1.4.0
+	github.com/lib/pq v1.10.9
 	github.com/manifoldco/promptui v0.9.0
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.8.4
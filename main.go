@@ -1,11 +1,22 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/google/uuid"
+	_ "github.com/lib/pq"
 	"github.com/manifoldco/promptui"
 	"github.com/sjain93/web-crawler-go/config"
 	"github.com/sjain93/web-crawler-go/src/crawler"
@@ -14,25 +25,105 @@ import (
 )
 
 const (
-	NewCrawlOption  = "New Crawl"
-	LoadCrawlOption = "Load Crawl"
-	AllCrawlOption  = "All Crawls"
-	ExitOption      = "Exit"
+	NewCrawlOption     = "New Crawl"
+	LoadCrawlOption    = "Load Crawl"
+	ResumeCrawlOption  = "Resume Crawl"
+	BackgroundOption   = "Background Crawl"
+	JobsOption         = "Crawl Jobs"
+	CancelJobOption    = "Cancel Job"
+	AllCrawlOption     = "All Crawls"
+	DiffCrawlsOption   = "Diff Crawls"
+	DeleteCrawlOption  = "Delete Crawl"
+	SearchOption       = "Search Crawls"
+	ExportOption       = "Export Crawls"
+	ImportOption       = "Import Crawls"
+	ImportReportOption = "Import Report"
+	ExitOption         = "Exit"
 )
 
+// directory holding checkpoints of in progress crawls, kept across runs so an
+// interrupted crawl can be resumed
+const checkpointDir = ".checkpoints"
+
+// Connects to Postgres with the settings read from the environment, through
+// the lib/pq driver unless another driver is linked in and configured
+func openPostgresRepository() (crawler.CrawlerRepoManager, error) {
+	settings, err := config.GetPostgresSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(settings.Driver, settings.ConnString())
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(settings.MaxConns)
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return crawler.NewPostgresRepository(context.Background(), db)
+}
+
+// A repeatable flag holding "<url> <interval or cron expression>" values
+type scheduleFlags []string
+
+func (f *scheduleFlags) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *scheduleFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
 func main() {
+	var schedules scheduleFlags
+	serve := flag.Bool("serve", false, "run scheduled crawls until interrupted instead of showing the menu")
+	store := flag.String("store", "memory", `where crawls are kept, "memory", "file" or "postgres"`)
+	dataDir := flag.String("data-dir", ".crawls", "directory used by the file store and its page store")
+	reportSettings, err := config.GetReportSettings()
+	if err != nil {
+		log.Fatalf("Error reading report settings: %v", err.Error())
+	}
+	reports := reportOutput{now: time.Now}
+	flag.StringVar(&reports.dir, "report-dir", reportSettings.Dir, "directory reports are written to")
+	flag.StringVar(&reports.name, "report-name", reportSettings.Name, "report file name, {host}, {id} and {timestamp} are filled in and the extension is added")
+	flag.BoolVar(&reports.stdout, "report-stdout", false, "write reports to stdout instead of a file")
+	flag.BoolVar(&reports.overwrite, "overwrite", reportSettings.Overwrite, "replace a report file that already exists")
+	reportFormat := flag.String("report-format", reportSettings.Format, `report format, "json", "csv" or "ndjson"`)
+
+	flag.Usage = usage
+	flag.Var(&schedules, "schedule", `site to crawl on a schedule in serve mode, e.g. "https://monzo.com 6h" or "https://monzo.com 0 3 * * *" (repeatable)`)
+	flag.Parse()
+	if err = checkReportFormat(*reportFormat); err != nil {
+		log.Fatalf("Error choosing the report format: %v", err.Error())
+	}
+	reports.format = crawler.ReportFormat(*reportFormat)
+
 	logger, err := zap.NewProduction()
 	if err != nil {
 		log.Fatalf("Error initializing logger: %v", err.Error())
 	}
 
-	inMemDB := config.GetInMemoryStore()
-	crawlerRepo, err := crawler.NewCrawlerRepository(inMemDB)
-	if err != nil {
-		logger.Sugar().Fatalf("Error initializing in memory datastore: %v", err.Error())
+	crawlerSvc, closePageStore := newService(*store, *dataDir, logger)
+
+	// a subcommand runs once and exits, the menu is only shown without one
+	if flag.NArg() > 0 {
+		code := runCommand(crawlerSvc, reports, flag.Args(), os.Stdout, os.Stderr, logger)
+		crawlerSvc.Close()
+		closePageStore()
+		os.Exit(code)
 	}
 
-	crawlerSvc := crawler.NewCrawlerService(crawlerRepo, logger)
+	if *serve {
+		if err = serveSchedules(crawlerSvc, schedules, logger); err != nil {
+			logger.Sugar().Fatalf("Error scheduling crawls: %v", err.Error())
+		}
+		closePageStore()
+		return
+	}
 
 	for {
 		prompt := promptui.Select{
@@ -40,7 +131,17 @@ func main() {
 			Items: []string{
 				NewCrawlOption,
 				LoadCrawlOption,
+				ResumeCrawlOption,
+				BackgroundOption,
+				JobsOption,
+				CancelJobOption,
 				AllCrawlOption,
+				DiffCrawlsOption,
+				DeleteCrawlOption,
+				SearchOption,
+				ExportOption,
+				ImportOption,
+				ImportReportOption,
 				ExitOption,
 			},
 		}
@@ -53,48 +154,112 @@ func main() {
 		var report []crawler.Metadata
 		switch result {
 		case NewCrawlOption:
-			inPrompt := promptui.Prompt{
-				Label: "Enter a website to crawl",
-				Validate: func(inURL string) error {
-					_, err := util.GetHost(inURL)
-					return err
-				},
+			crawlRec := crawler.Metadata{InitialURL: promptURL("Enter a website to crawl")}
+			cached, err := crawlerSvc.GetCachedCrawl(crawlRec, crawler.CrawlOptions{})
+			if err != nil {
+				logger.Sugar().Errorf("Error checking for cached crawl: %v", err.Error())
+				continue
+			}
+			if len(cached) > 0 && useCachedCrawl(cached[0]) {
+				report = cached
+				break
 			}
 
-			initURL, err := inPrompt.Run()
+			report, err = crawlerSvc.CrawlSite(
+				crawlRec,
+				crawler.CrawlOptions{ForceRefresh: len(cached) > 0},
+			)
 			if err != nil {
-				logger.Sugar().Fatalf("Prompt failed %v\n", err)
+				logger.Sugar().Errorf("Error running crawler: %v", err.Error())
+				continue
 			}
-			report, err = crawlerSvc.CrawlSite(crawler.Metadata{InitialURL: initURL})
+		case LoadCrawlOption:
+			crawlID := promptID("Enter a previous crawl result ID")
+			report, err = crawlerSvc.GetCrawl(crawlID)
 			if err != nil {
 				logger.Sugar().Errorf("Error running crawler: %v", err.Error())
 				continue
 			}
-		case LoadCrawlOption:
-			inPrompt := promptui.Prompt{
-				Label: "Enter a previous crawl result ID",
-				Validate: func(crawlID string) error {
-					_, err := uuid.Parse(crawlID)
-					return err
-				},
+		case ResumeCrawlOption:
+			crawlID, ok := promptCheckpoint(crawlerSvc, logger)
+			if !ok {
+				continue
 			}
-
-			crawlID, err := inPrompt.Run()
+			report, err = crawlerSvc.ResumeCrawl(crawlID)
 			if err != nil {
-				logger.Sugar().Fatalf("Prompt failed %v\n", err)
+				logger.Sugar().Errorf("Error resuming crawl: %v", err.Error())
+				continue
 			}
-			report, err = crawlerSvc.GetCrawl(crawlID)
+		case BackgroundOption:
+			initURL := promptURL("Enter a website to crawl in the background")
+			jobID, err := crawlerSvc.StartCrawl(
+				crawler.Metadata{InitialURL: initURL},
+				crawler.CrawlOptions{},
+			)
 			if err != nil {
-				logger.Sugar().Errorf("Error running crawler: %v", err.Error())
+				logger.Sugar().Errorf("Error starting crawl: %v", err.Error())
 				continue
 			}
+			logger.Sugar().Infof("started crawl job %v", jobID)
+			continue
+		case JobsOption:
+			printJobs(crawlerSvc.ListJobs())
+			continue
+		case CancelJobOption:
+			jobID := promptID("Enter the ID of a crawl job")
+			if err = crawlerSvc.CancelJob(jobID); err != nil {
+				logger.Sugar().Errorf("Error cancelling crawl job: %v", err.Error())
+			}
+			continue
 		case AllCrawlOption:
-			report, err = crawlerSvc.GetCrawlHistory()
+			if err = browseCrawls(crawlerSvc); err != nil {
+				logger.Sugar().Errorf("Error listing crawls: %v", err.Error())
+			}
+			continue
+		case DiffCrawlsOption:
+			baseID := promptID("Enter the ID of the earlier crawl")
+			targetID := promptID("Enter the ID of the later crawl")
+			diff, err := crawlerSvc.DiffCrawls(baseID, targetID)
 			if err != nil {
-				logger.Sugar().Errorf("Error running crawler: %v", err.Error())
+				logger.Sugar().Errorf("Error comparing crawls: %v", err.Error())
 				continue
 			}
+			if err = writeDiffFile(diff, reports.overwrite); err != nil {
+				logger.Sugar().Warnf("Error generating crawl diff: %v", err.Error())
+			}
+			continue
+		case DeleteCrawlOption:
+			crawlID := promptID("Enter the ID of the crawl to delete")
+			if err = crawlerSvc.DeleteCrawl(crawlID); err != nil {
+				logger.Sugar().Errorf("Error deleting crawl: %v", err.Error())
+			}
+			continue
+		case SearchOption:
+			matches, err := crawlerSvc.SearchCrawls(promptSearch())
+			if err != nil {
+				logger.Sugar().Errorf("Error searching crawls: %v", err.Error())
+				continue
+			}
+			printMatches(matches)
+			continue
+		case ExportOption:
+			if err = exportCrawls(crawlerSvc, reports.overwrite); err != nil {
+				logger.Sugar().Errorf("Error exporting crawls: %v", err.Error())
+			}
+			continue
+		case ImportOption:
+			if err = importCrawls(crawlerSvc.ImportCrawls, defaultArchivePath); err != nil {
+				logger.Sugar().Errorf("Error importing crawls: %v", err.Error())
+			}
+			continue
+		case ImportReportOption:
+			if err = importCrawls(crawlerSvc.ImportReport, defaultReportPath); err != nil {
+				logger.Sugar().Errorf("Error importing report: %v", err.Error())
+			}
+			continue
 		case ExitOption:
+			crawlerSvc.Close()
+			closePageStore()
 			os.Exit(0)
 		}
 
@@ -103,19 +268,472 @@ func main() {
 			continue
 		}
 
-		if err = writeReportFile(report); err != nil {
+		path, err := reports.write(crawlerSvc, report, os.Stdout)
+		if err != nil {
 			logger.Sugar().Warnf("Error generating crawl report: %v", err.Error())
 			continue
 		}
+		if path != "" {
+			fmt.Printf("report written to %s\n", path)
+		}
 
 		continue
 	}
 }
 
-func writeReportFile(report []crawler.Metadata) error {
-	file, err := json.MarshalIndent(report, "", " ")
+// Opens the chosen store and builds the service on top of it, along with a
+// function closing the page store once the service is no longer used
+func newService(
+	store string,
+	dataDir string,
+	logger *zap.Logger,
+) (crawler.CrawlerServiceManager, func()) {
+	var (
+		err         error
+		crawlerRepo crawler.CrawlerRepoManager
+		pageStore   crawler.PageStoreManager
+	)
+	switch store {
+	case "memory":
+		crawlerRepo, err = crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+		if err != nil {
+			logger.Sugar().Fatalf("Error initializing in memory datastore: %v", err.Error())
+		}
+	case "file":
+		crawlerRepo, err = crawler.NewFileRepository(dataDir)
+		if err != nil {
+			logger.Sugar().Fatalf("Error initializing file datastore: %v", err.Error())
+		}
+		// pages are written to disk as they are crawled
+		pageStore, err = crawler.NewPageStore(filepath.Join(dataDir, "pages"), 0)
+		if err != nil {
+			logger.Sugar().Fatalf("Error initializing page store: %v", err.Error())
+		}
+	case "postgres":
+		crawlerRepo, err = openPostgresRepository()
+		if err != nil {
+			logger.Sugar().Fatalf("Error initializing postgres datastore: %v", err.Error())
+		}
+	default:
+		logger.Sugar().Fatalf("Unknown store %q, expected memory, file or postgres", store)
+	}
+
+	checkpointRepo, err := crawler.NewCheckpointRepository(checkpointDir)
+	if err != nil {
+		logger.Sugar().Fatalf("Error initializing checkpoint store: %v", err.Error())
+	}
+
+	crawlDefaults, err := config.GetCrawlDefaults()
+	if err != nil {
+		logger.Sugar().Fatalf("Error reading crawl defaults: %v", err.Error())
+	}
+	svcConfig := crawler.NewDefaultServiceConfig()
+	svcConfig.DefaultOptions = crawler.CrawlOptionsFromConfig(crawlDefaults)
+
+	retention, err := config.GetRetentionSettings()
+	if err != nil {
+		logger.Sugar().Fatalf("Error reading retention settings: %v", err.Error())
+	}
+	svcConfig.Retention = crawler.RetentionPolicy{
+		KeepLast: retention.KeepLast,
+		MaxAge:   retention.MaxAge,
+		Interval: retention.Interval,
+	}
+
+	webhookSettings := config.GetWebhookSettings()
+	webhooks := make([]crawler.WebhookConfig, 0, len(webhookSettings.URLs))
+	for _, url := range webhookSettings.URLs {
+		webhooks = append(webhooks, crawler.WebhookConfig{URL: url, Secret: webhookSettings.Secret})
+	}
+
+	svcOpts := []crawler.ServiceOption{
+		crawler.WithRepository(crawlerRepo),
+		crawler.WithCheckpoints(checkpointRepo),
+		crawler.WithLogger(logger),
+		crawler.WithConfig(*svcConfig),
+		crawler.WithWebhooks(webhooks...),
+	}
+	if pageStore != nil {
+		svcOpts = append(svcOpts, crawler.WithPageStore(pageStore))
+	}
+	crawlerSvc, err := crawler.NewCrawlerService(svcOpts...)
+	if err != nil {
+		logger.Sugar().Fatalf("Error initializing crawler service: %v", err.Error())
+	}
+	// the page store index is written on close, so the next start doesn't
+	// replay the active segment
+	closePageStore := func() {
+		if pageStore == nil {
+			return
+		}
+		if err := pageStore.Close(); err != nil {
+			logger.Sugar().Errorf("Error closing page store: %v", err.Error())
+		}
+	}
+
+	return crawlerSvc, closePageStore
+}
+
+// Registers every scheduled crawl and blocks until the process is interrupted,
+// stopping the service before returning
+func serveSchedules(
+	crawlerSvc crawler.CrawlerServiceManager,
+	schedules []string,
+	logger *zap.Logger,
+) error {
+	if len(schedules) == 0 {
+		return fmt.Errorf("serve mode needs at least one -schedule")
+	}
+
+	for _, value := range schedules {
+		initURL, spec, _ := strings.Cut(strings.TrimSpace(value), " ")
+		schedule, err := crawler.ParseSchedule(spec)
+		if err != nil {
+			return fmt.Errorf("%q: %w", value, err)
+		}
+		if _, err = crawlerSvc.ScheduleCrawl(
+			crawler.Metadata{InitialURL: initURL},
+			crawler.CrawlOptions{},
+			schedule,
+		); err != nil {
+			return fmt.Errorf("%q: %w", value, err)
+		}
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	<-sigs
+
+	logger.Sugar().Info("stopping scheduled crawls, waiting for the runs in progress")
+	crawlerSvc.Close()
+	return nil
+}
+
+// Prompts for a URL with a valid scheme and host
+func promptURL(label string) string {
+	inPrompt := promptui.Prompt{
+		Label: label,
+		Validate: func(inURL string) error {
+			_, err := util.GetHost(inURL)
+			return err
+		},
+	}
+
+	inURL, err := inPrompt.Run()
 	if err != nil {
+		log.Fatalf("Prompt failed %v\n", err)
+	}
+	return inURL
+}
+
+// Prompts for a crawl or job ID, both are generated as a uuid
+func promptID(label string) string {
+	inPrompt := promptui.Prompt{
+		Label: label,
+		Validate: func(id string) error {
+			_, err := uuid.Parse(id)
+			return err
+		},
+	}
+
+	id, err := inPrompt.Run()
+	if err != nil {
+		log.Fatalf("Prompt failed %v\n", err)
+	}
+	return id
+}
+
+// Offers the interrupted crawls to pick from, false when there is nothing
+// to resume
+func promptCheckpoint(crawlerSvc crawler.CrawlerServiceManager, logger *zap.Logger) (string, bool) {
+	checkpoints, err := crawlerSvc.ListCheckpoints()
+	if err != nil {
+		logger.Sugar().Errorf("Error listing interrupted crawls: %v", err.Error())
+		return "", false
+	}
+	if len(checkpoints) == 0 {
+		fmt.Println("there are no interrupted crawls to resume")
+		return "", false
+	}
+
+	items := make([]string, 0, len(checkpoints))
+	for _, cp := range checkpoints {
+		items = append(items, fmt.Sprintf(
+			"%s  %s  %s  visited %d  pending %d",
+			cp.ID,
+			cp.UpdatedAt.Local().Format(time.RFC3339),
+			cp.InitialURL,
+			len(cp.State.Visited),
+			len(cp.State.Pending),
+		))
+	}
+	prompt := promptui.Select{Label: "Select an interrupted crawl", Items: items}
+	i, _, err := prompt.Run()
+	if err != nil {
+		log.Fatalf("Prompt failed %v\n", err)
+	}
+	return checkpoints[i].ID, true
+}
+
+// Prints one line per background crawl job with its progress
+func printJobs(jobs []crawler.Job) {
+	if len(jobs) == 0 {
+		fmt.Println("no crawl jobs have been started")
+		return
+	}
+
+	for _, job := range jobs {
+		fmt.Printf(
+			"%s  %-9s  %s  visited %d/%d  errors %d  crawl %s\n",
+			job.ID,
+			job.Status,
+			job.InitialURL,
+			job.Progress.Visited,
+			job.Progress.Discovered,
+			job.Progress.Errors,
+			job.CrawlID,
+		)
+	}
+}
+
+// number of crawls listed at a time by All Crawls
+const historyPageSize = 20
+
+// Lists summaries of the stored crawls newest first, optionally for a single
+// host, one page at a time
+func browseCrawls(crawlerSvc crawler.CrawlerServiceManager) error {
+	hostPrompt := promptui.Prompt{Label: "Filter by host (leave empty for every host)"}
+	host, err := hostPrompt.Run()
+	if err != nil {
+		log.Fatalf("Prompt failed %v\n", err)
+	}
+
+	query := crawler.CrawlQuery{
+		Host:        strings.TrimSpace(host),
+		Limit:       historyPageSize,
+		SummaryOnly: true,
+	}
+	for {
+		page, err := crawlerSvc.QueryCrawls(query)
+		if err != nil {
+			return err
+		}
+		if page.Total == 0 {
+			fmt.Println("no crawls have been stored")
+			return nil
+		}
+
+		for _, summary := range page.Summaries {
+			fmt.Println(summaryLine(summary))
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+
+		next := promptui.Select{
+			Label: fmt.Sprintf("%d crawls in total", page.Total),
+			Items: []string{"Next page", "Done"},
+		}
+		_, result, err := next.Run()
+		if err != nil {
+			log.Fatalf("Prompt failed %v\n", err)
+		}
+		if result == "Done" {
+			return nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+// Prompts for how to match page URLs and the pattern to match
+func promptSearch() crawler.PageSearch {
+	modePrompt := promptui.Select{
+		Label: "Match page URLs by",
+		Items: []crawler.SearchMode{crawler.SearchExact, crawler.SearchPrefix, crawler.SearchRegex},
+	}
+	_, mode, err := modePrompt.Run()
+	if err != nil {
+		log.Fatalf("Prompt failed %v\n", err)
+	}
+
+	patternPrompt := promptui.Prompt{Label: "Enter the URL, prefix or regex"}
+	pattern, err := patternPrompt.Run()
+	if err != nil {
+		log.Fatalf("Prompt failed %v\n", err)
+	}
+
+	return crawler.PageSearch{Mode: crawler.SearchMode(mode), Pattern: pattern}
+}
+
+// Prints one line per matched page, oldest crawl first
+func printMatches(matches []crawler.PageMatch) {
+	if len(matches) == 0 {
+		fmt.Println("no stored crawl saw a matching page")
+		return
+	}
+
+	for _, match := range matches {
+		fmt.Printf(
+			"%s  %s  status %d  %s\n",
+			match.CrawlID,
+			match.CreatedAt.Format(time.RFC3339),
+			match.Page.StatusCode,
+			match.Page.URL,
+		)
+	}
+}
+
+const (
+	// default file crawl archives are written to and read from
+	defaultArchivePath = "crawls.jsonl.gz"
+	// report read by Import Report when no other file is entered
+	defaultReportPath = "report.json"
+)
+
+// Prompts for a file path, an empty answer falls back to the default
+func promptPath(label, defaultPath string) string {
+	inPrompt := promptui.Prompt{Label: label, Default: defaultPath}
+	path, err := inPrompt.Run()
+	if err != nil {
+		log.Fatalf("Prompt failed %v\n", err)
+	}
+	if strings.TrimSpace(path) == "" {
+		return defaultPath
+	}
+	return strings.TrimSpace(path)
+}
+
+// Writes one crawl, or every stored crawl, to an archive file. A failed
+// export doesn't leave a partial archive behind, an existing archive is only
+// replaced when overwriting
+func exportCrawls(crawlerSvc crawler.CrawlerServiceManager, overwrite bool) error {
+	idPrompt := promptui.Prompt{
+		Label: "Enter the ID of the crawl to export (leave empty for every crawl)",
+		Validate: func(id string) error {
+			if id == "" {
+				return nil
+			}
+			_, err := uuid.Parse(id)
+			return err
+		},
+	}
+	crawlID, err := idPrompt.Run()
+	if err != nil {
+		log.Fatalf("Prompt failed %v\n", err)
+	}
+	path := promptPath("Archive file", defaultArchivePath)
+
+	file, err := createOutput(path, overwrite)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	if crawlID != "" {
+		ids = append(ids, crawlID)
+	}
+	n, err := crawlerSvc.ExportCrawls(file, ids...)
+	if err = file.finish(err); err != nil {
+		return err
+	}
+
+	fmt.Printf("exported %d crawl(s) to %s\n", n, path)
+	return nil
+}
+
+// Reads an archive or report file into the repository with the given
+// import, asking what to do with crawls whose ID is already stored
+func importCrawls(
+	importFn func(r io.Reader, policy crawler.CollisionPolicy) (crawler.ImportResult, error),
+	defaultPath string,
+) error {
+	path := promptPath("File to import", defaultPath)
+
+	policyPrompt := promptui.Select{
+		Label: "When a crawl ID is already stored",
+		Items: []crawler.CollisionPolicy{crawler.CollisionSkip, crawler.CollisionRename, crawler.CollisionFail},
+	}
+	_, policy, err := policyPrompt.Run()
+	if err != nil {
+		log.Fatalf("Prompt failed %v\n", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	result, err := importFn(file, crawler.CollisionPolicy(policy))
+	for _, id := range result.Imported {
+		fmt.Printf("imported %s\n", id)
+	}
+	for archivedID, id := range result.Renamed {
+		fmt.Printf("%s was already stored, imported as %s\n", archivedID, id)
+	}
+	for _, id := range result.Skipped {
+		fmt.Printf("%s was already stored, skipped\n", id)
+	}
+	return err
+}
+
+// Asks the user whether a cached crawl should be reported instead of running
+// a new crawl of the same site
+func useCachedCrawl(cached crawler.Metadata) bool {
+	const (
+		useCached = "Use cached crawl"
+		recrawl   = "Recrawl"
+	)
+
+	prompt := promptui.Select{
+		Label: fmt.Sprintf(
+			"A cached crawl from %.1f hours ago exists - use it or recrawl?",
+			time.Since(cached.CreatedAt).Hours(),
+		),
+		Items: []string{useCached, recrawl},
+	}
+
+	_, result, err := prompt.Run()
+	if err != nil {
+		log.Fatalf("Prompt failed %v\n", err)
+	}
+
+	return result == useCached
+}
+
+// Writes a crawl diff as diff.json or diff.md depending on the chosen format,
+// an existing diff is only replaced when overwriting
+func writeDiffFile(diff crawler.CrawlDiff, overwrite bool) error {
+	const (
+		jsonFormat     = "JSON"
+		markdownFormat = "Markdown"
+	)
+
+	prompt := promptui.Select{
+		Label: "Select diff format",
+		Items: []string{jsonFormat, markdownFormat},
+	}
+
+	_, result, err := prompt.Run()
+	if err != nil {
+		log.Fatalf("Prompt failed %v\n", err)
+	}
+
+	path, data := "diff.md", []byte(diff.Markdown())
+	if result != markdownFormat {
+		path = "diff.json"
+		if data, err = json.MarshalIndent(diff, "", " "); err != nil {
+			return err
+		}
+	}
+
+	file, err := createOutput(path, overwrite)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err = file.finish(err); err != nil {
 		return err
 	}
-	return os.WriteFile("report.json", file, 0o644)
+	fmt.Printf("diff written to %s\n", path)
+	return nil
 }
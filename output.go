@@ -0,0 +1,149 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sjain93/web-crawler-go/src/crawler"
+)
+
+// layout of {timestamp} in report names, sorts in time order
+const reportTimestampLayout = "20060102T150405Z"
+
+var errOutputExists = errors.New("file already exists, pass -overwrite to replace it")
+
+// characters of a host that are left out of file names, e.g. the colon of a port
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// Where reports are written, from the CRAWLER_REPORT_* settings unless
+// overridden by flags
+type reportOutput struct {
+	dir string
+	// file name template holding {host}, {id} and {timestamp}
+	name string
+	// write reports to stdout rather than a file
+	stdout    bool
+	overwrite bool
+	// the format written, its name is the extension of report files
+	format crawler.ReportFormat
+	now    func() time.Time
+}
+
+// Expands the name template with the first crawl of the report and the time
+// of writing
+func (o reportOutput) path(report []crawler.Metadata) string {
+	var host, id string
+	if len(report) > 0 {
+		host = unsafeNameChars.ReplaceAllString(report[0].Host, "_")
+		id = report[0].ID
+	}
+
+	name := strings.NewReplacer(
+		"{host}", host,
+		"{id}", id,
+		"{timestamp}", o.now().UTC().Format(reportTimestampLayout),
+	).Replace(o.name)
+	return filepath.Join(o.dir, name+"."+string(o.format))
+}
+
+// Writes the report to stdout or to a new file named by the template, the
+// path written to is returned and is empty for stdout
+func (o reportOutput) write(crawlerSvc crawler.CrawlerServiceManager, report []crawler.Metadata, stdout io.Writer) (string, error) {
+	if o.stdout {
+		return "", o.writeTo(crawlerSvc, stdout, report)
+	}
+
+	path := o.path(report)
+	return path, o.writeFile(crawlerSvc, path, report)
+}
+
+// Writes the report to the path, an existing file is only replaced when
+// overwriting. The directory is created if needed
+func (o reportOutput) writeFile(crawlerSvc crawler.CrawlerServiceManager, path string, report []crawler.Metadata) error {
+	file, err := createOutput(path, o.overwrite)
+	if err != nil {
+		return err
+	}
+	return file.finish(o.writeTo(crawlerSvc, file, report))
+}
+
+// A file being written by a command. A new file is created exclusively, and
+// when overwriting the output is written to a temporary file that only
+// replaces the existing one once the write succeeded
+type outputFile struct {
+	*os.File
+	path string
+	tmp  bool
+}
+
+// Creates the output file and its directory, an existing file is refused
+// unless overwriting
+func createOutput(path string, overwrite bool) (*outputFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	if overwrite {
+		file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+		if err != nil {
+			return nil, err
+		}
+		if err = file.Chmod(0o644); err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return nil, err
+		}
+		return &outputFile{File: file, path: path, tmp: true}, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		return nil, errors.Wrap(errOutputExists, path)
+	} else if err != nil {
+		return nil, err
+	}
+	return &outputFile{File: file, path: path}, nil
+}
+
+// Closes the file given the outcome of the write. A failed write removes the
+// file, which this command created, and leaves any existing file in place
+func (f *outputFile) finish(err error) error {
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if f.tmp {
+		return os.Rename(f.Name(), f.path)
+	}
+	return nil
+}
+
+// Streams the crawls to w in the report format, pages are read one at a time
+// so large crawls aren't held in memory
+func (o reportOutput) writeTo(crawlerSvc crawler.CrawlerServiceManager, w io.Writer, report []crawler.Metadata) error {
+	rw, err := crawler.NewReportWriter(o.format, w)
+	if err != nil {
+		return err
+	}
+	if err = crawlerSvc.WriteReport(rw, report); err != nil {
+		return err
+	}
+	return rw.Close()
+}
+
+// Rejects report formats no writer is registered for
+func checkReportFormat(format string) error {
+	formats := []string{}
+	for _, f := range crawler.ReportFormats() {
+		formats = append(formats, string(f))
+	}
+	return checkFormat(format, formats...)
+}
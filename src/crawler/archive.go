@@ -0,0 +1,338 @@
+package crawler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/sjain93/web-crawler-go/src/crawler/instance"
+)
+
+const (
+	// identifies a crawl archive, checked before anything is imported
+	archiveFormat = "web-crawler-archive"
+	// bumped whenever the layout of an archived crawl changes, archives of
+	// any other version are refused
+	ArchiveVersion = 1
+)
+
+var (
+	ErrArchiveFormat          = errors.New("not a crawl archive")
+	ErrArchiveVersion         = errors.New("unsupported crawl archive version")
+	ErrArchiveCorrupt         = errors.New("crawl archive is corrupt")
+	ErrArchiveCollision       = errors.New("an archived crawl id already exists")
+	ErrInvalidCollisionPolicy = errors.New("collision policy must be skip, rename or fail")
+)
+
+// What an import does with an archived crawl whose ID is already stored
+type CollisionPolicy string
+
+const (
+	// leave the stored crawl alone and move on, the default
+	CollisionSkip CollisionPolicy = "skip"
+	// store the archived crawl under a new ID
+	CollisionRename CollisionPolicy = "rename"
+	// stop the import, crawls imported before the collision are kept
+	CollisionFail CollisionPolicy = "fail"
+)
+
+// An empty policy is the default, anything else has to be a known policy
+func (p CollisionPolicy) resolve() (CollisionPolicy, error) {
+	switch p {
+	case "":
+		return CollisionSkip, nil
+	case CollisionSkip, CollisionRename, CollisionFail:
+		return p, nil
+	default:
+		return p, ErrInvalidCollisionPolicy
+	}
+}
+
+// First line of an archive, the crawl count lets an import tell a truncated
+// archive from a complete one
+type ArchiveHeader struct {
+	Format     string
+	Version    int
+	ExportedAt time.Time
+	Crawls     int
+}
+
+// A link from the page it was found on to the page it points at
+type ArchiveEdge struct {
+	Source string
+	Target string
+}
+
+// A crawl as written to an archive. The parent of each page is kept as an
+// edge rather than on the page, and errors are kept as their messages
+type ArchivedCrawl struct {
+	ID             string
+	InitialURL     string
+	Host           string
+	CreatedAt      time.Time
+	Options        CrawlOptions
+	Fingerprint    string
+	CrawlResultSet []string
+	Pages          []instance.PageRecord
+	Edges          []ArchiveEdge
+	Errors         []string
+}
+
+func newArchivedCrawl(crawlRec Metadata) ArchivedCrawl {
+	archived := ArchivedCrawl{
+		ID:             crawlRec.ID,
+		InitialURL:     crawlRec.InitialURL,
+		Host:           crawlRec.Host,
+		CreatedAt:      crawlRec.CreatedAt,
+		Options:        crawlRec.Options,
+		Fingerprint:    crawlRec.Fingerprint,
+		CrawlResultSet: crawlRec.CrawlResultSet,
+		Pages:          make([]instance.PageRecord, 0, len(crawlRec.Pages)),
+		Edges:          []ArchiveEdge{},
+		Errors:         make([]string, 0, len(crawlRec.ErrList)),
+	}
+	for _, page := range crawlRec.Pages {
+		if page.Parent != "" {
+			archived.Edges = append(archived.Edges, ArchiveEdge{Source: page.Parent, Target: page.URL})
+		}
+		page.Parent = ""
+		archived.Pages = append(archived.Pages, page)
+	}
+	for _, err := range crawlRec.ErrList {
+		archived.Errors = append(archived.Errors, err.Error())
+	}
+	return archived
+}
+
+// Rebuilds the crawl record, the ID has to be a uuid like the ones the
+// service generates and every edge has to point at an archived page
+func (c ArchivedCrawl) metadata() (Metadata, error) {
+	if !validID(c.ID) {
+		return Metadata{}, errors.Wrapf(ErrArchiveCorrupt, "crawl id %q is not a uuid", c.ID)
+	}
+	if c.Host == "" {
+		return Metadata{}, errors.Wrapf(ErrArchiveCorrupt, "crawl %v is missing its host", c.ID)
+	}
+
+	pages := make(map[string]int, len(c.Pages))
+	for i, page := range c.Pages {
+		pages[page.URL] = i
+	}
+
+	crawlRec := Metadata{
+		ID:             c.ID,
+		InitialURL:     c.InitialURL,
+		Host:           c.Host,
+		CreatedAt:      c.CreatedAt,
+		Options:        c.Options,
+		Fingerprint:    c.Fingerprint,
+		CrawlResultSet: c.CrawlResultSet,
+		Pages:          append([]instance.PageRecord{}, c.Pages...),
+		ErrList:        make([]error, 0, len(c.Errors)),
+	}
+	for _, edge := range c.Edges {
+		i, ok := pages[edge.Target]
+		if !ok {
+			return Metadata{}, errors.Wrapf(ErrArchiveCorrupt, "crawl %v has an edge to unknown page %v", c.ID, edge.Target)
+		}
+		crawlRec.Pages[i].Parent = edge.Source
+	}
+	for _, msg := range c.Errors {
+		crawlRec.ErrList = append(crawlRec.ErrList, errors.New(msg))
+	}
+	if crawlRec.CrawlResultSet == nil {
+		crawlRec.CrawlResultSet = []string{}
+	}
+	return crawlRec, nil
+}
+
+// Outcome of an import
+type ImportResult struct {
+	// IDs the crawls were stored under, in archive order
+	Imported []string
+	// archived IDs that were already stored and left alone
+	Skipped []string
+	// archived IDs stored under a new ID, mapped to that ID
+	Renamed map[string]string
+}
+
+// Writes the crawls with the given IDs, or every stored crawl when none are
+// given, to a gzip compressed archive. The archive holds a header line
+// followed by one JSON encoded crawl per line, so crawls are written and
+// read one at a time
+func ExportArchive(ctx context.Context, repo CrawlerRepoManager, w io.Writer, ids ...string) (int, error) {
+	return exportArchive(ctx, repo, nil, w, ids...)
+}
+
+// Writes the archive like ExportArchive, load fills in the pages of crawls
+// saved without them when set
+func exportArchive(
+	ctx context.Context,
+	repo CrawlerRepoManager,
+	load func(crawlRec *Metadata) error,
+	w io.Writer,
+	ids ...string,
+) (int, error) {
+	count := len(ids)
+	crawlAt := func(i int) (Metadata, error) {
+		return repo.GetCrawlByID(ctx, ids[i])
+	}
+	if count == 0 {
+		history, err := repo.GetCrawlHistory(ctx)
+		if err != nil {
+			return 0, err
+		}
+		count = len(history)
+		crawlAt = func(i int) (Metadata, error) {
+			return history[i], nil
+		}
+	}
+
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+
+	header := ArchiveHeader{
+		Format:     archiveFormat,
+		Version:    ArchiveVersion,
+		ExportedAt: time.Now().UTC(),
+		Crawls:     count,
+	}
+	if err := enc.Encode(header); err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < count; i++ {
+		crawlRec, err := crawlAt(i)
+		if err != nil {
+			return i, err
+		}
+		if load != nil {
+			if err = load(&crawlRec); err != nil {
+				return i, err
+			}
+		}
+		if err = enc.Encode(newArchivedCrawl(crawlRec)); err != nil {
+			return i, err
+		}
+	}
+
+	return count, zw.Close()
+}
+
+// Reads an archive written by ExportArchive and saves its crawls in the
+// repository, crawl IDs that are already stored are handled by the policy.
+// Archives of another version are refused before anything is saved
+func ImportArchive(
+	ctx context.Context,
+	repo CrawlerRepoManager,
+	r io.Reader,
+	policy CollisionPolicy,
+) (ImportResult, error) {
+	result := ImportResult{Imported: []string{}, Skipped: []string{}, Renamed: map[string]string{}}
+	policy, err := policy.resolve()
+	if err != nil {
+		return result, err
+	}
+
+	zr, err := gzip.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return result, errors.Wrap(ErrArchiveFormat, err.Error())
+	}
+	defer zr.Close()
+	dec := json.NewDecoder(zr)
+
+	var header ArchiveHeader
+	if err = dec.Decode(&header); err != nil || header.Format != archiveFormat {
+		return result, ErrArchiveFormat
+	}
+	if header.Version != ArchiveVersion {
+		return result, errors.Wrapf(ErrArchiveVersion, "got version %v, expected %v", header.Version, ArchiveVersion)
+	}
+
+	read := 0
+	for {
+		var archived ArchivedCrawl
+		err = dec.Decode(&archived)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return result, errors.Wrap(ErrArchiveCorrupt, err.Error())
+		}
+		read++
+
+		crawlRec, err := archived.metadata()
+		if err != nil {
+			return result, err
+		}
+		if err = importCrawl(ctx, repo, crawlRec, policy, &result); err != nil {
+			return result, err
+		}
+	}
+
+	if read != header.Crawls {
+		return result, errors.Wrapf(ErrArchiveCorrupt, "read %v of %v crawls", read, header.Crawls)
+	}
+	return result, nil
+}
+
+func importCrawl(
+	ctx context.Context,
+	repo CrawlerRepoManager,
+	crawlRec Metadata,
+	policy CollisionPolicy,
+	result *ImportResult,
+) error {
+	archivedID := crawlRec.ID
+	err := repo.Save(ctx, &crawlRec)
+	if errors.Is(err, ErrUniqueKeyViolated) {
+		switch policy {
+		case CollisionSkip:
+			result.Skipped = append(result.Skipped, archivedID)
+			return nil
+		case CollisionFail:
+			return errors.Wrapf(ErrArchiveCollision, "crawl %v", archivedID)
+		}
+
+		crawlRec.ID = uuid.NewString()
+		if err = repo.Save(ctx, &crawlRec); err == nil {
+			result.Renamed[archivedID] = crawlRec.ID
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	result.Imported = append(result.Imported, crawlRec.ID)
+	return nil
+}
+
+// This service method writes the given crawls, or every stored crawl, to a
+// compressed archive and returns how many were written
+func (s *crawlerService) ExportCrawls(w io.Writer, ids ...string) (int, error) {
+	n, err := exportArchive(context.Background(), s.crawlerRepo, s.loadPages, w, ids...)
+	if err != nil && errors.Is(err, ErrRecordNotFound) {
+		return n, ErrSvcRecordNotFound
+	}
+	return n, err
+}
+
+// This service method saves the crawls of an archive written by ExportCrawls,
+// possibly on another machine, in the repository
+func (s *crawlerService) ImportCrawls(r io.Reader, policy CollisionPolicy) (ImportResult, error) {
+	result, err := ImportArchive(context.Background(), s.crawlerRepo, r, policy)
+	if len(result.Imported) > 0 {
+		s.logger.Sugar().Infof(
+			"imported %v crawl(s), %v renamed and %v skipped",
+			len(result.Imported),
+			len(result.Renamed),
+			len(result.Skipped),
+		)
+	}
+	return result, err
+}
@@ -0,0 +1,310 @@
+package crawler_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sjain93/web-crawler-go/config"
+	"github.com/sjain93/web-crawler-go/src/crawler"
+	"github.com/sjain93/web-crawler-go/src/crawler/instance"
+	"github.com/stretchr/testify/assert"
+)
+
+func archiveCrawls() []crawler.Metadata {
+	return []crawler.Metadata{
+		{
+			ID:             "3c0f5a1e-7d2b-4f8a-9e61-0b4d2c7a8f13",
+			InitialURL:     "https://monzo.com/",
+			Host:           "monzo.com",
+			CrawlResultSet: []string{"https://monzo.com/", "https://monzo.com/pricing/"},
+			ErrList:        []error{errors.New("error fetching page: https://monzo.com/help/")},
+			Pages: []instance.PageRecord{
+				{URL: "https://monzo.com/", StatusCode: http.StatusOK, ContentType: "text/html", Size: 2048},
+				{
+					URL:          "https://monzo.com/pricing/",
+					Parent:       "https://monzo.com/",
+					Depth:        1,
+					StatusCode:   http.StatusOK,
+					ResponseTime: 120 * time.Millisecond,
+				},
+			},
+			CreatedAt:   time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC),
+			Options:     crawler.CrawlOptions{Scope: "/", MaxDepth: 2, Workers: 16, Timeout: time.Minute},
+			Fingerprint: "9a8b7c",
+		},
+		{
+			ID:             "8e2d4b6f-1a3c-4e5d-8f70-2c9b1a0d3e54",
+			InitialURL:     "https://www.koho.ca/",
+			Host:           "www.koho.ca",
+			CrawlResultSet: []string{"https://www.koho.ca/"},
+			CreatedAt:      time.Date(2023, 6, 2, 8, 30, 0, 0, time.UTC),
+		},
+	}
+}
+
+// Writes an archive by hand, so tests can break it in specific ways
+func rawArchive(t *testing.T, header crawler.ArchiveHeader, crawls ...crawler.ArchivedCrawl) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	enc := json.NewEncoder(zw)
+	assert.NoError(t, enc.Encode(header))
+	for _, c := range crawls {
+		assert.NoError(t, enc.Encode(c))
+	}
+	assert.NoError(t, zw.Close())
+	return buf
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	inMemDB := config.GetInMemoryStore[crawler.Metadata]()
+	preLoad(inMemDB, archiveCrawls()...)
+	source, err := crawler.NewCrawlerRepository(inMemDB)
+	assert.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	n, err := crawler.ExportArchive(context.Background(), source, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	// the archive can be imported into any backend
+	target, err := crawler.NewFileRepository(t.TempDir())
+	assert.NoError(t, err)
+	result, err := crawler.ImportArchive(context.Background(), target, buf, crawler.CollisionSkip)
+	assert.NoError(t, err)
+	assert.Len(t, result.Imported, 2)
+	assert.Empty(t, result.Skipped)
+
+	for _, expected := range archiveCrawls() {
+		crawlRec, err := target.GetCrawlByID(context.Background(), expected.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, expected.CrawlResultSet, crawlRec.CrawlResultSet)
+		assert.Equal(t, expected.Options, crawlRec.Options)
+		assert.Equal(t, expected.Fingerprint, crawlRec.Fingerprint)
+		assert.True(t, expected.CreatedAt.Equal(crawlRec.CreatedAt))
+		assert.Equal(t, len(expected.ErrList), len(crawlRec.ErrList))
+		if len(expected.Pages) > 0 {
+			assert.Equal(t, expected.Pages, crawlRec.Pages)
+		}
+	}
+
+	t.Run("Selected crawls", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		n, err := crawler.ExportArchive(context.Background(), source, buf, "8e2d4b6f-1a3c-4e5d-8f70-2c9b1a0d3e54")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		_, err = crawler.ExportArchive(context.Background(), source, &bytes.Buffer{}, "missing")
+		assert.ErrorIs(t, err, crawler.ErrRecordNotFound)
+	})
+
+	t.Run("Crawls already in the file store are renamed", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		_, err := crawler.ExportArchive(context.Background(), source, buf)
+		assert.NoError(t, err)
+
+		result, err := crawler.ImportArchive(context.Background(), target, buf, crawler.CollisionRename)
+		assert.NoError(t, err)
+		assert.Len(t, result.Renamed, 2)
+		history, err := target.GetCrawlHistory(context.Background())
+		assert.NoError(t, err)
+		assert.Len(t, history, 4)
+	})
+}
+
+func TestImportArchiveCollisions(t *testing.T) {
+	existing := archiveCrawls()[0]
+	existing.Host = "stored.monzo.com"
+
+	testCases := map[string]struct {
+		policy   crawler.CollisionPolicy
+		imported int
+		skipped  []string
+		renamed  int
+		expected error
+	}{
+		"Skip": {
+			policy:   crawler.CollisionSkip,
+			imported: 1,
+			skipped:  []string{existing.ID},
+		},
+		"Default is skip": {
+			imported: 1,
+			skipped:  []string{existing.ID},
+		},
+		"Rename": {
+			policy:   crawler.CollisionRename,
+			imported: 2,
+			skipped:  []string{},
+			renamed:  1,
+		},
+		"Fail": {
+			policy:   crawler.CollisionFail,
+			skipped:  []string{},
+			expected: crawler.ErrArchiveCollision,
+		},
+		"Unknown policy": {
+			policy:   crawler.CollisionPolicy("overwrite"),
+			skipped:  []string{},
+			expected: crawler.ErrInvalidCollisionPolicy,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			inMemDB := config.GetInMemoryStore[crawler.Metadata]()
+			preLoad(inMemDB, existing)
+			crawlerRepo, err := crawler.NewCrawlerRepository(inMemDB)
+			assert.NoError(t, err)
+
+			crawls := archiveCrawls()
+			archived := []crawler.ArchivedCrawl{}
+			for _, crawlRec := range crawls {
+				archived = append(archived, crawler.ArchivedCrawl{
+					ID:        crawlRec.ID,
+					Host:      crawlRec.Host,
+					CreatedAt: crawlRec.CreatedAt,
+				})
+			}
+			buf := rawArchive(t, crawler.ArchiveHeader{
+				Format:  "web-crawler-archive",
+				Version: crawler.ArchiveVersion,
+				Crawls:  len(archived),
+			}, archived...)
+
+			result, err := crawler.ImportArchive(context.Background(), crawlerRepo, buf, tc.policy)
+			if tc.expected != nil {
+				assert.ErrorIs(t, err, tc.expected)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Len(t, result.Imported, tc.imported)
+			assert.Equal(t, tc.skipped, result.Skipped)
+			assert.Len(t, result.Renamed, tc.renamed)
+
+			// the stored crawl is never overwritten
+			crawlRec, err := crawlerRepo.GetCrawlByID(context.Background(), existing.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, "stored.monzo.com", crawlRec.Host)
+
+			if newID, ok := result.Renamed[existing.ID]; ok {
+				crawlRec, err = crawlerRepo.GetCrawlByID(context.Background(), newID)
+				assert.NoError(t, err)
+				assert.Equal(t, "monzo.com", crawlRec.Host)
+			}
+		})
+	}
+}
+
+func TestImportArchiveValidation(t *testing.T) {
+	header := crawler.ArchiveHeader{Format: "web-crawler-archive", Version: crawler.ArchiveVersion, Crawls: 1}
+	valid := crawler.ArchivedCrawl{ID: "3f6b2d8e-1c4a-4e9b-a7d5-0b8c2e4f6a1d", Host: "monzo.com"}
+
+	newerVersion := header
+	newerVersion.Version = crawler.ArchiveVersion + 1
+	otherFormat := header
+	otherFormat.Format = "report"
+	truncated := header
+	truncated.Crawls = 2
+
+	testCases := map[string]struct {
+		archive  *bytes.Buffer
+		expected error
+	}{
+		"Not compressed": {
+			archive:  bytes.NewBufferString(`[{"ID": "monzo-1"}]`),
+			expected: crawler.ErrArchiveFormat,
+		},
+		"Other format": {
+			archive:  rawArchive(t, otherFormat, valid),
+			expected: crawler.ErrArchiveFormat,
+		},
+		"Unsupported version": {
+			archive:  rawArchive(t, newerVersion, valid),
+			expected: crawler.ErrArchiveVersion,
+		},
+		"Missing crawls": {
+			archive:  rawArchive(t, truncated, valid),
+			expected: crawler.ErrArchiveCorrupt,
+		},
+		"Missing host": {
+			archive:  rawArchive(t, header, crawler.ArchivedCrawl{ID: valid.ID}),
+			expected: crawler.ErrArchiveCorrupt,
+		},
+		"ID is not a uuid": {
+			archive:  rawArchive(t, header, crawler.ArchivedCrawl{ID: "monzo-1", Host: "monzo.com"}),
+			expected: crawler.ErrArchiveCorrupt,
+		},
+		"ID is a path": {
+			archive:  rawArchive(t, header, crawler.ArchivedCrawl{ID: "../crawls/" + valid.ID, Host: "monzo.com"}),
+			expected: crawler.ErrArchiveCorrupt,
+		},
+		"Edge to an unknown page": {
+			archive: rawArchive(t, header, crawler.ArchivedCrawl{
+				ID:    valid.ID,
+				Host:  "monzo.com",
+				Edges: []crawler.ArchiveEdge{{Source: "https://monzo.com/", Target: "https://monzo.com/isa/"}},
+			}),
+			expected: crawler.ErrArchiveCorrupt,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+			assert.NoError(t, err)
+
+			_, err = crawler.ImportArchive(context.Background(), crawlerRepo, tc.archive, crawler.CollisionSkip)
+			assert.ErrorIs(t, err, tc.expected)
+		})
+	}
+
+	t.Run("Version is checked before saving", func(t *testing.T) {
+		crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+		assert.NoError(t, err)
+
+		_, err = crawler.ImportArchive(context.Background(), crawlerRepo, rawArchive(t, newerVersion, valid), "")
+		assert.ErrorIs(t, err, crawler.ErrArchiveVersion)
+		crawls, err := crawlerRepo.GetCrawlHistory(context.Background())
+		assert.NoError(t, err)
+		assert.Empty(t, crawls)
+	})
+}
+
+func TestExportImportCrawls(t *testing.T) {
+	inMemDB := config.GetInMemoryStore[crawler.Metadata]()
+	preLoad(inMemDB, archiveCrawls()...)
+	crawlerRepo, err := crawler.NewCrawlerRepository(inMemDB)
+	assert.NoError(t, err)
+	crawlerSvc, err := crawler.NewCrawlerService(crawler.WithRepository(crawlerRepo))
+	assert.NoError(t, err)
+
+	_, err = crawlerSvc.ExportCrawls(&bytes.Buffer{}, "5b1e9c3d-missing")
+	assert.ErrorIs(t, err, crawler.ErrSvcRecordNotFound)
+
+	buf := &bytes.Buffer{}
+	n, err := crawlerSvc.ExportCrawls(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	// importing into the same service renames every crawl
+	result, err := crawlerSvc.ImportCrawls(buf, crawler.CollisionRename)
+	assert.NoError(t, err)
+	assert.Len(t, result.Renamed, 2)
+
+	history, err := crawlerSvc.GetCrawlHistory()
+	assert.NoError(t, err)
+	assert.Len(t, history, 4)
+
+	diff, err := crawlerSvc.DiffCrawls(
+		"3c0f5a1e-7d2b-4f8a-9e61-0b4d2c7a8f13",
+		result.Renamed["3c0f5a1e-7d2b-4f8a-9e61-0b4d2c7a8f13"],
+	)
+	assert.NoError(t, err)
+	assert.True(t, diff.Empty())
+}
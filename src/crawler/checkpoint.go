@@ -0,0 +1,132 @@
+package crawler
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/sjain93/web-crawler-go/src/crawler/instance"
+	"github.com/sjain93/web-crawler-go/src/util"
+)
+
+var ErrCheckpointNotFound = errors.New("checkpoint not found")
+
+// A checkpoint ties the state of an in progress crawler instance to the crawl
+// record it will be saved as once the crawl completes
+type Checkpoint struct {
+	ID         string
+	InitialURL string
+	Host       string
+	Options    CrawlOptions
+	State      instance.State
+	UpdatedAt  time.Time
+}
+
+// Public interface for persisting checkpoints of long running crawls
+type CheckpointManager interface {
+	Save(cp *Checkpoint) error
+	Get(id string) (Checkpoint, error)
+	List() ([]Checkpoint, error)
+	Delete(id string) error
+}
+
+// CheckpointRepository keeps one JSON file per crawl ID in a local directory
+type CheckpointRepository struct {
+	dir string
+}
+
+// Create a new checkpoint repository, the directory is created if needed
+func NewCheckpointRepository(dir string) (CheckpointManager, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return &CheckpointRepository{}, err
+	}
+
+	return &CheckpointRepository{dir: dir}, nil
+}
+
+// Writes the checkpoint atomically, so a crash mid-write never leaves a
+// partial checkpoint behind
+func (r *CheckpointRepository) Save(cp *Checkpoint) error {
+	if !validID(cp.ID) {
+		return ErrInvalidID
+	}
+	cp.UpdatedAt = time.Now().UTC()
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	return util.WriteFileAtomic(r.path(cp.ID), data)
+}
+
+// Returns the last checkpoint written for the provided crawl ID
+func (r *CheckpointRepository) Get(id string) (Checkpoint, error) {
+	var cp Checkpoint
+	if !validID(id) {
+		return cp, ErrCheckpointNotFound
+	}
+
+	data, err := os.ReadFile(r.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, ErrCheckpointNotFound
+	} else if err != nil {
+		return cp, err
+	}
+
+	err = json.Unmarshal(data, &cp)
+	return cp, err
+}
+
+// Returns every stored checkpoint, most recently written first
+func (r *CheckpointRepository) List() ([]Checkpoint, error) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return []Checkpoint{}, err
+	}
+
+	checkpoints := []Checkpoint{}
+	for _, entry := range entries {
+		// temporary files of a write in progress are left out
+		id := strings.TrimSuffix(entry.Name(), ".json")
+		if entry.IsDir() || id == entry.Name() || !validID(id) {
+			continue
+		}
+		cp, err := r.Get(id)
+		if errors.Is(err, ErrCheckpointNotFound) {
+			// removed since the directory was read
+			continue
+		} else if err != nil {
+			return []Checkpoint{}, err
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+
+	sort.Slice(checkpoints, func(i, j int) bool {
+		return checkpoints[i].UpdatedAt.After(checkpoints[j].UpdatedAt)
+	})
+	return checkpoints, nil
+}
+
+// Removes the checkpoint for the provided crawl ID, missing checkpoints are
+// not treated as an error
+func (r *CheckpointRepository) Delete(id string) error {
+	if !validID(id) {
+		return nil
+	}
+	err := os.Remove(r.path(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// The ID must have been validated, so it can't point outside the directory
+func (r *CheckpointRepository) path(id string) string {
+	return filepath.Join(r.dir, id+".json")
+}
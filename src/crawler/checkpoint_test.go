@@ -0,0 +1,88 @@
+package crawler_test
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/sjain93/web-crawler-go/src/crawler"
+	"github.com/sjain93/web-crawler-go/src/crawler/instance"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpointRepository(t *testing.T) {
+	checkpointRepo, err := crawler.NewCheckpointRepository(t.TempDir())
+	assert.NoError(t, err)
+
+	saved := crawler.Checkpoint{
+		ID:         "5eb020a4-54cc-4b57-b19f-cbd33a2df881",
+		InitialURL: "https://monzo.com/",
+		Host:       "monzo.com",
+		State: instance.State{
+			InitialURL: "https://monzo.com/",
+			Visited:    []string{"https://monzo.com/"},
+			Pending:    []instance.Link{{URL: "https://monzo.com/isa/", Depth: 1}},
+			Errors:     []string{},
+		},
+	}
+	assert.NoError(t, checkpointRepo.Save(&saved))
+
+	testCases := map[string]struct {
+		id          string
+		expectedErr error
+	}{
+		"Success": {
+			id:          saved.ID,
+			expectedErr: nil,
+		},
+		"Failure - not found": {
+			id:          uuid.NewString(),
+			expectedErr: crawler.ErrCheckpointNotFound,
+		},
+		"Failure - path instead of an id": {
+			id:          "../checkpoints/" + saved.ID,
+			expectedErr: crawler.ErrCheckpointNotFound,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cp, err := checkpointRepo.Get(tc.id)
+			assert.Equal(t, tc.expectedErr, err)
+			if tc.expectedErr == nil {
+				assert.Equal(t, saved.State, cp.State)
+				assert.False(t, cp.UpdatedAt.IsZero())
+			}
+		})
+	}
+
+	t.Run("IDs must be uuids", func(t *testing.T) {
+		assert.Equal(t, crawler.ErrInvalidID, checkpointRepo.Save(&crawler.Checkpoint{ID: "a/" + saved.ID}))
+	})
+
+	t.Run("List", func(t *testing.T) {
+		later := crawler.Checkpoint{ID: "9c41d0e7-2f3b-4a8e-b6d5-7e1f0a2c3b4d", InitialURL: "https://monzo.com/blog/"}
+		assert.NoError(t, checkpointRepo.Save(&later))
+
+		checkpoints, err := checkpointRepo.List()
+		assert.NoError(t, err)
+		if assert.Len(t, checkpoints, 2) {
+			// most recently written first
+			assert.Equal(t, later.ID, checkpoints[0].ID)
+			assert.Equal(t, saved.ID, checkpoints[1].ID)
+			assert.Equal(t, saved.State, checkpoints[1].State)
+		}
+		assert.NoError(t, checkpointRepo.Delete(later.ID))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		assert.NoError(t, checkpointRepo.Delete(saved.ID))
+		_, err := checkpointRepo.Get(saved.ID)
+		assert.Equal(t, crawler.ErrCheckpointNotFound, err)
+		// deleting a missing checkpoint is a no-op
+		assert.NoError(t, checkpointRepo.Delete(saved.ID))
+
+		checkpoints, err := checkpointRepo.List()
+		assert.NoError(t, err)
+		assert.Empty(t, checkpoints)
+	})
+}
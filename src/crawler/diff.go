@@ -0,0 +1,186 @@
+package crawler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sjain93/web-crawler-go/src/crawler/instance"
+)
+
+// Changes between two stored crawls, every list is sorted by URL
+type CrawlDiff struct {
+	BaseID   string
+	TargetID string
+	// pages only found by the target crawl
+	Added []string
+	// pages only found by the base crawl
+	Removed []string
+	// pages found by both crawls that responded with a different status
+	StatusChanges []StatusChange
+	// pages broken in the target crawl that were missing or healthy in the base
+	NewBrokenLinks []instance.PageRecord
+	// pages found by both crawls that redirected somewhere else
+	RedirectChanges []RedirectChange
+}
+
+type StatusChange struct {
+	URL    string
+	Before int
+	After  int
+}
+
+type RedirectChange struct {
+	URL    string
+	Before string
+	After  string
+}
+
+// Compares the pages of a base crawl against a later target crawl. Crawls
+// stored before page records were kept only contribute their links, so for
+// those only added and removed pages are reported
+func DiffCrawls(base, target Metadata) CrawlDiff {
+	diff := CrawlDiff{
+		BaseID:          base.ID,
+		TargetID:        target.ID,
+		Added:           []string{},
+		Removed:         []string{},
+		StatusChanges:   []StatusChange{},
+		NewBrokenLinks:  []instance.PageRecord{},
+		RedirectChanges: []RedirectChange{},
+	}
+
+	basePages := pagesByURL(base)
+	targetPages := pagesByURL(target)
+
+	for url, before := range basePages {
+		if _, ok := targetPages[url]; !ok {
+			diff.Removed = append(diff.Removed, url)
+			continue
+		}
+		after := targetPages[url]
+
+		// a zero status means the crawl did not record one
+		if before.StatusCode != 0 && after.StatusCode != 0 && before.StatusCode != after.StatusCode {
+			diff.StatusChanges = append(diff.StatusChanges, StatusChange{
+				URL:    url,
+				Before: before.StatusCode,
+				After:  after.StatusCode,
+			})
+		}
+		if before.RedirectURL != after.RedirectURL {
+			diff.RedirectChanges = append(diff.RedirectChanges, RedirectChange{
+				URL:    url,
+				Before: before.RedirectURL,
+				After:  after.RedirectURL,
+			})
+		}
+	}
+
+	for url, after := range targetPages {
+		before, ok := basePages[url]
+		if !ok {
+			diff.Added = append(diff.Added, url)
+		}
+		if after.IsBroken() && (!ok || !before.IsBroken()) {
+			diff.NewBrokenLinks = append(diff.NewBrokenLinks, after)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Slice(diff.StatusChanges, func(i, j int) bool {
+		return diff.StatusChanges[i].URL < diff.StatusChanges[j].URL
+	})
+	sort.Slice(diff.NewBrokenLinks, func(i, j int) bool {
+		return diff.NewBrokenLinks[i].URL < diff.NewBrokenLinks[j].URL
+	})
+	sort.Slice(diff.RedirectChanges, func(i, j int) bool {
+		return diff.RedirectChanges[i].URL < diff.RedirectChanges[j].URL
+	})
+
+	return diff
+}
+
+// Reports whether the two crawls found the same pages in the same state
+func (d CrawlDiff) Empty() bool {
+	return len(d.Added) == 0 &&
+		len(d.Removed) == 0 &&
+		len(d.StatusChanges) == 0 &&
+		len(d.NewBrokenLinks) == 0 &&
+		len(d.RedirectChanges) == 0
+}
+
+// Renders the diff as a markdown document with one section per kind of change
+func (d CrawlDiff) Markdown() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# Crawl diff\n\n")
+	fmt.Fprintf(&b, "- Base: `%s`\n- Target: `%s`\n", d.BaseID, d.TargetID)
+
+	if d.Empty() {
+		b.WriteString("\nNo changes.\n")
+		return b.String()
+	}
+
+	writeSection := func(title string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n## %s (%d)\n\n", title, len(lines))
+		for _, line := range lines {
+			fmt.Fprintf(&b, "- %s\n", line)
+		}
+	}
+
+	writeSection("Added pages", d.Added)
+	writeSection("Removed pages", d.Removed)
+
+	lines := []string{}
+	for _, c := range d.StatusChanges {
+		lines = append(lines, fmt.Sprintf("%s: %d → %d", c.URL, c.Before, c.After))
+	}
+	writeSection("Status changes", lines)
+
+	lines = []string{}
+	for _, page := range d.NewBrokenLinks {
+		reason := page.Error
+		if reason == "" {
+			reason = fmt.Sprintf("status %d", page.StatusCode)
+		}
+		lines = append(lines, fmt.Sprintf("%s (%s, linked from %s)", page.URL, reason, orNone(page.Parent)))
+	}
+	writeSection("New broken links", lines)
+
+	lines = []string{}
+	for _, c := range d.RedirectChanges {
+		lines = append(lines, fmt.Sprintf("%s: %s → %s", c.URL, orNone(c.Before), orNone(c.After)))
+	}
+	writeSection("Redirect changes", lines)
+
+	return b.String()
+}
+
+// Indexes the pages of a crawl by URL, falling back to the result set for
+// crawls without page records
+func pagesByURL(crawlRec Metadata) map[string]instance.PageRecord {
+	pages := make(map[string]instance.PageRecord)
+	if len(crawlRec.Pages) > 0 {
+		for _, page := range crawlRec.Pages {
+			pages[page.URL] = page
+		}
+		return pages
+	}
+
+	for _, link := range crawlRec.CrawlResultSet {
+		pages[link] = instance.PageRecord{URL: link}
+	}
+	return pages
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
@@ -0,0 +1,162 @@
+package crawler_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sjain93/web-crawler-go/config"
+	"github.com/sjain93/web-crawler-go/src/crawler"
+	"github.com/sjain93/web-crawler-go/src/crawler/instance"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffCrawls(t *testing.T) {
+	base := crawler.Metadata{
+		ID: "base",
+		Pages: []instance.PageRecord{
+			{URL: "https://monzo.com/", StatusCode: http.StatusOK},
+			{URL: "https://monzo.com/isa/", StatusCode: http.StatusOK},
+			{URL: "https://monzo.com/old/", StatusCode: http.StatusOK},
+			{URL: "https://monzo.com/help/", StatusCode: http.StatusOK, RedirectURL: "https://monzo.com/support/"},
+			{URL: "https://monzo.com/gone/", StatusCode: http.StatusNotFound},
+		},
+	}
+
+	testCases := map[string]struct {
+		base     crawler.Metadata
+		target   crawler.Metadata
+		expected crawler.CrawlDiff
+	}{
+		"Identical crawls": {
+			base:   base,
+			target: crawler.Metadata{ID: "target", Pages: base.Pages},
+			expected: crawler.CrawlDiff{
+				BaseID:          "base",
+				TargetID:        "target",
+				Added:           []string{},
+				Removed:         []string{},
+				StatusChanges:   []crawler.StatusChange{},
+				NewBrokenLinks:  []instance.PageRecord{},
+				RedirectChanges: []crawler.RedirectChange{},
+			},
+		},
+		"Every kind of change": {
+			base: base,
+			target: crawler.Metadata{
+				ID: "target",
+				Pages: []instance.PageRecord{
+					{URL: "https://monzo.com/", StatusCode: http.StatusOK},
+					{URL: "https://monzo.com/isa/", StatusCode: http.StatusInternalServerError, Parent: "https://monzo.com/"},
+					{URL: "https://monzo.com/new/", StatusCode: http.StatusOK},
+					{URL: "https://monzo.com/broken/", Error: "connection refused", Parent: "https://monzo.com/"},
+					{URL: "https://monzo.com/help/", StatusCode: http.StatusOK, RedirectURL: "https://monzo.com/faq/"},
+					{URL: "https://monzo.com/gone/", StatusCode: http.StatusNotFound},
+				},
+			},
+			expected: crawler.CrawlDiff{
+				BaseID:   "base",
+				TargetID: "target",
+				Added:    []string{"https://monzo.com/broken/", "https://monzo.com/new/"},
+				Removed:  []string{"https://monzo.com/old/"},
+				StatusChanges: []crawler.StatusChange{
+					{URL: "https://monzo.com/isa/", Before: http.StatusOK, After: http.StatusInternalServerError},
+				},
+				NewBrokenLinks: []instance.PageRecord{
+					{URL: "https://monzo.com/broken/", Error: "connection refused", Parent: "https://monzo.com/"},
+					{URL: "https://monzo.com/isa/", StatusCode: http.StatusInternalServerError, Parent: "https://monzo.com/"},
+				},
+				RedirectChanges: []crawler.RedirectChange{
+					{URL: "https://monzo.com/help/", Before: "https://monzo.com/support/", After: "https://monzo.com/faq/"},
+				},
+			},
+		},
+		"Crawls without page records compare links": {
+			base: crawler.Metadata{
+				ID:             "base",
+				CrawlResultSet: []string{"https://monzo.com/", "https://monzo.com/old/"},
+			},
+			target: crawler.Metadata{
+				ID:             "target",
+				CrawlResultSet: []string{"https://monzo.com/", "https://monzo.com/new/"},
+			},
+			expected: crawler.CrawlDiff{
+				BaseID:          "base",
+				TargetID:        "target",
+				Added:           []string{"https://monzo.com/new/"},
+				Removed:         []string{"https://monzo.com/old/"},
+				StatusChanges:   []crawler.StatusChange{},
+				NewBrokenLinks:  []instance.PageRecord{},
+				RedirectChanges: []crawler.RedirectChange{},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, crawler.DiffCrawls(tc.base, tc.target))
+		})
+	}
+
+	t.Run("Markdown", func(t *testing.T) {
+		diff := crawler.DiffCrawls(base, crawler.Metadata{
+			ID: "target",
+			Pages: []instance.PageRecord{
+				{URL: "https://monzo.com/", StatusCode: http.StatusOK},
+				{URL: "https://monzo.com/isa/", StatusCode: http.StatusNotFound, Parent: "https://monzo.com/"},
+			},
+		})
+		md := diff.Markdown()
+		assert.True(t, strings.HasPrefix(md, "# Crawl diff\n"))
+		assert.Contains(t, md, "## Removed pages (3)")
+		assert.Contains(t, md, "- https://monzo.com/isa/: 200 → 404")
+		assert.Contains(t, md, "- https://monzo.com/isa/ (status 404, linked from https://monzo.com/)")
+		assert.NotContains(t, md, "## Added pages")
+
+		assert.Contains(t, crawler.DiffCrawls(base, base).Markdown(), "No changes.")
+	})
+}
+
+func TestServiceDiffCrawls(t *testing.T) {
+	inMemDB := config.GetInMemoryStore[crawler.Metadata]()
+	preLoad(inMemDB,
+		crawler.Metadata{ID: "5eb020a4-54cc-4b57-b19f-cbd33a2df881", CrawlResultSet: []string{"https://monzo.com/"}},
+		crawler.Metadata{ID: "ff4f7d87-3a0c-4b2b-9c5c-7cb1c6b7f4a1", CrawlResultSet: []string{"https://monzo.com/", "https://monzo.com/isa/"}},
+	)
+	crawlerRepo, err := crawler.NewCrawlerRepository(inMemDB)
+	assert.NoError(t, err)
+
+	crawlerSvc, err := crawler.NewCrawlerService(crawler.WithRepository(crawlerRepo))
+	assert.NoError(t, err)
+
+	testCases := map[string]struct {
+		idA           string
+		idB           string
+		expectedErr   error
+		expectedAdded []string
+	}{
+		"Happy Path - diffs stored crawls": {
+			idA:           "5eb020a4-54cc-4b57-b19f-cbd33a2df881",
+			idB:           "ff4f7d87-3a0c-4b2b-9c5c-7cb1c6b7f4a1",
+			expectedAdded: []string{"https://monzo.com/isa/"},
+		},
+		"Error - unknown crawl": {
+			idA:         "5eb020a4-54cc-4b57-b19f-cbd33a2df881",
+			idB:         "00000000-0000-0000-0000-000000000000",
+			expectedErr: crawler.ErrSvcRecordNotFound,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			diff, err := crawlerSvc.DiffCrawls(tc.idA, tc.idB)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.idA, diff.BaseID)
+			assert.Equal(t, tc.expectedAdded, diff.Added)
+		})
+	}
+}
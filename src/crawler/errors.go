@@ -0,0 +1,124 @@
+package crawler
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnavailable is matched by errors from a datastore that could not be
+// reached, or a call whose context ended first
+var ErrUnavailable = errors.New("datastore unavailable")
+
+// Classifies the errors returned by repositories, so callers can react to
+// them the same way whichever backend is used
+type ErrorKind int
+
+const (
+	// anything not covered below, e.g. a stored crawl that can't be decoded
+	KindInternal ErrorKind = iota
+	KindNotFound
+	KindConflict
+	KindInvalid
+	KindUnavailable
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindNotFound:
+		return "not found"
+	case KindConflict:
+		return "conflict"
+	case KindInvalid:
+		return "invalid"
+	case KindUnavailable:
+		return "unavailable"
+	default:
+		return "internal"
+	}
+}
+
+// RepoError is the error returned by every repository method. errors.Is
+// matches the sentinel of its kind (ErrRecordNotFound, ErrUniqueKeyViolated
+// or ErrUnavailable) as well as anything it wraps, errors.As gives access to
+// the kind
+type RepoError struct {
+	Kind ErrorKind
+	// the repository method that failed
+	Op string
+	// the crawl the call was about, empty for calls over every crawl
+	ID  string
+	Err error
+}
+
+func (e *RepoError) Error() string {
+	if e.ID != "" {
+		return fmt.Sprintf("%v crawl %v: %v", e.Op, e.ID, e.Err)
+	}
+	return fmt.Sprintf("%v: %v", e.Op, e.Err)
+}
+
+func (e *RepoError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RepoError) Is(target error) bool {
+	switch e.Kind {
+	case KindNotFound:
+		return target == ErrRecordNotFound
+	case KindConflict:
+		return target == ErrUniqueKeyViolated
+	case KindUnavailable:
+		return target == ErrUnavailable
+	default:
+		return false
+	}
+}
+
+// Returns the kind of a repository error, errors that don't come from a
+// repository are internal
+func KindOf(err error) ErrorKind {
+	var repoErr *RepoError
+	if errors.As(err, &repoErr) {
+		return repoErr.Kind
+	}
+	return KindInternal
+}
+
+func newRepoError(kind ErrorKind, op, id string, err error) error {
+	return &RepoError{Kind: kind, Op: op, ID: id, Err: err}
+}
+
+func notFoundError(op, id string) error {
+	return newRepoError(KindNotFound, op, id, ErrRecordNotFound)
+}
+
+func conflictError(op, id string) error {
+	return newRepoError(KindConflict, op, id, ErrUniqueKeyViolated)
+}
+
+// Returns an unavailable error once the context is done, checked before a
+// call touches the datastore
+func contextError(ctx context.Context, op, id string) error {
+	if err := ctx.Err(); err != nil {
+		return newRepoError(KindUnavailable, op, id, err)
+	}
+	return nil
+}
+
+// Classifies an error from database/sql, errors reaching the server or
+// running out of time are unavailable and everything else is internal
+func sqlError(op, id string, err error) error {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone), errors.As(err, &netErr):
+		return newRepoError(KindUnavailable, op, id, err)
+	default:
+		return newRepoError(KindInternal, op, id, err)
+	}
+}
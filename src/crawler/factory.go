@@ -0,0 +1,28 @@
+package crawler
+
+import "github.com/sjain93/web-crawler-go/src/crawler/instance"
+
+// Public interface used by the service to construct crawler instances, it can
+// be swapped out so that the service runs without touching the network
+type CrawlerFactory interface {
+	NewCrawler(initialURL string, cfg instance.Config) (instance.CrawlerIManager, error)
+	NewCrawlerFromState(state instance.State, cfg instance.Config) (instance.CrawlerIManager, error)
+}
+
+// Builds crawlers from the instance package, used unless another factory is
+// provided to the service
+type instanceFactory struct{}
+
+func (instanceFactory) NewCrawler(
+	initialURL string,
+	cfg instance.Config,
+) (instance.CrawlerIManager, error) {
+	return instance.NewCrawler(initialURL, cfg)
+}
+
+func (instanceFactory) NewCrawlerFromState(
+	state instance.State,
+	cfg instance.Config,
+) (instance.CrawlerIManager, error) {
+	return instance.NewCrawlerFromState(state, cfg)
+}
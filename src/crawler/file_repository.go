@@ -0,0 +1,436 @@
+package crawler
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/sjain93/web-crawler-go/src/util"
+)
+
+const (
+	// file listing every stored crawl, read once when the repository opens
+	fileIndexName = "index.json"
+	// directory holding one JSON file per crawl
+	fileCrawlsDir = "crawls"
+	// number of decoded crawl files kept in memory
+	fileCacheSize = 32
+)
+
+// The fields of a crawl kept in the index, enough to answer host lookups
+// without reading every crawl file
+type fileIndexEntry struct {
+	ID        string
+	Host      string
+	CreatedAt time.Time
+}
+
+// A crawl as written to disk. Errors don't survive a JSON round trip, so
+// they are stored as their messages
+type storedCrawl struct {
+	Metadata
+	ErrList []string
+}
+
+func newStoredCrawl(crawlRec Metadata) storedCrawl {
+	stored := storedCrawl{Metadata: crawlRec, ErrList: []string{}}
+	for _, err := range crawlRec.ErrList {
+		stored.ErrList = append(stored.ErrList, err.Error())
+	}
+	return stored
+}
+
+func (c storedCrawl) metadata() Metadata {
+	crawlRec := c.Metadata
+	crawlRec.ErrList = []error{}
+	for _, msg := range c.ErrList {
+		crawlRec.ErrList = append(crawlRec.ErrList, errors.New(msg))
+	}
+	return crawlRec
+}
+
+// Decoded crawl files, the least recently used crawl is dropped once the cache
+// is full
+type crawlCache struct {
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+func newCrawlCache(size int) *crawlCache {
+	return &crawlCache{size: size, order: list.New(), items: map[string]*list.Element{}}
+}
+
+func (c *crawlCache) get(id string) (Metadata, bool) {
+	elem, ok := c.items[id]
+	if !ok {
+		return Metadata{}, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(Metadata).clone(), true
+}
+
+func (c *crawlCache) add(crawlRec Metadata) {
+	if elem, ok := c.items[crawlRec.ID]; ok {
+		elem.Value = crawlRec.clone()
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[crawlRec.ID] = c.order.PushFront(crawlRec.clone())
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(Metadata).ID)
+	}
+}
+
+func (c *crawlCache) remove(id string) {
+	if elem, ok := c.items[id]; ok {
+		c.order.Remove(elem)
+		delete(c.items, id)
+	}
+}
+
+// FileRepository persists crawls to a local directory so they survive
+// restarts. Only the index is read up front, crawl files are loaded when they
+// are asked for and the most recently used ones are kept in memory. Crawl IDs
+// must be uuids, as each crawl file is named after its ID
+type FileRepository struct {
+	dir string
+
+	mu      sync.Mutex
+	entries map[string]fileIndexEntry
+	hosts   hostIndex
+	loaded  *crawlCache
+	// built from every crawl file on the first search
+	urls *urlIndex
+}
+
+// Create a new file repository, the directory is created if needed. A missing
+// index is rebuilt from the crawl files
+func NewFileRepository(dir string) (CrawlerRepoManager, error) {
+	if err := os.MkdirAll(filepath.Join(dir, fileCrawlsDir), 0o755); err != nil {
+		return &FileRepository{}, err
+	}
+
+	r := &FileRepository{
+		dir:     dir,
+		entries: map[string]fileIndexEntry{},
+		hosts:   hostIndex{},
+		loaded:  newCrawlCache(fileCacheSize),
+	}
+
+	entries, err := r.readIndex()
+	if err != nil {
+		return &FileRepository{}, err
+	}
+	for _, entry := range entries {
+		if !validID(entry.ID) {
+			return &FileRepository{}, errors.Wrapf(ErrInvalidDataType, "index lists crawl %q", entry.ID)
+		}
+		r.entries[entry.ID] = entry
+		r.hosts.add(Metadata{ID: entry.ID, Host: entry.Host, CreatedAt: entry.CreatedAt})
+	}
+
+	return r, nil
+}
+
+// Writes the crawl file and then the index, each atomically
+func (r *FileRepository) Save(ctx context.Context, crawlRec *Metadata) error {
+	if err := contextError(ctx, "save", crawlRec.ID); err != nil {
+		return err
+	}
+	if !validID(crawlRec.ID) {
+		return newRepoError(KindInvalid, "save", crawlRec.ID, ErrInvalidID)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.entries[crawlRec.ID]; ok {
+		return conflictError("save", crawlRec.ID)
+	}
+	createdAt := creationTime(*crawlRec)
+
+	stored := newStoredCrawl(*crawlRec)
+	stored.CreatedAt = createdAt
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return newRepoError(KindInvalid, "save", crawlRec.ID, err)
+	}
+	if err = util.WriteFileAtomic(r.crawlPath(crawlRec.ID), data); err != nil {
+		return newRepoError(KindUnavailable, "save", crawlRec.ID, err)
+	}
+
+	entry := fileIndexEntry{ID: crawlRec.ID, Host: crawlRec.Host, CreatedAt: createdAt}
+	r.entries[entry.ID] = entry
+	if err = r.writeIndex(); err != nil {
+		// the crawl file isn't listed anywhere, a rebuilt index would pick
+		// it up as a saved crawl
+		delete(r.entries, entry.ID)
+		_ = os.Remove(r.crawlPath(crawlRec.ID))
+		return newRepoError(KindUnavailable, "save", crawlRec.ID, err)
+	}
+
+	crawlRec.CreatedAt = createdAt
+	r.hosts.add(*crawlRec)
+	r.loaded.add(*crawlRec)
+	if r.urls != nil {
+		r.urls.add(*crawlRec)
+	}
+
+	return nil
+}
+
+func (r *FileRepository) GetCrawlByID(ctx context.Context, id string) (Metadata, error) {
+	if err := contextError(ctx, "get", id); err != nil {
+		return Metadata{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok, err := r.load(id)
+	if err != nil {
+		return Metadata{}, err
+	}
+	if !ok {
+		return Metadata{}, notFoundError("get", id)
+	}
+
+	return stored, nil
+}
+
+// Returns every stored crawl, loading any crawl files not read yet
+func (r *FileRepository) GetCrawlHistory(ctx context.Context) ([]Metadata, error) {
+	if err := contextError(ctx, "history", ""); err != nil {
+		return []Metadata{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	crawls := make([]Metadata, 0, len(r.entries))
+	for id := range r.entries {
+		crawlRec, ok, err := r.load(id)
+		if err != nil {
+			return []Metadata{}, err
+		}
+		if ok {
+			crawls = append(crawls, crawlRec)
+		}
+	}
+
+	return crawls, nil
+}
+
+// Lists every stored crawl from the index, without reading any crawl file
+func (r *FileRepository) ListCrawls(ctx context.Context) ([]CrawlRef, error) {
+	if err := contextError(ctx, "list", ""); err != nil {
+		return []CrawlRef{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	crawls := make([]CrawlRef, 0, len(r.entries))
+	for _, entry := range r.entries {
+		crawls = append(crawls, CrawlRef{ID: entry.ID, Host: entry.Host, CreatedAt: entry.CreatedAt})
+	}
+
+	return crawls, nil
+}
+
+// Returns the crawls of a host, most recent first
+func (r *FileRepository) GetCrawlsByHost(ctx context.Context, host string) ([]Metadata, error) {
+	if err := contextError(ctx, "get by host", ""); err != nil {
+		return []Metadata{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries := r.hosts[host]
+	crawls := make([]Metadata, 0, len(entries))
+	for _, entry := range entries {
+		stored, ok, err := r.load(entry.id)
+		if err != nil {
+			return []Metadata{}, err
+		}
+		if ok {
+			crawls = append(crawls, stored)
+		}
+	}
+
+	return crawls, nil
+}
+
+// Removes the crawl from the index before its file, so the index never lists
+// a crawl without a file
+func (r *FileRepository) Delete(ctx context.Context, id string) error {
+	if err := contextError(ctx, "delete", id); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[id]
+	if !ok {
+		return notFoundError("delete", id)
+	}
+	// the URLs of the crawl are needed to take it out of the URL index
+	var crawlRec Metadata
+	if r.urls != nil {
+		var err error
+		if crawlRec, _, err = r.load(id); err != nil {
+			return err
+		}
+	}
+
+	delete(r.entries, id)
+	if err := r.writeIndex(); err != nil {
+		r.entries[id] = entry
+		return newRepoError(KindUnavailable, "delete", id, err)
+	}
+
+	r.hosts.remove(Metadata{ID: id, Host: entry.Host})
+	if r.urls != nil {
+		r.urls.remove(crawlRec)
+	}
+	r.loaded.remove(id)
+
+	err := os.Remove(r.crawlPath(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return newRepoError(KindUnavailable, "delete", id, err)
+	}
+	return nil
+}
+
+// Searches the URLs of every stored crawl, the first search loads every crawl
+// file to build the URL index
+func (r *FileRepository) SearchPages(ctx context.Context, q PageSearch) ([]PageMatch, error) {
+	if err := contextError(ctx, "search", ""); err != nil {
+		return []PageMatch{}, err
+	}
+	match, err := newURLMatcher(q)
+	if err != nil {
+		return []PageMatch{}, newRepoError(KindInvalid, "search", "", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.urls == nil {
+		urls := newURLIndex()
+		for id := range r.entries {
+			crawlRec, ok, err := r.load(id)
+			if err != nil {
+				return []PageMatch{}, err
+			}
+			if ok {
+				urls.add(crawlRec)
+			}
+		}
+		r.urls = urls
+	}
+
+	return collectMatches(r.urls, match, r.load)
+}
+
+// Returns a crawl listed in the index, reading its file unless it is cached.
+// Must be called with the lock held
+func (r *FileRepository) load(id string) (Metadata, bool, error) {
+	if _, ok := r.entries[id]; !ok {
+		return Metadata{}, false, nil
+	}
+	if crawlRec, ok := r.loaded.get(id); ok {
+		return crawlRec, true, nil
+	}
+
+	data, err := os.ReadFile(r.crawlPath(id))
+	if err != nil {
+		return Metadata{}, false, newRepoError(KindUnavailable, "load", id, err)
+	}
+	var stored storedCrawl
+	if err = json.Unmarshal(data, &stored); err != nil {
+		return Metadata{}, false, newRepoError(
+			KindInternal, "load", id, errors.Wrap(ErrInvalidDataType, err.Error()),
+		)
+	}
+
+	crawlRec := stored.metadata()
+	r.loaded.add(crawlRec)
+	return crawlRec, true, nil
+}
+
+// Reads the index file, or rebuilds it from the crawl files when it is missing
+func (r *FileRepository) readIndex() ([]fileIndexEntry, error) {
+	var entries []fileIndexEntry
+
+	data, err := os.ReadFile(filepath.Join(r.dir, fileIndexName))
+	if err == nil {
+		err = json.Unmarshal(data, &entries)
+		return entries, err
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return entries, err
+	}
+
+	files, err := os.ReadDir(filepath.Join(r.dir, fileCrawlsDir))
+	if err != nil {
+		return entries, err
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(r.dir, fileCrawlsDir, file.Name()))
+		if err != nil {
+			return entries, err
+		}
+		var stored storedCrawl
+		if err = json.Unmarshal(data, &stored); err != nil {
+			return entries, errors.Wrapf(ErrInvalidDataType, "%v: %v", file.Name(), err.Error())
+		}
+		entries = append(entries, fileIndexEntry{
+			ID:        stored.ID,
+			Host:      stored.Host,
+			CreatedAt: stored.CreatedAt,
+		})
+	}
+
+	return entries, nil
+}
+
+// Must be called with the lock held
+func (r *FileRepository) writeIndex() error {
+	entries := make([]fileIndexEntry, 0, len(r.entries))
+	for _, entry := range r.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(filepath.Join(r.dir, fileIndexName), data)
+}
+
+// IDs are validated before they are listed in the index, so the path can't
+// point outside the crawls directory
+func (r *FileRepository) crawlPath(id string) string {
+	return filepath.Join(r.dir, fileCrawlsDir, id+".json")
+}
@@ -0,0 +1,161 @@
+package crawler_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sjain93/web-crawler-go/src/crawler"
+	"github.com/sjain93/web-crawler-go/src/crawler/repotest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileRepository(t *testing.T) {
+	dir := t.TempDir()
+	monzo1 := "6f1c2b7e-8a3d-4c5f-9e0b-1d2a3c4b5e6f"
+	monzo2 := "2a9e4d1c-7b6f-4e3a-8d5c-0f9e8d7c6b5a"
+	koho1 := "c3b2a190-5e4d-4f6c-a7b8-9d0e1f2a3b4c"
+
+	crawlerRepo, err := crawler.NewFileRepository(dir)
+	assert.NoError(t, err)
+
+	crawls := []crawler.Metadata{
+		{
+			ID:             monzo1,
+			InitialURL:     "https://monzo.com/",
+			Host:           "monzo.com",
+			CrawlResultSet: []string{"https://monzo.com/", "https://monzo.com/isa/"},
+			ErrList:        []error{errors.New("timeout fetching https://monzo.com/help/")},
+		},
+		{
+			ID:             monzo2,
+			InitialURL:     "https://monzo.com/",
+			Host:           "monzo.com",
+			CrawlResultSet: []string{"https://monzo.com/", "https://monzo.com/pricing/"},
+		},
+		{
+			ID:             koho1,
+			InitialURL:     "https://www.koho.ca/",
+			Host:           "www.koho.ca",
+			CrawlResultSet: []string{"https://www.koho.ca/"},
+		},
+	}
+	for i := range crawls {
+		assert.NoError(t, crawlerRepo.Save(context.Background(), &crawls[i]))
+	}
+
+	t.Run("Duplicate ID", func(t *testing.T) {
+		dup := crawler.Metadata{ID: monzo1, Host: "monzo.com"}
+		assert.ErrorIs(t, crawlerRepo.Save(context.Background(), &dup), crawler.ErrUniqueKeyViolated)
+	})
+
+	t.Run("Crawls survive a restart", func(t *testing.T) {
+		reopened, err := crawler.NewFileRepository(dir)
+		assert.NoError(t, err)
+
+		crawlRec, err := reopened.GetCrawlByID(context.Background(), monzo1)
+		assert.NoError(t, err)
+		assert.Equal(t, crawls[0].CrawlResultSet, crawlRec.CrawlResultSet)
+		assert.True(t, crawls[0].CreatedAt.Equal(crawlRec.CreatedAt))
+		assert.Len(t, crawlRec.ErrList, 1)
+		assert.EqualError(t, crawlRec.ErrList[0], "timeout fetching https://monzo.com/help/")
+
+		byHost, err := reopened.GetCrawlsByHost(context.Background(), "monzo.com")
+		assert.NoError(t, err)
+		assert.Len(t, byHost, 2)
+		assert.Equal(t, monzo2, byHost[0].ID)
+		assert.Equal(t, monzo1, byHost[1].ID)
+
+		history, err := reopened.GetCrawlHistory(context.Background())
+		assert.NoError(t, err)
+		assert.Len(t, history, 3)
+	})
+
+	t.Run("Missing index is rebuilt", func(t *testing.T) {
+		assert.NoError(t, os.Remove(filepath.Join(dir, "index.json")))
+
+		reopened, err := crawler.NewFileRepository(dir)
+		assert.NoError(t, err)
+
+		history, err := reopened.GetCrawlHistory(context.Background())
+		assert.NoError(t, err)
+		assert.Len(t, history, 3)
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		matches, err := crawlerRepo.SearchPages(context.Background(), crawler.PageSearch{
+			Mode:    crawler.SearchPrefix,
+			Pattern: "https://monzo.com/",
+		})
+		assert.NoError(t, err)
+		assert.Len(t, matches, 4)
+	})
+
+	t.Run("Delete removes the crawl file", func(t *testing.T) {
+		assert.NoError(t, crawlerRepo.Delete(context.Background(), koho1))
+		assert.ErrorIs(t, crawlerRepo.Delete(context.Background(), koho1), crawler.ErrRecordNotFound)
+
+		_, err := os.Stat(filepath.Join(dir, "crawls", koho1+".json"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+
+		reopened, err := crawler.NewFileRepository(dir)
+		assert.NoError(t, err)
+		_, err = reopened.GetCrawlByID(context.Background(), koho1)
+		assert.ErrorIs(t, err, crawler.ErrRecordNotFound)
+	})
+
+	t.Run("Service", func(t *testing.T) {
+		crawlerSvc, err := crawler.NewCrawlerService(crawler.WithRepository(crawlerRepo))
+		assert.NoError(t, err)
+
+		crawlRecs, err := crawlerSvc.GetCrawl(monzo2)
+		assert.NoError(t, err)
+		assert.Len(t, crawlRecs, 1)
+		assert.Equal(t, "monzo.com", crawlRecs[0].Host)
+	})
+}
+
+func TestFileRepositoryIDs(t *testing.T) {
+	dir := t.TempDir()
+	crawlerRepo, err := crawler.NewFileRepository(dir)
+	assert.NoError(t, err)
+
+	t.Run("IDs must be uuids", func(t *testing.T) {
+		for _, id := range []string{"", "monzo-1", "../monzo", "a/6f1c2b7e-8a3d-4c5f-9e0b-1d2a3c4b5e6f"} {
+			crawlRec := crawler.Metadata{ID: id, Host: "monzo.com"}
+			err := crawlerRepo.Save(context.Background(), &crawlRec)
+			assert.ErrorIs(t, err, crawler.ErrInvalidID, id)
+			assert.Equal(t, crawler.KindInvalid, crawler.KindOf(err), id)
+		}
+
+		files, err := os.ReadDir(filepath.Join(dir, "crawls"))
+		assert.NoError(t, err)
+		assert.Empty(t, files)
+	})
+
+	t.Run("Failed index write leaves no crawl file", func(t *testing.T) {
+		// the index can't be replaced by a file while it is a directory
+		assert.NoError(t, os.Mkdir(filepath.Join(dir, "index.json"), 0o755))
+		defer os.Remove(filepath.Join(dir, "index.json"))
+
+		crawlRec := crawler.Metadata{ID: "6f1c2b7e-8a3d-4c5f-9e0b-1d2a3c4b5e6f", Host: "monzo.com"}
+		err := crawlerRepo.Save(context.Background(), &crawlRec)
+		assert.ErrorIs(t, err, crawler.ErrUnavailable)
+
+		files, err := os.ReadDir(filepath.Join(dir, "crawls"))
+		assert.NoError(t, err)
+		assert.Empty(t, files)
+		_, err = crawlerRepo.GetCrawlByID(context.Background(), crawlRec.ID)
+		assert.ErrorIs(t, err, crawler.ErrRecordNotFound)
+	})
+}
+
+func TestFileRepositoryConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) crawler.CrawlerRepoManager {
+		crawlerRepo, err := crawler.NewFileRepository(t.TempDir())
+		assert.NoError(t, err)
+		return crawlerRepo
+	})
+}
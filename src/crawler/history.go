@@ -0,0 +1,287 @@
+package crawler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Outcome of a stored crawl, only crawls that ran to the end are stored
+type CrawlStatus string
+
+const (
+	// every page was fetched without an error
+	CrawlComplete CrawlStatus = "complete"
+	// the crawl finished but some pages could not be fetched
+	CrawlPartial CrawlStatus = "partial"
+)
+
+func (m Metadata) Status() CrawlStatus {
+	if len(m.ErrList) > 0 {
+		return CrawlPartial
+	}
+	return CrawlComplete
+}
+
+type CrawlSortField string
+
+const (
+	SortByCreatedAt CrawlSortField = "created_at"
+	SortByHost      CrawlSortField = "host"
+	SortByPageCount CrawlSortField = "page_count"
+)
+
+// Filters, order and page of a crawl history query, zero values match every
+// crawl and return them newest first. Pages are selected with either an
+// offset or the cursor of the previous page, not both. A cursor is refused by
+// a query sorted on another field or in the other direction
+type CrawlQuery struct {
+	Host string
+	// only crawls created at or after From and before To
+	From time.Time
+	To   time.Time
+	// empty matches every status
+	Status CrawlStatus
+	// defaults to the creation time, ties are broken on the crawl ID
+	SortBy    CrawlSortField
+	Ascending bool
+	// zero returns every matching crawl
+	Limit  int
+	Offset int
+	Cursor string
+	// leaves the full records out of the result, only summaries are returned
+	SummaryOnly bool
+}
+
+// The size of a stored crawl without its link set or page records
+type CrawlSummary struct {
+	ID         string
+	InitialURL string
+	Host       string
+	CreatedAt  time.Time
+	Status     CrawlStatus
+	PageCount  int
+	ErrorCount int
+	Options    CrawlOptions
+}
+
+// A page of a crawl history query. Crawls is only filled in when the query
+// asks for full records, and NextCursor is empty on the last page
+type CrawlHistoryPage struct {
+	Summaries  []CrawlSummary
+	Crawls     []Metadata
+	Total      int
+	NextCursor string
+}
+
+// Position of the last crawl on a page and the order it was taken in,
+// encoded into an opaque cursor
+type historyCursor struct {
+	SortBy    CrawlSortField
+	Ascending bool
+	Key       string
+	ID        string
+}
+
+func (m Metadata) Summary() CrawlSummary {
+	pageCount := len(m.CrawlResultSet)
+	if len(m.Pages) > 0 {
+		pageCount = len(m.Pages)
+	}
+
+	return CrawlSummary{
+		ID:         m.ID,
+		InitialURL: m.InitialURL,
+		Host:       m.Host,
+		CreatedAt:  m.CreatedAt,
+		Status:     m.Status(),
+		PageCount:  pageCount,
+		ErrorCount: len(m.ErrList),
+		Options:    m.Options,
+	}
+}
+
+// This service method returns a page of stored crawls matching the query
+func (s *crawlerService) QueryCrawls(q CrawlQuery) (CrawlHistoryPage, error) {
+	if err := q.validate(); err != nil {
+		return CrawlHistoryPage{}, err
+	}
+
+	var (
+		crawls []Metadata
+		err    error
+	)
+	if q.Host != "" {
+		crawls, err = s.crawlerRepo.GetCrawlsByHost(context.Background(), q.Host)
+	} else {
+		crawls, err = s.crawlerRepo.GetCrawlHistory(context.Background())
+	}
+	if err != nil {
+		return CrawlHistoryPage{}, err
+	}
+
+	matched := []Metadata{}
+	for _, crawlRec := range crawls {
+		if q.matches(crawlRec) {
+			matched = append(matched, crawlRec)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return q.before(matched[i], matched[j])
+	})
+
+	start := q.Offset
+	if q.Cursor != "" {
+		cursor, err := q.decodeCursor(q.Cursor)
+		if err != nil {
+			return CrawlHistoryPage{}, err
+		}
+		// the first crawl ordered after the cursor, which still works when
+		// the crawl the cursor points at has been deleted since
+		start = sort.Search(len(matched), func(i int) bool {
+			return q.afterCursor(matched[i], cursor)
+		})
+	}
+	if start > len(matched) {
+		start = len(matched)
+	}
+
+	end := len(matched)
+	if q.Limit > 0 && start+q.Limit < end {
+		end = start + q.Limit
+	}
+
+	page := CrawlHistoryPage{
+		Summaries: []CrawlSummary{},
+		Total:     len(matched),
+	}
+	if !q.SummaryOnly {
+		page.Crawls = []Metadata{}
+	}
+	for _, crawlRec := range matched[start:end] {
+		page.Summaries = append(page.Summaries, crawlRec.Summary())
+		if !q.SummaryOnly {
+			page.Crawls = append(page.Crawls, crawlRec)
+		}
+	}
+
+	if end < len(matched) && end > start {
+		page.NextCursor = encodeCursor(q.position(matched[end-1]))
+	}
+
+	return page, nil
+}
+
+func (q CrawlQuery) validate() error {
+	if q.Limit < 0 || q.Offset < 0 {
+		return ErrSvcInvalidQuery
+	}
+	if q.Offset > 0 && q.Cursor != "" {
+		return ErrSvcInvalidQuery
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		return ErrSvcInvalidQuery
+	}
+	switch q.Status {
+	case "", CrawlComplete, CrawlPartial:
+	default:
+		return ErrSvcInvalidQuery
+	}
+	switch q.SortBy {
+	case "", SortByCreatedAt, SortByHost, SortByPageCount:
+	default:
+		return ErrSvcInvalidQuery
+	}
+	return nil
+}
+
+func (q CrawlQuery) matches(crawlRec Metadata) bool {
+	if q.Host != "" && crawlRec.Host != q.Host {
+		return false
+	}
+	if !q.From.IsZero() && crawlRec.CreatedAt.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !crawlRec.CreatedAt.Before(q.To) {
+		return false
+	}
+	if q.Status != "" && crawlRec.Status() != q.Status {
+		return false
+	}
+	return true
+}
+
+func (q CrawlQuery) sortField() CrawlSortField {
+	if q.SortBy == "" {
+		return SortByCreatedAt
+	}
+	return q.SortBy
+}
+
+// Sort keys compare as strings, so times and counts are fixed width
+func (q CrawlQuery) sortKey(crawlRec Metadata) string {
+	switch q.sortField() {
+	case SortByHost:
+		return crawlRec.Host
+	case SortByPageCount:
+		return fmt.Sprintf("%010d", crawlRec.Summary().PageCount)
+	default:
+		return crawlRec.CreatedAt.UTC().Format("2006-01-02T15:04:05.000000000")
+	}
+}
+
+// The position of a crawl in the query results
+func (q CrawlQuery) position(crawlRec Metadata) historyCursor {
+	return historyCursor{
+		SortBy:    q.sortField(),
+		Ascending: q.Ascending,
+		Key:       q.sortKey(crawlRec),
+		ID:        crawlRec.ID,
+	}
+}
+
+// Reports whether a crawl is ordered before another in the query results
+func (q CrawlQuery) before(a, b Metadata) bool {
+	return q.less(q.position(a), q.position(b))
+}
+
+func (q CrawlQuery) afterCursor(crawlRec Metadata, cursor historyCursor) bool {
+	return q.less(cursor, q.position(crawlRec))
+}
+
+func (q CrawlQuery) less(a, b historyCursor) bool {
+	x, y := a.Key, b.Key
+	if x == y {
+		x, y = a.ID, b.ID
+	}
+	if q.Ascending {
+		return x < y
+	}
+	return x > y
+}
+
+func encodeCursor(c historyCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// The key of a cursor taken in another order can't be compared with the keys
+// of this query, so such cursors are refused rather than skipping crawls
+func (q CrawlQuery) decodeCursor(raw string) (historyCursor, error) {
+	var c historyCursor
+
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return c, ErrSvcInvalidCursor
+	}
+	if err = json.Unmarshal(b, &c); err != nil || c.ID == "" {
+		return c, ErrSvcInvalidCursor
+	}
+	if c.SortBy != q.sortField() || c.Ascending != q.Ascending {
+		return c, ErrSvcInvalidCursor
+	}
+	return c, nil
+}
@@ -0,0 +1,187 @@
+package crawler_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sjain93/web-crawler-go/config"
+	"github.com/sjain93/web-crawler-go/src/crawler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryCrawls(t *testing.T) {
+	now := time.Date(2023, time.March, 15, 12, 0, 0, 0, time.UTC)
+
+	inMemDB := config.GetInMemoryStore[crawler.Metadata]()
+	preLoad(inMemDB,
+		crawler.Metadata{
+			ID:             "monzo-1",
+			Host:           "monzo.com",
+			CrawlResultSet: []string{"https://monzo.com/"},
+			CreatedAt:      now.Add(-3 * time.Hour),
+		},
+		crawler.Metadata{
+			ID:             "monzo-2",
+			Host:           "monzo.com",
+			CrawlResultSet: []string{"https://monzo.com/", "https://monzo.com/isa/", "https://monzo.com/blog/"},
+			ErrList:        []error{errors.New("error fetching page: https://monzo.com/help/")},
+			CreatedAt:      now.Add(-2 * time.Hour),
+		},
+		crawler.Metadata{
+			ID:             "koho-1",
+			Host:           "www.koho.ca",
+			CrawlResultSet: []string{"https://www.koho.ca/", "https://www.koho.ca/save/"},
+			CreatedAt:      now.Add(-time.Hour),
+		},
+		crawler.Metadata{
+			ID:             "spacy-1",
+			Host:           "spacy.io",
+			CrawlResultSet: []string{},
+			CreatedAt:      now,
+		},
+	)
+	crawlerRepo, err := crawler.NewCrawlerRepository(inMemDB)
+	assert.NoError(t, err)
+
+	crawlerSvc, err := crawler.NewCrawlerService(crawler.WithRepository(crawlerRepo))
+	assert.NoError(t, err)
+
+	ids := func(page crawler.CrawlHistoryPage) []string {
+		res := []string{}
+		for _, summary := range page.Summaries {
+			res = append(res, summary.ID)
+		}
+		return res
+	}
+
+	testCases := map[string]struct {
+		query         crawler.CrawlQuery
+		expectedIDs   []string
+		expectedTotal int
+		expectedErr   error
+	}{
+		"Newest first by default": {
+			query:         crawler.CrawlQuery{},
+			expectedIDs:   []string{"spacy-1", "koho-1", "monzo-2", "monzo-1"},
+			expectedTotal: 4,
+		},
+		"Host": {
+			query:         crawler.CrawlQuery{Host: "monzo.com"},
+			expectedIDs:   []string{"monzo-2", "monzo-1"},
+			expectedTotal: 2,
+		},
+		"Date range": {
+			query:         crawler.CrawlQuery{From: now.Add(-2 * time.Hour), To: now},
+			expectedIDs:   []string{"koho-1", "monzo-2"},
+			expectedTotal: 2,
+		},
+		"Status": {
+			query:         crawler.CrawlQuery{Status: crawler.CrawlPartial},
+			expectedIDs:   []string{"monzo-2"},
+			expectedTotal: 1,
+		},
+		"Sorted by page count": {
+			query:         crawler.CrawlQuery{SortBy: crawler.SortByPageCount, Ascending: true},
+			expectedIDs:   []string{"spacy-1", "monzo-1", "koho-1", "monzo-2"},
+			expectedTotal: 4,
+		},
+		"Sorted by host": {
+			query:         crawler.CrawlQuery{SortBy: crawler.SortByHost, Ascending: true},
+			expectedIDs:   []string{"monzo-1", "monzo-2", "spacy-1", "koho-1"},
+			expectedTotal: 4,
+		},
+		"Limit and offset": {
+			query:         crawler.CrawlQuery{Limit: 2, Offset: 1},
+			expectedIDs:   []string{"koho-1", "monzo-2"},
+			expectedTotal: 4,
+		},
+		"Offset past the end": {
+			query:         crawler.CrawlQuery{Offset: 10},
+			expectedIDs:   []string{},
+			expectedTotal: 4,
+		},
+		"Negative limit": {
+			query:       crawler.CrawlQuery{Limit: -1},
+			expectedErr: crawler.ErrSvcInvalidQuery,
+		},
+		"Offset and cursor": {
+			query:       crawler.CrawlQuery{Offset: 1, Cursor: "abc"},
+			expectedErr: crawler.ErrSvcInvalidQuery,
+		},
+		"Empty date range": {
+			query:       crawler.CrawlQuery{From: now, To: now},
+			expectedErr: crawler.ErrSvcInvalidQuery,
+		},
+		"Unknown status": {
+			query:       crawler.CrawlQuery{Status: "failed"},
+			expectedErr: crawler.ErrSvcInvalidQuery,
+		},
+		"Malformed cursor": {
+			query:       crawler.CrawlQuery{Cursor: "not a cursor"},
+			expectedErr: crawler.ErrSvcInvalidCursor,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			page, err := crawlerSvc.QueryCrawls(tc.query)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedIDs, ids(page))
+			assert.Equal(t, tc.expectedTotal, page.Total)
+			assert.Len(t, page.Crawls, len(tc.expectedIDs))
+		})
+	}
+
+	t.Run("Cursor pagination", func(t *testing.T) {
+		query := crawler.CrawlQuery{Limit: 3, SummaryOnly: true}
+		first, err := crawlerSvc.QueryCrawls(query)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"spacy-1", "koho-1", "monzo-2"}, ids(first))
+		assert.Nil(t, first.Crawls)
+		assert.NotEmpty(t, first.NextCursor)
+
+		query.Cursor = first.NextCursor
+		second, err := crawlerSvc.QueryCrawls(query)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"monzo-1"}, ids(second))
+		assert.Empty(t, second.NextCursor)
+
+		// the default order is by creation time, so naming it keeps the cursor
+		query.SortBy = crawler.SortByCreatedAt
+		_, err = crawlerSvc.QueryCrawls(query)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Cursor of another order", func(t *testing.T) {
+		first, err := crawlerSvc.QueryCrawls(crawler.CrawlQuery{Limit: 2})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, first.NextCursor)
+
+		for name, query := range map[string]crawler.CrawlQuery{
+			"Sort field": {Limit: 2, SortBy: crawler.SortByHost, Cursor: first.NextCursor},
+			"Direction":  {Limit: 2, Ascending: true, Cursor: first.NextCursor},
+		} {
+			_, err = crawlerSvc.QueryCrawls(query)
+			assert.ErrorIs(t, err, crawler.ErrSvcInvalidCursor, name)
+		}
+	})
+
+	t.Run("Summary", func(t *testing.T) {
+		page, err := crawlerSvc.QueryCrawls(crawler.CrawlQuery{Host: "monzo.com", Limit: 1})
+		assert.NoError(t, err)
+		assert.Equal(t, crawler.CrawlSummary{
+			ID:         "monzo-2",
+			Host:       "monzo.com",
+			CreatedAt:  now.Add(-2 * time.Hour),
+			Status:     crawler.CrawlPartial,
+			PageCount:  3,
+			ErrorCount: 1,
+		}, page.Summaries[0])
+		assert.Equal(t, "monzo-2", page.Crawls[0].ID)
+	})
+}
@@ -0,0 +1,141 @@
+package crawler
+
+import (
+	"sort"
+	"time"
+)
+
+// Index from each host to its crawls, most recent first
+type hostIndex map[string][]hostEntry
+
+type hostEntry struct {
+	id        string
+	createdAt time.Time
+}
+
+// Reports whether an entry is ordered before another, ties on the creation
+// time are broken on the ID so the order is stable
+func (e hostEntry) before(other hostEntry) bool {
+	if !e.createdAt.Equal(other.createdAt) {
+		return e.createdAt.After(other.createdAt)
+	}
+	return e.id < other.id
+}
+
+func (idx hostIndex) add(crawlRec Metadata) {
+	entry := hostEntry{id: crawlRec.ID, createdAt: crawlRec.CreatedAt}
+	entries := idx[crawlRec.Host]
+
+	i := sort.Search(len(entries), func(i int) bool {
+		return entry.before(entries[i])
+	})
+	entries = append(entries, hostEntry{})
+	copy(entries[i+1:], entries[i:])
+	entries[i] = entry
+	idx[crawlRec.Host] = entries
+}
+
+func (idx hostIndex) remove(crawlRec Metadata) {
+	entries := idx[crawlRec.Host]
+	for i, entry := range entries {
+		if entry.id == crawlRec.ID {
+			entries = append(entries[:i], entries[i+1:]...)
+			break
+		}
+	}
+
+	if len(entries) == 0 {
+		delete(idx, crawlRec.Host)
+		return
+	}
+	idx[crawlRec.Host] = entries
+}
+
+// Index from every crawled URL to the crawls that found it. The URLs are
+// also kept sorted so prefix searches are a binary search, and regex
+// searches only visit each distinct URL once
+type urlIndex struct {
+	crawls map[string]map[string]struct{}
+	urls   []string
+}
+
+func newURLIndex() *urlIndex {
+	return &urlIndex{crawls: map[string]map[string]struct{}{}}
+}
+
+func (idx *urlIndex) add(crawlRec Metadata) {
+	for _, url := range crawlURLs(crawlRec) {
+		ids, ok := idx.crawls[url]
+		if !ok {
+			ids = map[string]struct{}{}
+			idx.crawls[url] = ids
+
+			i := sort.SearchStrings(idx.urls, url)
+			idx.urls = append(idx.urls, "")
+			copy(idx.urls[i+1:], idx.urls[i:])
+			idx.urls[i] = url
+		}
+		ids[crawlRec.ID] = struct{}{}
+	}
+}
+
+func (idx *urlIndex) remove(crawlRec Metadata) {
+	for _, url := range crawlURLs(crawlRec) {
+		ids, ok := idx.crawls[url]
+		if !ok {
+			continue
+		}
+		delete(ids, crawlRec.ID)
+		if len(ids) > 0 {
+			continue
+		}
+
+		delete(idx.crawls, url)
+		i := sort.SearchStrings(idx.urls, url)
+		if i < len(idx.urls) && idx.urls[i] == url {
+			idx.urls = append(idx.urls[:i], idx.urls[i+1:]...)
+		}
+	}
+}
+
+// Returns the URLs matched by the search along with the crawls that found
+// each of them
+func (idx *urlIndex) search(match urlMatcher) map[string]map[string]struct{} {
+	res := map[string]map[string]struct{}{}
+
+	switch match.mode {
+	case SearchExact:
+		if ids, ok := idx.crawls[match.pattern]; ok {
+			res[match.pattern] = ids
+		}
+	case SearchPrefix:
+		for i := sort.SearchStrings(idx.urls, match.pattern); i < len(idx.urls); i++ {
+			if !match.matches(idx.urls[i]) {
+				break
+			}
+			res[idx.urls[i]] = idx.crawls[idx.urls[i]]
+		}
+	default:
+		for _, url := range idx.urls {
+			if match.matches(url) {
+				res[url] = idx.crawls[url]
+			}
+		}
+	}
+
+	return res
+}
+
+// Every distinct URL of a crawl, its page records or its link set for
+// crawls stored without page records
+func crawlURLs(crawlRec Metadata) []string {
+	if len(crawlRec.Pages) == 0 {
+		return crawlRec.CrawlResultSet
+	}
+
+	urls := make([]string, 0, len(crawlRec.Pages))
+	for _, page := range crawlRec.Pages {
+		urls = append(urls, page.URL)
+	}
+	return urls
+}
@@ -1,8 +1,15 @@
 package instance
 
 import (
+	"context"
+	"io"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sjain93/web-crawler-go/src/util"
@@ -14,6 +21,10 @@ type CrawlerIManager interface {
 	Process()
 	GetLinks() []string
 	GetErrors() []error
+	GetPages() []PageRecord
+	Snapshot() State
+	Stats() Stats
+	Stop()
 	// ✋🏻 For Testing Only!
 	ExtractTestCall(resp *http.Response, url string)
 }
@@ -23,12 +34,35 @@ type CrawlerIManager interface {
 type crawlerInstance struct {
 	initialURL string
 	// using sync map allows multiple threads to interact
-	// with this data structure in a thread safe manner
+	// with this data structure in a thread safe manner, links are stored
+	// against the Link (parent and depth) they were discovered with
 	linkMap sync.Map
 	// collect any errors from threads, goal is to return them all at the end
 	errMap sync.Map
+	// links whose page has been fetched (or failed to fetch), anything in the
+	// linkMap that is missing here is still on the frontier
+	visitedMap sync.Map
+	// the PageRecord of every visited link
+	pageMap sync.Map
+	// links dispatched when the crawl begins, either the initial URL or the
+	// frontier of a resumed crawl
+	seeds []Link
+	// limits on which links are followed, zero values leave the crawl unbounded
+	scope    string
+	maxDepth int
+	maxPages int64
+	// number of links dispatched so far, checked against maxPages
+	pageCount int64
+	// progress counters, updated atomically by the crawling threads
+	discovered int64
+	visited    int64
+	errCount   int64
+	// cancelled by Stop, in flight requests are aborted and pending links
+	// are left on the frontier
+	ctx    context.Context
+	cancel context.CancelFunc
 	// using a channel to allow concurrent crawling
-	linkChan chan string
+	linkChan chan Link
 	wg       *sync.WaitGroup
 	done     chan struct{}
 	// using a semaphore in place of a mutex, to limit concurrency
@@ -36,11 +70,72 @@ type crawlerInstance struct {
 	sem chan struct{}
 	// HTTP request client, dereferenced for each instance of the crawler
 	client http.Client
+	// optional limit on requests in flight shared with other crawlers
+	fetchLimiter util.Semaphore
+	// optional hook receiving each page record as it is fetched
+	onPage func(page PageRecord)
+}
+
+// A link discovered by the crawler, the page it was found on and its distance
+// (in links) from the initial URL
+type Link struct {
+	URL    string
+	Parent string
+	Depth  int
+}
+
+// The outcome of fetching a single page
+type PageRecord struct {
+	URL          string
+	Parent       string
+	Depth        int
+	StatusCode   int
+	ContentType  string
+	Size         int64
+	ResponseTime time.Duration
+	// final URL when the request was redirected
+	RedirectURL string
+	// set when the page could not be fetched
+	Error string
+}
+
+// A page is broken when it could not be fetched or returned an error status
+func (p PageRecord) IsBroken() bool {
+	return p.Error != "" || p.StatusCode >= http.StatusBadRequest
+}
+
+// State is a point in time view of a crawl that can be persisted and later
+// used to resume the crawl without re-fetching visited pages
+type State struct {
+	InitialURL string
+	Visited    []string
+	Pending    []Link
+	Errors     []string
+	Pages      []PageRecord
+}
+
+// Counters describing the progress of a crawl
+type Stats struct {
+	Discovered int
+	Visited    int
+	Errors     int
 }
 
 type Config struct {
 	WokerSetting *util.ConcurrencyConfig
 	HttpClient   *http.Client
+	// only follow links whose path starts with this prefix
+	Scope string
+	// maximum depth from the initial URL, zero is unlimited
+	MaxDepth int
+	// maximum number of pages fetched, zero is unlimited
+	MaxPages int
+	// shared across crawlers to cap the total number of requests in flight,
+	// nil leaves only the per crawler worker limit
+	FetchLimiter util.Semaphore
+	// called with the record of every page as soon as it is fetched, from the
+	// crawling threads
+	OnPage func(page PageRecord)
 }
 
 // Setup crawler config based on default values defined in utl
@@ -55,18 +150,64 @@ func NewCrawler(
 	initlUrl string,
 	config Config,
 ) (CrawlerIManager, error) {
+	c, err := newCrawlerInstance(initlUrl, config)
+	if err != nil {
+		return &crawlerInstance{}, err
+	}
+	c.seeds = []Link{{URL: initlUrl}}
+
+	return c, nil
+}
+
+// Rebuilds a crawler from a previously captured state, visited pages are not
+// fetched again and the pending frontier is dispatched when Process is called
+func NewCrawlerFromState(state State, config Config) (CrawlerIManager, error) {
+	c, err := newCrawlerInstance(state.InitialURL, config)
+	if err != nil {
+		return &crawlerInstance{}, err
+	}
+
+	// the depth of a visited page no longer matters, its links are already
+	// part of the pending frontier
+	for _, link := range state.Visited {
+		c.linkMap.Store(link, Link{URL: link})
+		c.visitedMap.Store(link, struct{}{})
+	}
+	c.pageCount = int64(len(state.Visited))
+	c.discovered = int64(len(state.Visited))
+	c.visited = int64(len(state.Visited))
+	for _, errMsg := range state.Errors {
+		c.storeErr(errors.New(errMsg))
+	}
+	for _, page := range state.Pages {
+		c.pageMap.Store(page.URL, page)
+	}
+	c.seeds = state.Pending
+
+	return c, nil
+}
+
+func newCrawlerInstance(initlUrl string, config Config) (*crawlerInstance, error) {
 	if config.WokerSetting == nil || config.HttpClient == nil {
-		return &crawlerInstance{}, errors.New("crawler has invalid or missing config")
+		return nil, errors.New("crawler has invalid or missing config")
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
 	c := &crawlerInstance{
 		initialURL: initlUrl,
-		linkChan:   make(chan string, config.WokerSetting.TotalWorkers),
+		ctx:        ctx,
+		cancel:     cancel,
+		scope:      config.Scope,
+		maxDepth:   config.MaxDepth,
+		maxPages:   int64(config.MaxPages),
+		linkChan:   make(chan Link, config.WokerSetting.TotalWorkers),
 		wg:         new(sync.WaitGroup),
 		done:       make(chan struct{}),
 		// goal is to construct a buffered channel to keep threads in check
-		sem:    make(chan struct{}, config.WokerSetting.TotalWorkers),
-		client: *config.HttpClient,
+		sem:          make(chan struct{}, config.WokerSetting.TotalWorkers),
+		client:       *config.HttpClient,
+		fetchLimiter: config.FetchLimiter,
+		onPage:       config.OnPage,
 	}
 	return c, nil
 }
@@ -87,13 +228,30 @@ func (c *crawlerInstance) Process() {
 	}()
 
 	// initial call to the function that kicks off the parsing
-	c.beginLinkProcessing(c.initialURL)
+	for _, link := range c.seeds {
+		c.beginLinkProcessing(link)
+	}
 
 	// Global call to wait for any potential wait processes in progress
 	c.wg.Wait()
 	close(c.done)
 }
 
+// Stops the crawl early, Process returns once the in flight requests have
+// been aborted and the unvisited links remain pending in the Snapshot
+func (c *crawlerInstance) Stop() {
+	c.cancel()
+}
+
+// Public function to report progress, safe to call while Process is running
+func (c *crawlerInstance) Stats() Stats {
+	return Stats{
+		Discovered: int(atomic.LoadInt64(&c.discovered)),
+		Visited:    int(atomic.LoadInt64(&c.visited)),
+		Errors:     int(atomic.LoadInt64(&c.errCount)),
+	}
+}
+
 // Public function to get links stored in the sync map
 func (c *crawlerInstance) GetLinks() []string {
 	links := []string{}
@@ -116,6 +274,53 @@ func (c *crawlerInstance) GetErrors() []error {
 	return errors
 }
 
+// Public function to get the record of every visited page, sorted by URL
+func (c *crawlerInstance) GetPages() []PageRecord {
+	pages := []PageRecord{}
+	c.pageMap.Range(func(_, page interface{}) bool {
+		pages = append(pages, page.(PageRecord))
+		return true
+	})
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].URL < pages[j].URL
+	})
+	return pages
+}
+
+// Captures the visited set, pending frontier and errors of the crawl, safe to
+// call while Process is running
+func (c *crawlerInstance) Snapshot() State {
+	state := State{
+		InitialURL: c.initialURL,
+		Visited:    []string{},
+		Pending:    []Link{},
+		Errors:     []string{},
+		Pages:      []PageRecord{},
+	}
+
+	// visited links are collected first, a page is only marked as visited after
+	// its links have been stored, so nothing it discovered can be lost
+	visited := map[string]struct{}{}
+	c.visitedMap.Range(func(key, _ interface{}) bool {
+		visited[key.(string)] = struct{}{}
+		state.Visited = append(state.Visited, key.(string))
+		return true
+	})
+	c.linkMap.Range(func(key, link interface{}) bool {
+		if _, ok := visited[key.(string)]; !ok {
+			state.Pending = append(state.Pending, link.(Link))
+		}
+		return true
+	})
+	c.errMap.Range(func(key, _ interface{}) bool {
+		state.Errors = append(state.Errors, key.(error).Error())
+		return true
+	})
+	state.Pages = c.GetPages()
+
+	return state
+}
+
 // Adding a semaphore for multithreaded locking against a buffered channel
 func (c *crawlerInstance) start() {
 	c.sem <- struct{}{}
@@ -128,47 +333,87 @@ func (c *crawlerInstance) end() {
 }
 
 // Start a thread that gets a page's content via HTTP
-func (c *crawlerInstance) crawl(urlStr string) {
+func (c *crawlerInstance) crawl(link Link) {
 	c.start()
 	defer c.end()
 
+	if c.fetchLimiter != nil {
+		// a crawl stopped while waiting for a slot leaves the page pending
+		if !c.fetchLimiter.Acquire(c.ctx) {
+			return
+		}
+		defer c.fetchLimiter.Release()
+	}
+
+	record := PageRecord{URL: link.URL, Parent: link.Parent, Depth: link.Depth}
+
 	// fetch the page
-	res, err := c.client.Get(urlStr)
-	if err != nil {
-		c.errMap.Store(
-			errors.Wrapf(err, "error fetching page: %s", urlStr),
-			struct{}{},
-		)
+	start := time.Now()
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, link.URL, nil)
+	if err == nil {
+		var res *http.Response
+		res, err = c.client.Do(req)
+		if err == nil {
+			record.ResponseTime = time.Since(start)
+			record.StatusCode = res.StatusCode
+			record.ContentType = res.Header.Get("Content-Type")
+			// the client follows redirects, the request holds the final URL
+			if final := res.Request.URL.String(); final != link.URL {
+				record.RedirectURL = final
+			}
+			// scan the page
+			record.Size = c.extract(res, link)
+		}
+	}
+
+	// a page interrupted by Stop stays on the frontier
+	if c.ctx.Err() != nil {
 		return
 	}
-	// scan the page
-	c.extract(res, urlStr)
+	if err != nil {
+		err = errors.Wrapf(err, "error fetching page: %s", link.URL)
+		c.storeErr(err)
+		record.Error = err.Error()
+	}
+	c.pageMap.Store(link.URL, record)
+	c.visitedMap.Store(link.URL, struct{}{})
+	atomic.AddInt64(&c.visited, 1)
+	if c.onPage != nil {
+		c.onPage(record)
+	}
+}
+
+// Records an error against the crawl to be returned by GetErrors
+func (c *crawlerInstance) storeErr(err error) {
+	c.errMap.Store(err, struct{}{})
+	atomic.AddInt64(&c.errCount, 1)
 }
 
 // Pull out links from the HTTP response and dispatch them to be validated
-// and potentially added to the processing channel
-func (c *crawlerInstance) extract(res *http.Response, urlStr string) {
+// and potentially added to the processing channel, returns the number of
+// bytes read from the body
+func (c *crawlerInstance) extract(res *http.Response, parent Link) int64 {
 	const (
 		htmlATag    = "a"
 		htmlHrefTag = "href"
 	)
 
-	body := res.Body
-	defer body.Close()
+	body := &countingReader{r: res.Body}
+	defer res.Body.Close()
 	tokenizer := html.NewTokenizer(body)
 
 	for {
 		tokenType := tokenizer.Next()
 		switch tokenType {
 		case html.ErrorToken:
-			return
+			return body.n
 		case html.StartTagToken, html.SelfClosingTagToken:
 			token := tokenizer.Token()
 			if token.Data == htmlATag {
 				for _, attr := range token.Attr {
 					if attr.Key == htmlHrefTag {
 						link := attr.Val
-						c.validateAndDispatch(link, urlStr)
+						c.validateAndDispatch(link, parent)
 					}
 				}
 			}
@@ -178,7 +423,8 @@ func (c *crawlerInstance) extract(res *http.Response, urlStr string) {
 
 // Reference resolution, scheme verification and domain validation
 // before preparing it to be pulled and repeat the process as with its parent
-func (c *crawlerInstance) validateAndDispatch(link string, baseURL string) {
+func (c *crawlerInstance) validateAndDispatch(link string, parent Link) {
+	baseURL := parent.URL
 	// if the link is departing the given base URL's domain, no need to process
 	if !util.IsSameDomain(link, baseURL) {
 		return
@@ -186,10 +432,7 @@ func (c *crawlerInstance) validateAndDispatch(link string, baseURL string) {
 
 	absUrl, err := util.GetAbsoluteURL(link, baseURL)
 	if err != nil {
-		c.errMap.Store(
-			errors.Wrapf(err, "error getting abs url: %s baseURL: %s", link, baseURL),
-			struct{}{},
-		)
+		c.storeErr(errors.Wrapf(err, "error getting abs url: %s baseURL: %s", link, baseURL))
 		return
 	}
 
@@ -198,33 +441,73 @@ func (c *crawlerInstance) validateAndDispatch(link string, baseURL string) {
 		return
 	}
 
-	if absUrl != "" && util.IsSameDomain(absUrl, c.initialURL) {
-		c.beginLinkProcessing(absUrl)
+	if absUrl != "" && util.IsSameDomain(absUrl, c.initialURL) && c.inScope(absUrl) {
+		c.beginLinkProcessing(Link{URL: absUrl, Parent: parent.URL, Depth: parent.Depth + 1})
 	}
 }
 
+// A link is in scope when its path begins with the configured prefix
+func (c *crawlerInstance) inScope(absURL string) bool {
+	if c.scope == "" {
+		return true
+	}
+
+	u, err := url.Parse(absURL)
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(u.Path, c.scope)
+}
+
 // Ensuring the link is new, adding to the waitgroup increment
 // to indicate that there is a new thread that the process will need
 // to wait for.
-func (c *crawlerInstance) beginLinkProcessing(absURL string) {
-	// check to see if link has already been stored in the linkmap
-	_, visited := c.linkMap.Load(absURL)
-	if visited {
+func (c *crawlerInstance) beginLinkProcessing(link Link) {
+	if c.maxDepth > 0 && link.Depth > c.maxDepth {
 		return
 	}
 
+	// check to see if link has already been stored in the linkmap,
 	// otherwise add to new link map
-	c.linkMap.Store(absURL, struct{}{})
+	_, visited := c.linkMap.LoadOrStore(link.URL, link)
+	if visited {
+		return
+	}
+
+	// once the page budget is spent, newly discovered links are dropped
+	if c.maxPages > 0 && atomic.AddInt64(&c.pageCount, 1) > c.maxPages {
+		c.linkMap.Delete(link.URL)
+		return
+	}
+	atomic.AddInt64(&c.discovered, 1)
+
+	// after Stop the link is kept as pending but never fetched
+	if c.ctx.Err() != nil {
+		return
+	}
 
 	// continue the iteration and feed the link back into the channel for
 	// processing
 	c.wg.Add(1)
-	c.linkChan <- absURL
+	c.linkChan <- link
+}
+
+// Counts the bytes read through it, used to record page sizes
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
 }
 
 /*
 ⚠️ NOTE THE FUNCTION BELOW IS ONLY USED FOR TESTING
 */
 func (c *crawlerInstance) ExtractTestCall(resp *http.Response, url string) {
-	c.extract(resp, url)
+	c.extract(resp, Link{URL: url})
 }
@@ -1,7 +1,12 @@
 package instance_test
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/sjain93/web-crawler-go/src/crawler/instance"
 	"github.com/sjain93/web-crawler-go/src/util"
@@ -97,3 +102,306 @@ func unorderedEqual(first, second []string) bool {
 	}
 	return true
 }
+
+func TestResumeFromState(t *testing.T) {
+	hits := map[string]int{}
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+
+		pages := map[string]string{
+			"/":  `<a href="/a">a</a><a href="/b">b</a>`,
+			"/a": `<a href="/c">c</a>`,
+			"/b": `<a href="/">home</a>`,
+			"/c": `<a href="/d">d</a>`,
+		}
+		fmt.Fprint(w, pages[r.URL.Path])
+	}))
+	defer server.Close()
+
+	testCases := map[string]struct {
+		state         instance.State
+		expectedLinks []string
+		skippedPaths  []string
+	}{
+		"Resumes pending frontier without refetching visited pages": {
+			state: instance.State{
+				InitialURL: server.URL + "/",
+				Visited:    []string{server.URL + "/", server.URL + "/a"},
+				Pending: []instance.Link{
+					{URL: server.URL + "/b", Depth: 1},
+					{URL: server.URL + "/c", Depth: 2},
+				},
+				Errors: []string{"error fetching page"},
+			},
+			expectedLinks: []string{
+				server.URL + "/",
+				server.URL + "/a",
+				server.URL + "/b",
+				server.URL + "/c",
+				server.URL + "/d",
+			},
+			skippedPaths: []string{"/", "/a"},
+		},
+		"Nothing pending": {
+			state: instance.State{
+				InitialURL: server.URL + "/",
+				Visited:    []string{server.URL + "/"},
+			},
+			expectedLinks: []string{server.URL + "/"},
+			skippedPaths:  []string{"/"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mu.Lock()
+			hits = map[string]int{}
+			mu.Unlock()
+
+			c, err := instance.NewCrawlerFromState(tc.state, *instance.NewDefaultConfig())
+			assert.NoError(t, err)
+
+			c.Process()
+			assert.True(t, unorderedEqual(c.GetLinks(), tc.expectedLinks))
+			assert.Len(t, c.GetErrors(), len(tc.state.Errors))
+			for _, path := range tc.skippedPaths {
+				assert.Zero(t, hits[path])
+			}
+
+			state := c.Snapshot()
+			assert.Empty(t, state.Pending)
+			assert.True(t, unorderedEqual(state.Visited, tc.expectedLinks))
+		})
+	}
+}
+
+func TestCrawlLimits(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pages := map[string]string{
+			"/":       `<a href="/a">a</a><a href="/blog/">blog</a>`,
+			"/a":      `<a href="/a/b">b</a>`,
+			"/a/b":    `<a href="/a/b/c">c</a>`,
+			"/blog/":  `<a href="/blog/x">x</a><a href="/a">a</a>`,
+			"/blog/x": `<a href="/blog/y">y</a>`,
+		}
+		fmt.Fprint(w, pages[r.URL.Path])
+	}))
+	defer server.Close()
+
+	testCases := map[string]struct {
+		initialURL    string
+		scope         string
+		maxDepth      int
+		maxPages      int
+		expectedLinks []string
+	}{
+		"Depth limit": {
+			initialURL: server.URL + "/",
+			maxDepth:   1,
+			expectedLinks: []string{
+				server.URL + "/",
+				server.URL + "/a",
+				server.URL + "/blog/",
+			},
+		},
+		"Scope limit": {
+			initialURL: server.URL + "/blog/",
+			scope:      "/blog/",
+			expectedLinks: []string{
+				server.URL + "/blog/",
+				server.URL + "/blog/x",
+				server.URL + "/blog/y",
+			},
+		},
+		"Page budget": {
+			initialURL: server.URL + "/a",
+			maxPages:   2,
+			expectedLinks: []string{
+				server.URL + "/a",
+				server.URL + "/a/b",
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfg := instance.NewDefaultConfig()
+			cfg.Scope = tc.scope
+			cfg.MaxDepth = tc.maxDepth
+			cfg.MaxPages = tc.maxPages
+
+			c, err := instance.NewCrawler(tc.initialURL, *cfg)
+			assert.NoError(t, err)
+
+			c.Process()
+			assert.True(t, unorderedEqual(c.GetLinks(), tc.expectedLinks))
+		})
+	}
+}
+
+func TestStop(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		fmt.Fprint(w, `<a href="/slow">slow</a>`)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	c, err := instance.NewCrawler(server.URL+"/", *instance.NewDefaultConfig())
+	assert.NoError(t, err)
+
+	finished := make(chan struct{})
+	go func() {
+		c.Process()
+		close(finished)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return c.Stats().Visited == 1 && c.Stats().Discovered == 2
+	}, time.Second, 5*time.Millisecond)
+	c.Stop()
+
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("crawl did not stop")
+	}
+
+	state := c.Snapshot()
+	assert.Equal(t, []string{server.URL + "/"}, state.Visited)
+	assert.Equal(
+		t,
+		[]instance.Link{{URL: server.URL + "/slow", Parent: server.URL + "/", Depth: 1}},
+		state.Pending,
+	)
+	assert.Empty(t, state.Errors)
+}
+
+func TestSharedFetchLimiter(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+		fmt.Fprint(w, `<a href="/a">a</a><a href="/b">b</a><a href="/c">c</a>`)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	testCases := map[string]struct {
+		limit uint
+	}{
+		"Single request in flight": {limit: 1},
+		"Two requests in flight":   {limit: 2},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			maxInFlight = 0
+			limiter := util.NewSemaphore(tc.limit)
+
+			var wg sync.WaitGroup
+			for _, path := range []string{"/x", "/y"} {
+				cfg := instance.NewDefaultConfig()
+				cfg.FetchLimiter = limiter
+				c, err := instance.NewCrawler(server.URL+path, *cfg)
+				assert.NoError(t, err)
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					c.Process()
+				}()
+			}
+			wg.Wait()
+
+			assert.LessOrEqual(t, maxInFlight, int(tc.limit))
+		})
+	}
+}
+
+func TestPageRecords(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/missing">missing</a><a href="/old">old</a>`)
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		case "/new":
+			fmt.Fprint(w, "new")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	c, err := instance.NewCrawler(server.URL+"/", *instance.NewDefaultConfig())
+	assert.NoError(t, err)
+	c.Process()
+
+	pages := map[string]instance.PageRecord{}
+	for _, page := range c.GetPages() {
+		pages[page.URL] = page
+	}
+
+	testCases := map[string]struct {
+		url            string
+		expectedStatus int
+		expectedParent string
+		expectedDepth  int
+		redirectURL    string
+		broken         bool
+	}{
+		"Initial page": {
+			url:            server.URL + "/",
+			expectedStatus: http.StatusOK,
+		},
+		"Broken link": {
+			url:            server.URL + "/missing",
+			expectedStatus: http.StatusNotFound,
+			expectedParent: server.URL + "/",
+			expectedDepth:  1,
+			broken:         true,
+		},
+		"Redirect": {
+			url:            server.URL + "/old",
+			expectedStatus: http.StatusOK,
+			expectedParent: server.URL + "/",
+			expectedDepth:  1,
+			redirectURL:    server.URL + "/new",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			page, ok := pages[tc.url]
+			assert.True(t, ok)
+			assert.Equal(t, tc.expectedStatus, page.StatusCode)
+			assert.Equal(t, tc.expectedParent, page.Parent)
+			assert.Equal(t, tc.expectedDepth, page.Depth)
+			assert.Equal(t, tc.redirectURL, page.RedirectURL)
+			assert.Equal(t, tc.broken, page.IsBroken())
+			assert.Greater(t, page.Size, int64(0))
+		})
+	}
+}
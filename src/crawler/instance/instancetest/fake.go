@@ -0,0 +1,216 @@
+// Package instancetest provides a fake crawler instance so that code built on
+// top of the crawler can be tested without network access
+package instancetest
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/sjain93/web-crawler-go/src/crawler/instance"
+)
+
+var _ instance.CrawlerIManager = (*Crawler)(nil)
+
+// Crawler satisfies instance.CrawlerIManager with canned results
+type Crawler struct {
+	initialURL string
+	links      []string
+	errs       []error
+	pages      []instance.PageRecord
+	// when set Process blocks until it is closed or Stop is called
+	block chan struct{}
+	// called with every page once processed, like the real crawler does as
+	// pages are fetched
+	onPage func(page instance.PageRecord)
+
+	mu        sync.Mutex
+	processed bool
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+// Returns a fake crawler that reports the links and errors once processed,
+// no links defaults to the initial URL alone
+func NewCrawler(initialURL string, links []string, errs []error) *Crawler {
+	if links == nil {
+		links = []string{initialURL}
+	}
+
+	return &Crawler{
+		initialURL: initialURL,
+		links:      links,
+		errs:       errs,
+		stop:       make(chan struct{}),
+	}
+}
+
+// Replaces the page records, by default every link is reported as a page
+// fetched with a 200 status
+func (c *Crawler) WithPages(pages []instance.PageRecord) *Crawler {
+	c.pages = pages
+	return c
+}
+
+// Makes Process wait until the channel is closed or the crawler is stopped
+func (c *Crawler) BlockUntil(ch chan struct{}) *Crawler {
+	c.block = ch
+	return c
+}
+
+func (c *Crawler) Process() {
+	if c.block != nil {
+		select {
+		case <-c.block:
+		case <-c.stop:
+			return
+		}
+	}
+
+	c.mu.Lock()
+	c.processed = true
+	c.mu.Unlock()
+
+	if c.onPage != nil {
+		for _, page := range c.GetPages() {
+			c.onPage(page)
+		}
+	}
+}
+
+func (c *Crawler) GetLinks() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.processed {
+		return []string{}
+	}
+	return c.links
+}
+
+func (c *Crawler) GetErrors() []error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.processed {
+		return []error{}
+	}
+	return c.errs
+}
+
+func (c *Crawler) GetPages() []instance.PageRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.processed {
+		return []instance.PageRecord{}
+	}
+	if c.pages != nil {
+		return c.pages
+	}
+
+	pages := []instance.PageRecord{}
+	for _, link := range c.links {
+		pages = append(pages, instance.PageRecord{URL: link, StatusCode: http.StatusOK})
+	}
+	return pages
+}
+
+func (c *Crawler) Snapshot() instance.State {
+	state := instance.State{
+		InitialURL: c.initialURL,
+		Visited:    c.GetLinks(),
+		Pending:    []instance.Link{},
+		Errors:     []string{},
+		Pages:      c.GetPages(),
+	}
+	if len(state.Visited) == 0 {
+		state.Pending = append(state.Pending, instance.Link{URL: c.initialURL})
+	}
+	for _, err := range c.GetErrors() {
+		state.Errors = append(state.Errors, err.Error())
+	}
+	return state
+}
+
+func (c *Crawler) Stats() instance.Stats {
+	links := c.GetLinks()
+	return instance.Stats{
+		Discovered: len(links),
+		Visited:    len(links),
+		Errors:     len(c.GetErrors()),
+	}
+}
+
+func (c *Crawler) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+}
+
+func (c *Crawler) ExtractTestCall(resp *http.Response, url string) {}
+
+// Factory builds fake crawlers and records the config each one was built
+// with, it has the same methods as the crawler service's factory
+type Factory struct {
+	// results reported by every crawler built by the factory
+	Links  []string
+	Errors []error
+	Pages  []instance.PageRecord
+	// returned instead of a crawler when set
+	Err error
+	// when set every crawler blocks in Process until it is closed
+	Block chan struct{}
+
+	mu       sync.Mutex
+	configs  []instance.Config
+	crawlers []*Crawler
+}
+
+func (f *Factory) NewCrawler(
+	initialURL string,
+	cfg instance.Config,
+) (instance.CrawlerIManager, error) {
+	return f.build(initialURL, cfg)
+}
+
+func (f *Factory) NewCrawlerFromState(
+	state instance.State,
+	cfg instance.Config,
+) (instance.CrawlerIManager, error) {
+	return f.build(state.InitialURL, cfg)
+}
+
+// Returns the config of every crawler built so far, in order
+func (f *Factory) Configs() []instance.Config {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]instance.Config{}, f.configs...)
+}
+
+// Returns every crawler built so far, in order
+func (f *Factory) Crawlers() []*Crawler {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]*Crawler{}, f.crawlers...)
+}
+
+func (f *Factory) build(initialURL string, cfg instance.Config) (instance.CrawlerIManager, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	c := NewCrawler(initialURL, f.Links, f.Errors).WithPages(f.Pages)
+	c.onPage = cfg.OnPage
+	if f.Block != nil {
+		c.BlockUntil(f.Block)
+	}
+
+	f.mu.Lock()
+	f.configs = append(f.configs, cfg)
+	f.crawlers = append(f.crawlers, c)
+	f.mu.Unlock()
+
+	return c, nil
+}
@@ -0,0 +1,261 @@
+package crawler
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/sjain93/web-crawler-go/src/crawler/instance"
+	"github.com/sjain93/web-crawler-go/src/util"
+)
+
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+// A crawl running in the background, started through StartCrawl. Once the
+// job completes CrawlID refers to the stored crawl
+type Job struct {
+	ID         string
+	CrawlID    string
+	InitialURL string
+	Options    CrawlOptions
+	Status     JobStatus
+	Progress   instance.Stats
+	Error      string
+	CreatedAt  time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Internal bookkeeping for a job, the crawler is attached once the crawl
+// begins so the job can report progress and be cancelled
+type crawlJob struct {
+	mu              sync.Mutex
+	job             Job
+	crawler         instance.CrawlerIManager
+	cancelRequested bool
+	// closed once the job has left the scheduler, whatever its outcome
+	done chan struct{}
+	// clock of the service that started the job
+	now func() time.Time
+}
+
+// Returns a copy of the job with up to date progress counters
+func (j *crawlJob) snapshot() Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	job := j.job
+	if j.crawler != nil && job.Status == JobRunning {
+		job.Progress = j.crawler.Stats()
+	}
+	return job
+}
+
+// Moves a queued job to running, false if it was cancelled while queued
+func (j *crawlJob) begin() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.cancelRequested {
+		return false
+	}
+	j.job.Status = JobRunning
+	j.job.StartedAt = j.now().UTC()
+	return true
+}
+
+// Attaches the crawler running on behalf of the job, false if the job was
+// cancelled before the crawl could start
+func (j *crawlJob) attach(crawlID string, crawler instance.CrawlerIManager) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.cancelRequested {
+		return false
+	}
+	j.job.CrawlID = crawlID
+	j.crawler = crawler
+	return true
+}
+
+func (j *crawlJob) isCancelled() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.cancelRequested
+}
+
+// Requests that the job stops, queued jobs are cancelled straight away while
+// running jobs are marked cancelled once their crawler has stopped
+func (j *crawlJob) cancel() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	switch j.job.Status {
+	case JobCompleted, JobFailed, JobCancelled:
+		return ErrSvcJobFinished
+	case JobQueued:
+		j.job.Status = JobCancelled
+		j.job.FinishedAt = j.now().UTC()
+	}
+
+	j.cancelRequested = true
+	if j.crawler != nil {
+		j.crawler.Stop()
+	}
+	return nil
+}
+
+// Records the outcome of the crawl on the job
+func (j *crawlJob) finish(crawls []Metadata, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.crawler != nil {
+		j.job.Progress = j.crawler.Stats()
+	}
+	j.job.FinishedAt = j.now().UTC()
+
+	switch {
+	case errors.Is(err, ErrSvcCrawlCancelled):
+		j.job.Status = JobCancelled
+	case err != nil:
+		j.job.Status = JobFailed
+		j.job.Error = err.Error()
+	default:
+		j.job.Status = JobCompleted
+		if len(crawls) > 0 {
+			j.job.CrawlID = crawls[0].ID
+		}
+	}
+}
+
+// This service method validates the URL and queues the crawl to run in the
+// background, the returned job ID can be used to follow its progress
+func (s *crawlerService) StartCrawl(crawlRec Metadata, opts CrawlOptions) (string, error) {
+	job, err := s.startJob(crawlRec, opts)
+	if err != nil {
+		return "", err
+	}
+	return job.job.ID, nil
+}
+
+// Queues the crawl on the scheduler, or returns the job already queued or
+// running for the same target
+func (s *crawlerService) startJob(crawlRec Metadata, opts CrawlOptions) (*crawlJob, error) {
+	if _, err := util.GetHost(crawlRec.InitialURL); err != nil {
+		return nil, err
+	}
+	opts = s.resolveOptions(opts)
+	fingerprint, err := Fingerprint(crawlRec.InitialURL, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s.inflightMu.Lock()
+	defer s.inflightMu.Unlock()
+
+	// a job that is still queued or running for the same target is reused
+	if jobID, ok := s.activeJobs[fingerprint]; ok {
+		s.jobsMu.Lock()
+		job := s.jobs[jobID]
+		s.jobsMu.Unlock()
+		if job != nil {
+			if status := job.snapshot().Status; status == JobQueued || status == JobRunning {
+				s.logger.Sugar().Infof("crawl job %v already covers %v", jobID, crawlRec.InitialURL)
+				return job, nil
+			}
+		}
+	}
+
+	job := &crawlJob{
+		job: Job{
+			ID:         uuid.NewString(),
+			InitialURL: crawlRec.InitialURL,
+			Options:    opts,
+			Status:     JobQueued,
+			CreatedAt:  s.now().UTC(),
+		},
+		done: make(chan struct{}),
+		now:  s.now,
+	}
+
+	s.jobsMu.Lock()
+	s.jobs[job.job.ID] = job
+	s.jobsMu.Unlock()
+	s.activeJobs[fingerprint] = job.job.ID
+
+	s.scheduler.submit(opts.Priority, func() {
+		s.runJob(job, crawlRec, opts)
+
+		s.inflightMu.Lock()
+		if s.activeJobs[fingerprint] == job.job.ID {
+			delete(s.activeJobs, fingerprint)
+		}
+		s.inflightMu.Unlock()
+		close(job.done)
+	})
+
+	return job, nil
+}
+
+func (s *crawlerService) runJob(job *crawlJob, crawlRec Metadata, opts CrawlOptions) {
+	if !job.begin() {
+		return
+	}
+
+	crawls, err := s.crawlSite(crawlRec, opts, job)
+	if err != nil && !errors.Is(err, ErrSvcCrawlCancelled) {
+		s.logger.Sugar().Errorf("crawl job %v failed: %v", job.job.ID, err.Error())
+	}
+	job.finish(crawls, err)
+}
+
+// This method reports the status and progress of a background crawl
+func (s *crawlerService) GetJob(id string) (Job, error) {
+	s.jobsMu.Lock()
+	job, ok := s.jobs[id]
+	s.jobsMu.Unlock()
+	if !ok {
+		return Job{ID: id}, ErrSvcJobNotFound
+	}
+
+	return job.snapshot(), nil
+}
+
+// This method stops a queued or running background crawl
+func (s *crawlerService) CancelJob(id string) error {
+	s.jobsMu.Lock()
+	job, ok := s.jobs[id]
+	s.jobsMu.Unlock()
+	if !ok {
+		return ErrSvcJobNotFound
+	}
+
+	return job.cancel()
+}
+
+// This method returns every background crawl in the order they were started
+func (s *crawlerService) ListJobs() []Job {
+	s.jobsMu.Lock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job.snapshot())
+	}
+	s.jobsMu.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+	return jobs
+}
@@ -0,0 +1,56 @@
+-- One row per stored crawl, the crawl options are kept as columns
+CREATE TABLE crawls (
+    id          TEXT PRIMARY KEY,
+    initial_url TEXT NOT NULL,
+    host        TEXT NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL,
+    fingerprint TEXT NOT NULL DEFAULT '',
+    scope       TEXT NOT NULL DEFAULT '',
+    max_depth   INTEGER NOT NULL DEFAULT 0,
+    max_pages   INTEGER NOT NULL DEFAULT 0,
+    workers     INTEGER NOT NULL DEFAULT 0,
+    timeout_ns  BIGINT NOT NULL DEFAULT 0,
+    -- false for crawls stored with only their result set
+    has_pages   BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX crawls_host_created_at_idx ON crawls (host, created_at DESC, id);
+CREATE INDEX crawls_created_at_idx ON crawls (created_at);
+
+-- Every URL of a crawl. result_position orders the result set and is null for
+-- pages fetched but left out of it, the response columns are only meaningful
+-- when fetched is set
+CREATE TABLE pages (
+    crawl_id         TEXT NOT NULL REFERENCES crawls (id) ON DELETE CASCADE,
+    url              TEXT NOT NULL,
+    result_position  INTEGER,
+    fetched          BOOLEAN NOT NULL DEFAULT FALSE,
+    depth            INTEGER NOT NULL DEFAULT 0,
+    status_code      INTEGER NOT NULL DEFAULT 0,
+    content_type     TEXT NOT NULL DEFAULT '',
+    size             BIGINT NOT NULL DEFAULT 0,
+    response_time_ns BIGINT NOT NULL DEFAULT 0,
+    redirect_url     TEXT NOT NULL DEFAULT '',
+    error            TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (crawl_id, url)
+);
+
+-- text_pattern_ops lets prefix searches use the index whatever the collation
+CREATE INDEX pages_url_idx ON pages (url text_pattern_ops);
+
+-- The link each page was discovered through
+CREATE TABLE edges (
+    crawl_id   TEXT NOT NULL,
+    source_url TEXT NOT NULL,
+    target_url TEXT NOT NULL,
+    PRIMARY KEY (crawl_id, target_url),
+    FOREIGN KEY (crawl_id, target_url) REFERENCES pages (crawl_id, url) ON DELETE CASCADE
+);
+
+-- Errors met during the crawl, in the order they were recorded
+CREATE TABLE errors (
+    crawl_id TEXT NOT NULL REFERENCES crawls (id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    message  TEXT NOT NULL,
+    PRIMARY KEY (crawl_id, position)
+);
@@ -0,0 +1,7 @@
+-- edges only ever held the one link each page was discovered through, not
+-- every link between pages, so the table is named after what it holds
+ALTER TABLE edges RENAME TO page_parents;
+ALTER TABLE page_parents RENAME COLUMN target_url TO url;
+ALTER TABLE page_parents RENAME COLUMN source_url TO parent_url;
+ALTER TABLE page_parents RENAME CONSTRAINT edges_pkey TO page_parents_pkey;
+ALTER TABLE page_parents RENAME CONSTRAINT edges_crawl_id_target_url_fkey TO page_parents_crawl_id_url_fkey;
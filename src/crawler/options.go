@@ -0,0 +1,114 @@
+package crawler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/sjain93/web-crawler-go/config"
+	"github.com/sjain93/web-crawler-go/src/util"
+)
+
+// Zero valued settings are filled in from the defaults, a request sets these
+// to override a configured default back to no limit or no scope
+const (
+	NoLimit = -1
+	NoScope = "/"
+)
+
+// Options for a crawl, once resolved against the defaults they are stored on
+// the crawl record so that every crawl can be reproduced. ForceRefresh and
+// Priority only affect how a request is handled and are never stored
+type CrawlOptions struct {
+	// skip the cache lookup and always run a new crawl
+	ForceRefresh bool `json:"-"`
+	// only follow links whose path starts with this prefix, empty uses the
+	// default and NoScope follows every link
+	Scope string
+	// maximum depth from the initial URL, zero uses the default and NoLimit
+	// is unlimited
+	MaxDepth int
+	// maximum number of pages fetched, zero uses the default and NoLimit is
+	// unlimited
+	MaxPages int
+	// queue position of a background crawl, higher runs first
+	Priority int `json:"-"`
+	// overrides for the crawler instance, zero values use the defaults
+	Workers uint
+	Timeout time.Duration
+}
+
+// Builds crawl options from the defaults held in the config package
+func CrawlOptionsFromConfig(d config.CrawlDefaults) CrawlOptions {
+	return CrawlOptions{
+		Workers:  d.Workers,
+		Timeout:  d.Timeout,
+		Scope:    d.Scope,
+		MaxDepth: d.MaxDepth,
+		MaxPages: d.MaxPages,
+	}
+}
+
+// Fills every zero valued crawl setting from the defaults, request only
+// settings are left untouched
+func (o CrawlOptions) withDefaults(d CrawlOptions) CrawlOptions {
+	if o.Scope == "" {
+		o.Scope = d.Scope
+	}
+	if o.MaxDepth == 0 {
+		o.MaxDepth = d.MaxDepth
+	}
+	if o.MaxPages == 0 {
+		o.MaxPages = d.MaxPages
+	}
+	if o.Workers == 0 {
+		o.Workers = d.Workers
+	}
+	if o.Timeout == 0 {
+		o.Timeout = d.Timeout
+	}
+	return o
+}
+
+// Turns NoLimit and NoScope into the zero values the crawler and the stored
+// options use for no limit and no scope, once the defaults are filled in
+func (o CrawlOptions) withoutSentinels() CrawlOptions {
+	if o.Scope == NoScope {
+		o.Scope = ""
+	}
+	if o.MaxDepth < 0 {
+		o.MaxDepth = 0
+	}
+	if o.MaxPages < 0 {
+		o.MaxPages = 0
+	}
+	return o
+}
+
+// Drops the request only settings before the options are stored
+func (o CrawlOptions) forStorage() CrawlOptions {
+	o.ForceRefresh = false
+	o.Priority = 0
+	return o
+}
+
+// Returns a fingerprint of the normalized initial URL and the options that
+// change what a crawl visits, two crawls with the same fingerprint are
+// interchangeable for caching
+func Fingerprint(initialURL string, opts CrawlOptions) (string, error) {
+	normalized, err := util.NormalizeURL(initialURL)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf(
+		"%s|scope=%s|depth=%d|pages=%d",
+		normalized,
+		opts.Scope,
+		opts.MaxDepth,
+		opts.MaxPages,
+	)))
+
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,770 @@
+package crawler
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/sjain93/web-crawler-go/src/crawler/instance"
+	"github.com/sjain93/web-crawler-go/src/util"
+)
+
+var (
+	ErrPagesNotFound    = errors.New("no pages stored for crawl")
+	ErrPagesDeleted     = errors.New("pages of crawl were deleted")
+	ErrPageStoreClosed  = errors.New("page store is closed")
+	ErrPageStoreCorrupt = errors.New("page store segment is corrupt")
+)
+
+const (
+	// segments are sealed once they grow past this size
+	defaultSegmentSize = 4 << 20
+	// lists the segments in log order and the pages of each crawl they hold
+	segmentIndexName = "index.json"
+	segmentExt       = ".seg"
+	// length and CRC of the payload
+	recordHeaderSize = 8
+	// page records are a few hundred bytes, a longer length is never
+	// allocated as it can only come from a corrupt header
+	maxRecordSize = 1 << 20
+)
+
+// errTornRecord marks a record cut short by a crash, the log ends before it
+var errTornRecord = errors.New("torn segment record")
+
+// Public interface for storing the page records of crawls as they are fetched
+type PageStoreManager interface {
+	Append(crawlID string, page instance.PageRecord) error
+	ScanPages(crawlID string, fn func(page instance.PageRecord) error) error
+	Delete(crawlID string) error
+	Compact() error
+	Close() error
+}
+
+// One entry of a segment, either a page of a crawl or the tombstone recording
+// the deletion of a crawl
+type segmentRecord struct {
+	Crawl   string
+	Page    instance.PageRecord
+	Deleted bool `json:",omitempty"`
+}
+
+// What the index knows about a segment, Pages counts the page records of each
+// crawl so reads only open the segments holding the crawl
+type segmentMeta struct {
+	ID    int
+	Size  int64
+	Pages map[string]int
+}
+
+type segmentIndex struct {
+	Segments []segmentMeta
+	Deleted  []string
+	NextID   int
+}
+
+// SegmentPageStore is an append only log of page records split into segment
+// files. Pages are appended to the active segment as they are crawled, reads
+// stream a crawl back one record at a time, and compaction rewrites the
+// sealed segments without the pages of deleted crawls.
+//
+// Each record is a 4 byte length and a 4 byte CRC followed by the JSON
+// payload, so a record torn by a crash is detected and cut off when the store
+// is opened. The index is rewritten whenever a segment is sealed, records
+// appended since are replayed from the active segment
+type SegmentPageStore struct {
+	dir         string
+	segmentSize int64
+
+	// held by reads for their whole scan and by compaction, so segment files
+	// are never removed from under a read
+	compactMu sync.RWMutex
+
+	mu       sync.Mutex
+	segments []segmentMeta
+	deleted  map[string]struct{}
+	nextID   int
+	active   *os.File
+	closed   bool
+}
+
+// Opens the page store in the directory, creating it if needed. A zero
+// segment size uses the 4MiB default
+func NewPageStore(dir string, segmentSize int64) (PageStoreManager, error) {
+	if segmentSize <= 0 {
+		segmentSize = defaultSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return &SegmentPageStore{}, err
+	}
+
+	s := &SegmentPageStore{
+		dir:         dir,
+		segmentSize: segmentSize,
+		deleted:     map[string]struct{}{},
+		nextID:      1,
+	}
+	if err := s.open(); err != nil {
+		return &SegmentPageStore{}, err
+	}
+	return s, nil
+}
+
+// Appends a page record of the crawl to the active segment
+func (s *SegmentPageStore) Append(crawlID string, page instance.PageRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrPageStoreClosed
+	}
+	if _, ok := s.deleted[crawlID]; ok {
+		return ErrPagesDeleted
+	}
+	return s.append(segmentRecord{Crawl: crawlID, Page: page})
+}
+
+// Calls fn with every page of the crawl in the order they were appended,
+// reading one record at a time. A page appended again, e.g. when a resumed
+// crawl fetches it a second time, is only returned the first time. Scanning
+// stops at the first error returned by fn
+func (s *SegmentPageStore) ScanPages(crawlID string, fn func(page instance.PageRecord) error) error {
+	s.compactMu.RLock()
+	defer s.compactMu.RUnlock()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrPageStoreClosed
+	}
+	// only what was written when the scan began is read
+	segments := []segmentMeta{}
+	for _, seg := range s.segments {
+		if seg.Pages[crawlID] > 0 {
+			segments = append(segments, segmentMeta{ID: seg.ID, Size: seg.Size})
+		}
+	}
+	s.mu.Unlock()
+
+	if len(segments) == 0 {
+		return ErrPagesNotFound
+	}
+
+	seen := map[string]struct{}{}
+	for _, seg := range segments {
+		err := s.readSegment(seg.ID, seg.Size, func(rec segmentRecord, _ int64) error {
+			if rec.Deleted || rec.Crawl != crawlID {
+				return nil
+			}
+			if _, ok := seen[rec.Page.URL]; ok {
+				return nil
+			}
+			seen[rec.Page.URL] = struct{}{}
+			return fn(rec.Page)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Appends a tombstone for the crawl, its pages are no longer returned and are
+// dropped by the next compaction. Pages can't be appended to a deleted crawl
+// until then
+func (s *SegmentPageStore) Delete(crawlID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrPageStoreClosed
+	}
+	found := false
+	for _, seg := range s.segments {
+		found = found || seg.Pages[crawlID] > 0
+	}
+	if !found {
+		return ErrPagesNotFound
+	}
+
+	if err := s.append(segmentRecord{Crawl: crawlID, Deleted: true}); err != nil {
+		return err
+	}
+	s.forget(crawlID)
+	return nil
+}
+
+// Rewrites every sealed segment without the pages of deleted crawls or their
+// tombstones. Appends carry on in a fresh active segment while the sealed
+// segments are rewritten, the new segments only replace the old ones once the
+// index listing them is written
+func (s *SegmentPageStore) Compact() error {
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrPageStoreClosed
+	}
+	if s.segments[len(s.segments)-1].Size > 0 {
+		if err := s.roll(); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+	}
+	inputs := append([]segmentMeta{}, s.segments[:len(s.segments)-1]...)
+	dropped := map[string]struct{}{}
+	for id := range s.deleted {
+		dropped[id] = struct{}{}
+	}
+	s.mu.Unlock()
+
+	outputs, err := s.rewrite(inputs, dropped)
+	if err != nil {
+		for _, seg := range outputs {
+			os.Remove(s.segmentPath(seg.ID))
+		}
+		return err
+	}
+
+	s.mu.Lock()
+	// crawls deleted while the segments were rewritten still have their
+	// tombstone in a later segment, only their counts need dropping
+	for id := range dropped {
+		delete(s.deleted, id)
+	}
+	for id := range s.deleted {
+		for _, seg := range outputs {
+			delete(seg.Pages, id)
+		}
+	}
+	previous := s.segments
+	s.segments = append(outputs, s.segments[len(inputs):]...)
+	if err = s.writeIndex(); err != nil {
+		s.segments = previous
+		for id := range dropped {
+			s.deleted[id] = struct{}{}
+		}
+		s.mu.Unlock()
+		for _, seg := range outputs {
+			os.Remove(s.segmentPath(seg.ID))
+		}
+		return err
+	}
+	s.mu.Unlock()
+
+	for _, seg := range inputs {
+		if err = os.Remove(s.segmentPath(seg.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Syncs the active segment and writes the index, the store can't be used
+// afterwards
+func (s *SegmentPageStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	err := s.active.Sync()
+	if closeErr := s.active.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return s.writeIndex()
+}
+
+// Loads the index, or rebuilds it from the segments when it is missing, then
+// replays the records appended to the active segment since the index was
+// written. Must only be called by NewPageStore
+func (s *SegmentPageStore) open() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, segmentIndexName))
+	switch {
+	case err == nil:
+		var idx segmentIndex
+		if err = json.Unmarshal(data, &idx); err != nil {
+			return errors.Wrapf(ErrInvalidDataType, "page store index: %v", err.Error())
+		}
+		s.segments = idx.Segments
+		s.nextID = idx.NextID
+		for _, id := range idx.Deleted {
+			s.deleted[id] = struct{}{}
+		}
+	case errors.Is(err, os.ErrNotExist):
+		if err = s.rebuildIndex(); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+	if err = s.removeUnlisted(); err != nil {
+		return err
+	}
+
+	if len(s.segments) == 0 {
+		return s.roll()
+	}
+
+	last := &s.segments[len(s.segments)-1]
+	if err = s.replay(last); err != nil {
+		return err
+	}
+	s.active, err = os.OpenFile(s.segmentPath(last.ID), os.O_WRONLY|os.O_APPEND, 0o644)
+	return err
+}
+
+// Scans every segment file in ID order, used when the index is missing
+func (s *SegmentPageStore) rebuildIndex() error {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		var id int
+		if _, err := fmt.Sscanf(file.Name(), "%d"+segmentExt, &id); err != nil || file.IsDir() {
+			continue
+		}
+		s.segments = append(s.segments, segmentMeta{ID: id, Pages: map[string]int{}})
+		if id >= s.nextID {
+			s.nextID = id + 1
+		}
+	}
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].ID < s.segments[j].ID
+	})
+
+	for i := range s.segments {
+		if err = s.replay(&s.segments[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Applies the records past the indexed size of the segment. A torn record at
+// the end of the segment is truncated, a bad record followed by more data
+// means the segment is corrupt
+func (s *SegmentPageStore) replay(seg *segmentMeta) error {
+	f, err := os.OpenFile(s.segmentPath(seg.ID), os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err = f.Seek(seg.Size, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(f)
+	for {
+		rec, n, err := readRecord(r)
+		switch {
+		case err == io.EOF:
+			return nil
+		case err == nil:
+			s.apply(seg, rec, n)
+			continue
+		case errors.Is(err, errTornRecord):
+		case errors.Is(err, ErrPageStoreCorrupt):
+			// a length no record can have, or a bad record with more data
+			// after it, is only a torn tail when the rest is zeros, blocks a
+			// crash left allocated but unwritten
+			if n > recordHeaderSize+maxRecordSize || seg.Size+n < info.Size() {
+				zeros, zerosErr := isZeroFrom(f, seg.Size)
+				if zerosErr != nil {
+					return zerosErr
+				}
+				if !zeros {
+					return errors.Wrapf(err, "segment %v at offset %v", seg.ID, seg.Size)
+				}
+			}
+		default:
+			return err
+		}
+		return f.Truncate(seg.Size)
+	}
+}
+
+// Reports whether the file holds nothing but zeros from the offset on
+func isZeroFrom(f *os.File, offset int64) (bool, error) {
+	r := bufio.NewReader(io.NewSectionReader(f, offset, math.MaxInt64-offset))
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if b != 0 {
+			return false, nil
+		}
+	}
+}
+
+// Leftovers of an interrupted compaction, segments the index doesn't list,
+// are removed
+func (s *SegmentPageStore) removeUnlisted() error {
+	listed := map[string]struct{}{}
+	for _, seg := range s.segments {
+		listed[filepath.Base(s.segmentPath(seg.ID))] = struct{}{}
+	}
+
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if _, ok := listed[file.Name()]; ok || !strings.HasSuffix(file.Name(), segmentExt) {
+			continue
+		}
+		if err = os.Remove(filepath.Join(s.dir, file.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copies the live records of the segments into new ones, returning the new
+// segments in log order
+func (s *SegmentPageStore) rewrite(inputs []segmentMeta, dropped map[string]struct{}) ([]segmentMeta, error) {
+	outputs := []segmentMeta{}
+	var out *os.File
+	closeOut := func() error {
+		if out == nil {
+			return nil
+		}
+		err := out.Sync()
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		out = nil
+		return err
+	}
+	defer closeOut()
+
+	for _, in := range inputs {
+		err := s.readSegment(in.ID, in.Size, func(rec segmentRecord, _ int64) error {
+			if _, ok := dropped[rec.Crawl]; ok || rec.Deleted {
+				return nil
+			}
+
+			if out == nil || outputs[len(outputs)-1].Size >= s.segmentSize {
+				if err := closeOut(); err != nil {
+					return err
+				}
+				s.mu.Lock()
+				id := s.nextID
+				s.nextID++
+				s.mu.Unlock()
+
+				f, err := os.OpenFile(s.segmentPath(id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+				if err != nil {
+					return err
+				}
+				out = f
+				outputs = append(outputs, segmentMeta{ID: id, Pages: map[string]int{}})
+			}
+
+			n, err := writeRecord(out, rec)
+			if err != nil {
+				return err
+			}
+			s.apply(&outputs[len(outputs)-1], rec, n)
+			return nil
+		})
+		if err != nil {
+			return outputs, err
+		}
+	}
+
+	return outputs, closeOut()
+}
+
+// Reads the records of a segment up to the size, calling fn with each of them
+// and its encoded length
+func (s *SegmentPageStore) readSegment(id int, size int64, fn func(rec segmentRecord, n int64) error) error {
+	f, err := os.Open(s.segmentPath(id))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(io.LimitReader(f, size))
+	for {
+		rec, n, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		// the indexed part of a segment was fully written, so a torn record
+		// there is corruption as well
+		if errors.Is(err, errTornRecord) {
+			err = ErrPageStoreCorrupt
+		}
+		if err != nil {
+			return errors.Wrapf(err, "segment %v", id)
+		}
+		if err = fn(rec, n); err != nil {
+			return err
+		}
+	}
+}
+
+// Must be called with the lock held
+func (s *SegmentPageStore) append(rec segmentRecord) error {
+	seg := &s.segments[len(s.segments)-1]
+	n, err := writeRecord(s.active, rec)
+	if err != nil {
+		return err
+	}
+	s.apply(seg, rec, n)
+
+	if seg.Size >= s.segmentSize {
+		return s.roll()
+	}
+	return nil
+}
+
+// Accounts for a record in the segment and the store, must be called with the
+// lock held unless the segment is not listed yet
+func (s *SegmentPageStore) apply(seg *segmentMeta, rec segmentRecord, n int64) {
+	seg.Size += n
+	if rec.Deleted {
+		s.forget(rec.Crawl)
+		return
+	}
+	if seg.Pages == nil {
+		seg.Pages = map[string]int{}
+	}
+	seg.Pages[rec.Crawl]++
+}
+
+// Drops the counts of a deleted crawl, must be called with the lock held
+func (s *SegmentPageStore) forget(crawlID string) {
+	for _, seg := range s.segments {
+		delete(seg.Pages, crawlID)
+	}
+	s.deleted[crawlID] = struct{}{}
+}
+
+// Seals the active segment and starts a new one, the index is written so the
+// next open only replays the new segment. Must be called with the lock held
+func (s *SegmentPageStore) roll() error {
+	if s.active != nil {
+		err := s.active.Sync()
+		if closeErr := s.active.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	id := s.nextID
+	f, err := os.OpenFile(s.segmentPath(id), os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.nextID++
+	s.active = f
+	s.segments = append(s.segments, segmentMeta{ID: id, Pages: map[string]int{}})
+
+	return s.writeIndex()
+}
+
+// Must be called with the lock held
+func (s *SegmentPageStore) writeIndex() error {
+	idx := segmentIndex{Segments: s.segments, Deleted: []string{}, NextID: s.nextID}
+	for id := range s.deleted {
+		idx.Deleted = append(idx.Deleted, id)
+	}
+	sort.Strings(idx.Deleted)
+
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(filepath.Join(s.dir, segmentIndexName), data)
+}
+
+func (s *SegmentPageStore) segmentPath(id int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%08d%s", id, segmentExt))
+}
+
+// Writes the record in a single call, returning its encoded length
+func writeRecord(w io.Writer, rec segmentRecord) (int64, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return 0, err
+	}
+	if len(payload) > maxRecordSize {
+		return 0, errors.Wrapf(ErrInvalidDataType, "page record of %v bytes", len(payload))
+	}
+
+	buf := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	copy(buf[recordHeaderSize:], payload)
+
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+// Returns io.EOF at the clean end of a segment, errTornRecord for a record the
+// segment ends partway through and ErrPageStoreCorrupt for a record that fails
+// its checks. The length of the record is returned along with a corrupt
+// record, as far as its header tells
+func readRecord(r io.Reader) (segmentRecord, int64, error) {
+	var rec segmentRecord
+
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(r, header); err == io.EOF {
+		return rec, 0, io.EOF
+	} else if err == io.ErrUnexpectedEOF {
+		return rec, 0, errTornRecord
+	} else if err != nil {
+		return rec, 0, err
+	}
+
+	size := binary.BigEndian.Uint32(header[0:4])
+	n := int64(recordHeaderSize) + int64(size)
+	if size > maxRecordSize {
+		return rec, n, errors.Wrapf(ErrPageStoreCorrupt, "record of %v bytes", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return rec, 0, errTornRecord
+	} else if err != nil {
+		return rec, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return rec, n, errors.Wrap(ErrPageStoreCorrupt, "record checksum mismatch")
+	}
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return rec, n, errors.Wrap(ErrPageStoreCorrupt, err.Error())
+	}
+
+	return rec, n, nil
+}
+
+// Returns the hook writing the pages of a crawl to the page store, nil when
+// no page store is configured. A page that can't be written is still part of
+// the crawl record, so the error is only logged
+func (s *crawlerService) pageWriter(id string) func(page instance.PageRecord) {
+	if s.pageStore == nil {
+		return nil
+	}
+
+	return func(page instance.PageRecord) {
+		if err := s.pageStore.Append(id, page); err != nil {
+			s.logger.Sugar().Warnf("unable to store page %v of crawl %v: %v", page.URL, id, err.Error())
+		}
+	}
+}
+
+// Removes the pages of a deleted crawl from the page store
+func (s *crawlerService) deletePages(id string) {
+	if s.pageStore == nil {
+		return
+	}
+	if err := s.pageStore.Delete(id); err != nil && !errors.Is(err, ErrPagesNotFound) {
+		s.logger.Sugar().Warnf("unable to delete pages of crawl %v: %v", id, err.Error())
+	}
+}
+
+// Removes the pages of a crawl that was not saved, unless a checkpoint still
+// allows the crawl to be resumed and saved later
+func (s *crawlerService) discardPages(id string) {
+	if s.checkpointRepo != nil {
+		if _, err := s.checkpointRepo.Get(id); err == nil {
+			return
+		}
+	}
+	s.deletePages(id)
+}
+
+// Fills in the page records of a crawl saved without them, sorted by URL like
+// the records of a crawl. Only used where every page is needed at once, e.g.
+// to diff or export crawls
+func (s *crawlerService) loadPages(crawlRec *Metadata) error {
+	if s.pageStore == nil || len(crawlRec.Pages) > 0 {
+		return nil
+	}
+
+	pages := []instance.PageRecord{}
+	err := s.pageStore.ScanPages(crawlRec.ID, func(page instance.PageRecord) error {
+		pages = append(pages, page)
+		return nil
+	})
+	if err != nil && errors.Is(err, ErrPagesNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].URL < pages[j].URL
+	})
+	crawlRec.Pages = pages
+	return nil
+}
+
+// Reclaims the space of deleted pages, run after crawls are pruned
+func (s *crawlerService) compactPages() {
+	if s.pageStore == nil {
+		return
+	}
+	if err := s.pageStore.Compact(); err != nil {
+		s.logger.Sugar().Warnf("unable to compact page store: %v", err.Error())
+	}
+}
+
+// This service method calls fn with every page of a stored crawl, streaming
+// them from the page store when it holds the crawl. Crawls stored before the
+// page store was configured are read from their crawl record
+func (s *crawlerService) StreamPages(id string, fn func(page instance.PageRecord) error) error {
+	if s.pageStore != nil {
+		err := s.pageStore.ScanPages(id, fn)
+		if err == nil || !errors.Is(err, ErrPagesNotFound) {
+			return err
+		}
+	}
+
+	crawlRec, err := s.crawlerRepo.GetCrawlByID(context.Background(), id)
+	if err != nil && errors.Is(err, ErrRecordNotFound) {
+		return ErrSvcRecordNotFound
+	} else if err != nil {
+		return err
+	}
+	for _, page := range crawlRec.Pages {
+		if err = fn(page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,347 @@
+package crawler_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sjain93/web-crawler-go/config"
+	"github.com/sjain93/web-crawler-go/src/crawler"
+	"github.com/sjain93/web-crawler-go/src/crawler/instance"
+	"github.com/sjain93/web-crawler-go/src/crawler/instance/instancetest"
+	"github.com/stretchr/testify/assert"
+)
+
+// length and CRC in front of every segment record
+const recordHeader = 8
+
+func scanURLs(t *testing.T, store crawler.PageStoreManager, crawlID string) []string {
+	urls := []string{}
+	err := store.ScanPages(crawlID, func(page instance.PageRecord) error {
+		urls = append(urls, page.URL)
+		return nil
+	})
+	assert.NoError(t, err)
+	return urls
+}
+
+func appendPages(t *testing.T, store crawler.PageStoreManager, crawlID string, n int) []string {
+	urls := []string{}
+	for i := 0; i < n; i++ {
+		url := fmt.Sprintf("https://%v/page-%03d/", crawlID, i)
+		assert.NoError(t, store.Append(crawlID, instance.PageRecord{URL: url, StatusCode: http.StatusOK}))
+		urls = append(urls, url)
+	}
+	return urls
+}
+
+func TestPageStore(t *testing.T) {
+	t.Run("Pages stream back in order across segments", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := crawler.NewPageStore(dir, 512)
+		assert.NoError(t, err)
+
+		monzo := appendPages(t, store, "monzo.com", 20)
+		koho := appendPages(t, store, "www.koho.ca", 5)
+		// a page fetched again after a resume is only returned once
+		assert.NoError(t, store.Append("monzo.com", instance.PageRecord{URL: monzo[0]}))
+
+		segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+		assert.Greater(t, len(segments), 1)
+		assert.Equal(t, monzo, scanURLs(t, store, "monzo.com"))
+		assert.Equal(t, koho, scanURLs(t, store, "www.koho.ca"))
+
+		err = store.ScanPages("spacy.io", func(instance.PageRecord) error { return nil })
+		assert.ErrorIs(t, err, crawler.ErrPagesNotFound)
+
+		// the scan stops at the first error
+		stop := fmt.Errorf("stop")
+		seen := 0
+		err = store.ScanPages("monzo.com", func(instance.PageRecord) error {
+			seen++
+			return stop
+		})
+		assert.Equal(t, stop, err)
+		assert.Equal(t, 1, seen)
+
+		assert.NoError(t, store.Close())
+		reopened, err := crawler.NewPageStore(dir, 512)
+		assert.NoError(t, err)
+		assert.Equal(t, monzo, scanURLs(t, reopened, "monzo.com"))
+		assert.NoError(t, reopened.Close())
+	})
+
+	t.Run("Torn records are cut off", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := crawler.NewPageStore(dir, 0)
+		assert.NoError(t, err)
+		urls := appendPages(t, store, "monzo.com", 3)
+
+		// a crash while writing leaves part of a record behind, the store is
+		// not closed so the records are replayed from the segment
+		segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+		f, err := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0o644)
+		assert.NoError(t, err)
+		_, err = f.Write([]byte{0, 0, 1, 0, 0xde, 0xad, '{', '"'})
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+
+		reopened, err := crawler.NewPageStore(dir, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, urls, scanURLs(t, reopened, "monzo.com"))
+
+		more := appendPages(t, reopened, "www.koho.ca", 2)
+		assert.Equal(t, more, scanURLs(t, reopened, "www.koho.ca"))
+	})
+
+	t.Run("Zeros left at the end by a crash are cut off", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := crawler.NewPageStore(dir, 0)
+		assert.NoError(t, err)
+		urls := appendPages(t, store, "monzo.com", 3)
+
+		segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+		f, err := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0o644)
+		assert.NoError(t, err)
+		_, err = f.Write(make([]byte, 4096))
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+
+		reopened, err := crawler.NewPageStore(dir, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, urls, scanURLs(t, reopened, "monzo.com"))
+	})
+
+	t.Run("Corrupt records before the end are reported", func(t *testing.T) {
+		testCases := map[string]struct {
+			offset int64
+			data   []byte
+		}{
+			"Checksum mismatch": {offset: recordHeader + 2, data: []byte("x")},
+			"Oversized length":  {offset: 0, data: []byte{0xff, 0xff, 0xff, 0xff}},
+		}
+
+		for name, tc := range testCases {
+			t.Run(name, func(t *testing.T) {
+				dir := t.TempDir()
+				store, err := crawler.NewPageStore(dir, 0)
+				assert.NoError(t, err)
+				appendPages(t, store, "monzo.com", 3)
+
+				// the first record is damaged while the ones after it are intact
+				segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+				f, err := os.OpenFile(segments[len(segments)-1], os.O_WRONLY, 0o644)
+				assert.NoError(t, err)
+				_, err = f.WriteAt(tc.data, tc.offset)
+				assert.NoError(t, err)
+				assert.NoError(t, f.Close())
+
+				_, err = crawler.NewPageStore(dir, 0)
+				assert.ErrorIs(t, err, crawler.ErrPageStoreCorrupt)
+			})
+		}
+	})
+
+	t.Run("Deleted pages are dropped by compaction", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := crawler.NewPageStore(dir, 512)
+		assert.NoError(t, err)
+
+		monzo := appendPages(t, store, "monzo.com", 20)
+		appendPages(t, store, "www.koho.ca", 20)
+		assert.NoError(t, store.Delete("www.koho.ca"))
+		assert.ErrorIs(t, store.Delete("www.koho.ca"), crawler.ErrPagesNotFound)
+		assert.ErrorIs(t, store.Append("www.koho.ca", instance.PageRecord{}), crawler.ErrPagesDeleted)
+
+		err = store.ScanPages("www.koho.ca", func(instance.PageRecord) error { return nil })
+		assert.ErrorIs(t, err, crawler.ErrPagesNotFound)
+
+		before := dirSize(t, dir)
+		assert.NoError(t, store.Compact())
+		assert.Less(t, dirSize(t, dir), before)
+		assert.Equal(t, monzo, scanURLs(t, store, "monzo.com"))
+
+		// the crawl ID can be used again once its pages are gone
+		koho := appendPages(t, store, "www.koho.ca", 1)
+		assert.Equal(t, koho, scanURLs(t, store, "www.koho.ca"))
+
+		assert.NoError(t, store.Close())
+		reopened, err := crawler.NewPageStore(dir, 512)
+		assert.NoError(t, err)
+		assert.Equal(t, monzo, scanURLs(t, reopened, "monzo.com"))
+		assert.Equal(t, koho, scanURLs(t, reopened, "www.koho.ca"))
+	})
+
+	t.Run("Missing index is rebuilt", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := crawler.NewPageStore(dir, 512)
+		assert.NoError(t, err)
+
+		monzo := appendPages(t, store, "monzo.com", 10)
+		appendPages(t, store, "www.koho.ca", 10)
+		assert.NoError(t, store.Delete("www.koho.ca"))
+		assert.NoError(t, store.Close())
+		assert.NoError(t, os.Remove(filepath.Join(dir, "index.json")))
+
+		reopened, err := crawler.NewPageStore(dir, 512)
+		assert.NoError(t, err)
+		assert.Equal(t, monzo, scanURLs(t, reopened, "monzo.com"))
+		err = reopened.ScanPages("www.koho.ca", func(instance.PageRecord) error { return nil })
+		assert.ErrorIs(t, err, crawler.ErrPagesNotFound)
+	})
+}
+
+func TestStreamPages(t *testing.T) {
+	inMemDB := config.GetInMemoryStore[crawler.Metadata]()
+	preLoad(inMemDB, crawler.Metadata{
+		ID:    "1f9b0a52-7a3e-4d8e-9d67-3e7c2b5f4a10",
+		Host:  "monzo.com",
+		Pages: []instance.PageRecord{{URL: "https://monzo.com/", StatusCode: http.StatusOK}},
+	})
+	crawlerRepo, err := crawler.NewCrawlerRepository(inMemDB)
+	assert.NoError(t, err)
+
+	pageStore, err := crawler.NewPageStore(t.TempDir(), 0)
+	assert.NoError(t, err)
+	defer pageStore.Close()
+
+	factory := &instancetest.Factory{
+		Links: []string{"https://www.koho.ca/", "https://www.koho.ca/pricing/"},
+	}
+	crawlerSvc, err := crawler.NewCrawlerService(
+		crawler.WithRepository(crawlerRepo),
+		crawler.WithPageStore(pageStore),
+		crawler.WithCrawlerFactory(factory),
+	)
+	assert.NoError(t, err)
+
+	streamed := func(id string) ([]string, error) {
+		urls := []string{}
+		err := crawlerSvc.StreamPages(id, func(page instance.PageRecord) error {
+			urls = append(urls, page.URL)
+			return nil
+		})
+		return urls, err
+	}
+
+	crawls, err := crawlerSvc.CrawlSite(crawler.Metadata{InitialURL: "https://www.koho.ca/"}, crawler.CrawlOptions{})
+	assert.NoError(t, err)
+	id := crawls[0].ID
+
+	// pages of the new crawl were written to the page store while crawling
+	urls := []string{}
+	assert.NoError(t, pageStore.ScanPages(id, func(page instance.PageRecord) error {
+		urls = append(urls, page.URL)
+		return nil
+	}))
+	assert.Equal(t, []string{"https://www.koho.ca/", "https://www.koho.ca/pricing/"}, urls)
+
+	urls, err = streamed(id)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"https://www.koho.ca/", "https://www.koho.ca/pricing/"}, urls)
+
+	// the pages are only kept in the page store
+	assert.Len(t, crawls[0].Pages, 2)
+	stored, err := crawlerRepo.GetCrawlByID(context.Background(), id)
+	assert.NoError(t, err)
+	assert.Empty(t, stored.Pages)
+	assert.Len(t, stored.CrawlResultSet, 2)
+
+	t.Run("Pages are read back where needed", func(t *testing.T) {
+		factory.Pages = []instance.PageRecord{
+			{URL: "https://www.koho.ca/", StatusCode: http.StatusOK},
+			{URL: "https://www.koho.ca/pricing/", StatusCode: http.StatusNotFound},
+		}
+		defer func() { factory.Pages = nil }()
+		recrawls, err := crawlerSvc.CrawlSite(
+			crawler.Metadata{InitialURL: "https://www.koho.ca/"},
+			crawler.CrawlOptions{ForceRefresh: true},
+		)
+		assert.NoError(t, err)
+
+		diff, err := crawlerSvc.DiffCrawls(id, recrawls[0].ID)
+		assert.NoError(t, err)
+		if assert.Len(t, diff.StatusChanges, 1) {
+			assert.Equal(t, http.StatusNotFound, diff.StatusChanges[0].After)
+		}
+
+		matches, err := crawlerSvc.SearchCrawls(crawler.PageSearch{
+			Mode:    crawler.SearchExact,
+			Pattern: "https://www.koho.ca/pricing/",
+		})
+		assert.NoError(t, err)
+		if assert.Len(t, matches, 2) {
+			assert.Equal(t, http.StatusOK, matches[0].Page.StatusCode)
+			assert.Equal(t, http.StatusNotFound, matches[1].Page.StatusCode)
+		}
+
+		archive := &bytes.Buffer{}
+		_, err = crawlerSvc.ExportCrawls(archive, id)
+		assert.NoError(t, err)
+		target, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+		assert.NoError(t, err)
+		_, err = crawler.ImportArchive(context.Background(), target, archive, crawler.CollisionFail)
+		assert.NoError(t, err)
+		imported, err := target.GetCrawlByID(context.Background(), id)
+		assert.NoError(t, err)
+		assert.Len(t, imported.Pages, 2)
+
+		assert.NoError(t, crawlerSvc.DeleteCrawl(recrawls[0].ID))
+	})
+
+	// crawls stored without the page store fall back to their record
+	urls, err = streamed("1f9b0a52-7a3e-4d8e-9d67-3e7c2b5f4a10")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"https://monzo.com/"}, urls)
+
+	assert.NoError(t, crawlerSvc.DeleteCrawl(id))
+	_, err = streamed(id)
+	assert.ErrorIs(t, err, crawler.ErrSvcRecordNotFound)
+}
+
+// A repository that refuses every crawl as a duplicate
+type conflictRepo struct {
+	crawler.CrawlerRepoManager
+}
+
+func (r conflictRepo) Save(ctx context.Context, crawlRec *crawler.Metadata) error {
+	return fmt.Errorf("save %v: %w", crawlRec.ID, crawler.ErrUniqueKeyViolated)
+}
+
+func TestUnsavedCrawlPages(t *testing.T) {
+	crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+	assert.NoError(t, err)
+	pageStore, err := crawler.NewPageStore(t.TempDir(), 0)
+	assert.NoError(t, err)
+	defer pageStore.Close()
+
+	crawlerSvc, err := crawler.NewCrawlerService(
+		crawler.WithRepository(conflictRepo{crawlerRepo}),
+		crawler.WithPageStore(pageStore),
+		crawler.WithCrawlerFactory(&instancetest.Factory{}),
+	)
+	assert.NoError(t, err)
+
+	crawls, err := crawlerSvc.CrawlSite(crawler.Metadata{InitialURL: "https://monzo.com/"}, crawler.CrawlOptions{})
+	assert.ErrorIs(t, err, crawler.ErrSvcRecordExists)
+
+	// the pages of the crawl that was not saved are dropped
+	err = pageStore.ScanPages(crawls[0].ID, func(instance.PageRecord) error { return nil })
+	assert.ErrorIs(t, err, crawler.ErrPagesNotFound)
+}
+
+func dirSize(t *testing.T, dir string) int64 {
+	var size int64
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		assert.NoError(t, err)
+		size += info.Size()
+	}
+	return size
+}
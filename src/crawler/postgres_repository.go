@@ -0,0 +1,540 @@
+package crawler
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/sjain93/web-crawler-go/src/crawler/instance"
+)
+
+// schema migrations, applied in file name order
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+const (
+	crawlColumns = `id, initial_url, host, created_at, fingerprint,
+	scope, max_depth, max_pages, workers, timeout_ns, has_pages`
+	// Postgres refuses statements with more bind parameters than this
+	maxBindParams = 65535
+)
+
+// PostgresRepository stores crawls in Postgres through database/sql. The
+// driver is not linked in by this package, the *sql.DB is opened by the
+// caller with whichever driver the binary registers
+type PostgresRepository struct {
+	db *sql.DB
+}
+
+// Create a new Postgres repository, any migration not applied yet is run
+// before it is returned
+func NewPostgresRepository(ctx context.Context, db *sql.DB) (CrawlerRepoManager, error) {
+	if db == nil {
+		return &PostgresRepository{}, ErrNoDatastore
+	}
+
+	r := &PostgresRepository{db: db}
+	if err := r.migrate(ctx); err != nil {
+		return &PostgresRepository{}, errors.Wrap(err, "migrating crawl schema")
+	}
+	return r, nil
+}
+
+// Records a crawl and its pages, their parents and its errors in one
+// transaction
+func (r *PostgresRepository) Save(ctx context.Context, crawlRec *Metadata) error {
+	if err := r.save(ctx, crawlRec); err != nil {
+		var repoErr *RepoError
+		if errors.As(err, &repoErr) {
+			return err
+		}
+		return sqlError("save", crawlRec.ID, err)
+	}
+	return nil
+}
+
+func (r *PostgresRepository) save(ctx context.Context, crawlRec *Metadata) error {
+	// Postgres keeps timestamps to the microsecond
+	createdAt := creationTime(*crawlRec).Truncate(time.Microsecond)
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `INSERT INTO crawls (`+crawlColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (id) DO NOTHING`,
+		crawlRec.ID, crawlRec.InitialURL, crawlRec.Host, createdAt, crawlRec.Fingerprint,
+		crawlRec.Options.Scope, crawlRec.Options.MaxDepth, crawlRec.Options.MaxPages,
+		int64(crawlRec.Options.Workers), int64(crawlRec.Options.Timeout), len(crawlRec.Pages) > 0,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return conflictError("save", crawlRec.ID)
+	}
+
+	pages := map[string]instance.PageRecord{}
+	for _, page := range crawlRec.Pages {
+		pages[page.URL] = page
+	}
+	positions := map[string]int{}
+	for i, url := range crawlRec.CrawlResultSet {
+		if _, ok := positions[url]; !ok {
+			positions[url] = i
+		}
+	}
+
+	urls := make([]string, 0, len(pages)+len(positions))
+	for url := range pages {
+		urls = append(urls, url)
+	}
+	for url := range positions {
+		if _, ok := pages[url]; !ok {
+			urls = append(urls, url)
+		}
+	}
+	sort.Strings(urls)
+
+	pageRows := make([][]interface{}, 0, len(urls))
+	parentRows := [][]interface{}{}
+	for _, url := range urls {
+		var position sql.NullInt64
+		if i, ok := positions[url]; ok {
+			position = sql.NullInt64{Int64: int64(i), Valid: true}
+		}
+		page, fetched := pages[url]
+
+		pageRows = append(pageRows, []interface{}{
+			crawlRec.ID, url, position, fetched, page.Depth, page.StatusCode,
+			page.ContentType, page.Size, int64(page.ResponseTime), page.RedirectURL, page.Error,
+		})
+		if fetched && page.Parent != "" {
+			parentRows = append(parentRows, []interface{}{crawlRec.ID, page.URL, page.Parent})
+		}
+	}
+	errRows := make([][]interface{}, 0, len(crawlRec.ErrList))
+	for i, crawlErr := range crawlRec.ErrList {
+		errRows = append(errRows, []interface{}{crawlRec.ID, i, crawlErr.Error()})
+	}
+
+	if err = insertRows(ctx, tx, `pages (crawl_id, url, result_position, fetched, depth,
+		status_code, content_type, size, response_time_ns, redirect_url, error)`, 11, pageRows); err != nil {
+		return err
+	}
+	if err = insertRows(ctx, tx, `page_parents (crawl_id, url, parent_url)`, 3, parentRows); err != nil {
+		return err
+	}
+	if err = insertRows(ctx, tx, `errors (crawl_id, position, message)`, 3, errRows); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	crawlRec.CreatedAt = createdAt
+	return nil
+}
+
+func (r *PostgresRepository) GetCrawlByID(ctx context.Context, id string) (Metadata, error) {
+	crawls, err := r.queryCrawls(ctx, `SELECT `+crawlColumns+` FROM crawls WHERE id = $1`, id)
+	if err != nil {
+		return Metadata{}, sqlError("get", id, err)
+	}
+	if len(crawls) == 0 {
+		return Metadata{}, notFoundError("get", id)
+	}
+
+	return crawls[0], nil
+}
+
+func (r *PostgresRepository) GetCrawlHistory(ctx context.Context) ([]Metadata, error) {
+	crawls, err := r.queryCrawls(ctx, `SELECT `+crawlColumns+` FROM crawls ORDER BY created_at, id`)
+	if err != nil {
+		return []Metadata{}, sqlError("history", "", err)
+	}
+	return crawls, nil
+}
+
+// Lists every stored crawl from the crawls table alone, pages and errors are
+// not loaded
+func (r *PostgresRepository) ListCrawls(ctx context.Context) ([]CrawlRef, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT id, host, created_at FROM crawls ORDER BY created_at, id`)
+	if err != nil {
+		return []CrawlRef{}, sqlError("list", "", err)
+	}
+	defer rows.Close()
+
+	crawls := []CrawlRef{}
+	for rows.Next() {
+		var crawl CrawlRef
+		if err = rows.Scan(&crawl.ID, &crawl.Host, &crawl.CreatedAt); err != nil {
+			return []CrawlRef{}, sqlError("list", "", err)
+		}
+		crawl.CreatedAt = crawl.CreatedAt.UTC()
+		crawls = append(crawls, crawl)
+	}
+	if err = rows.Err(); err != nil {
+		return []CrawlRef{}, sqlError("list", "", err)
+	}
+
+	return crawls, nil
+}
+
+// Returns the crawls of a host, most recent first
+func (r *PostgresRepository) GetCrawlsByHost(ctx context.Context, host string) ([]Metadata, error) {
+	crawls, err := r.queryCrawls(ctx, `SELECT `+crawlColumns+` FROM crawls WHERE host = $1
+		ORDER BY created_at DESC, id`, host)
+	if err != nil {
+		return []Metadata{}, sqlError("get by host", "", err)
+	}
+	return crawls, nil
+}
+
+// Pages, page parents and errors of the crawl go with it through the foreign
+// keys
+func (r *PostgresRepository) Delete(ctx context.Context, id string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM crawls WHERE id = $1`, id)
+	if err != nil {
+		return sqlError("delete", id, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return sqlError("delete", id, err)
+	} else if n == 0 {
+		return notFoundError("delete", id)
+	}
+	return nil
+}
+
+// Exact and prefix searches use the URL index. Regex searches are matched by
+// Postgres, whose regular expressions differ from Go's in a few rarely used
+// constructs
+func (r *PostgresRepository) SearchPages(ctx context.Context, q PageSearch) ([]PageMatch, error) {
+	match, err := newURLMatcher(q)
+	if err != nil {
+		return []PageMatch{}, newRepoError(KindInvalid, "search", "", err)
+	}
+	matches, err := r.searchPages(ctx, match)
+	if err != nil {
+		return []PageMatch{}, sqlError("search", "", err)
+	}
+	return matches, nil
+}
+
+func (r *PostgresRepository) searchPages(ctx context.Context, match urlMatcher) ([]PageMatch, error) {
+	var cond, arg string
+	switch match.mode {
+	case SearchExact:
+		cond, arg = `p.url = $1`, match.pattern
+	case SearchPrefix:
+		cond, arg = `p.url LIKE $1 ESCAPE '\'`, escapeLike(match.pattern)+"%"
+	default:
+		cond, arg = `p.url ~ $1`, match.pattern
+	}
+
+	// crawls stored with page records are searched on the pages they fetched,
+	// the others on their result set, like the in-memory index
+	rows, err := r.db.QueryContext(ctx, `SELECT p.crawl_id, c.created_at, p.url, p.depth, p.status_code,
+			p.content_type, p.size, p.response_time_ns, p.redirect_url, p.error,
+			COALESCE(pp.parent_url, ''), p.fetched
+		FROM pages p
+		JOIN crawls c ON c.id = p.crawl_id
+		LEFT JOIN page_parents pp ON pp.crawl_id = p.crawl_id AND pp.url = p.url
+		WHERE (p.fetched OR NOT c.has_pages) AND `+cond, arg)
+	if err != nil {
+		return []PageMatch{}, err
+	}
+	defer rows.Close()
+
+	matches := []PageMatch{}
+	for rows.Next() {
+		var (
+			m            PageMatch
+			responseTime int64
+			fetched      bool
+		)
+		err = rows.Scan(&m.CrawlID, &m.CreatedAt, &m.Page.URL, &m.Page.Depth, &m.Page.StatusCode,
+			&m.Page.ContentType, &m.Page.Size, &responseTime, &m.Page.RedirectURL, &m.Page.Error,
+			&m.Page.Parent, &fetched,
+		)
+		if err != nil {
+			return []PageMatch{}, err
+		}
+		m.CreatedAt = m.CreatedAt.UTC()
+		if fetched {
+			m.Page.ResponseTime = time.Duration(responseTime)
+		} else {
+			m.Page = instance.PageRecord{URL: m.Page.URL}
+		}
+		matches = append(matches, m)
+	}
+	if err = rows.Err(); err != nil {
+		return []PageMatch{}, err
+	}
+	sortPageMatches(matches)
+
+	return matches, nil
+}
+
+// Runs the crawl query and fills in the pages and errors of every crawl it
+// returns, with one query each for all the crawls
+func (r *PostgresRepository) queryCrawls(ctx context.Context, query string, args ...interface{}) ([]Metadata, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return []Metadata{}, err
+	}
+	defer rows.Close()
+
+	crawls := []Metadata{}
+	// position of each crawl in crawls, keyed by ID
+	byID := map[string]int{}
+	ids := []string{}
+	for rows.Next() {
+		var (
+			crawlRec         Metadata
+			workers, timeout int64
+			hasPages         bool
+		)
+		err = rows.Scan(&crawlRec.ID, &crawlRec.InitialURL, &crawlRec.Host, &crawlRec.CreatedAt,
+			&crawlRec.Fingerprint, &crawlRec.Options.Scope, &crawlRec.Options.MaxDepth,
+			&crawlRec.Options.MaxPages, &workers, &timeout, &hasPages,
+		)
+		if err != nil {
+			return []Metadata{}, err
+		}
+		crawlRec.CreatedAt = crawlRec.CreatedAt.UTC()
+		crawlRec.Options.Workers = uint(workers)
+		crawlRec.Options.Timeout = time.Duration(timeout)
+		// crawls saved with only their result set come back the same way
+		if hasPages {
+			crawlRec.Pages = []instance.PageRecord{}
+		}
+		crawlRec.CrawlResultSet = []string{}
+		crawlRec.ErrList = []error{}
+
+		byID[crawlRec.ID] = len(crawls)
+		ids = append(ids, crawlRec.ID)
+		crawls = append(crawls, crawlRec)
+	}
+	if err = rows.Err(); err != nil {
+		return []Metadata{}, err
+	}
+	rows.Close()
+
+	if len(crawls) == 0 {
+		return crawls, nil
+	}
+	if err = r.loadPages(ctx, crawls, byID, ids); err != nil {
+		return []Metadata{}, err
+	}
+	if err = r.loadErrors(ctx, crawls, byID, ids); err != nil {
+		return []Metadata{}, err
+	}
+
+	return crawls, nil
+}
+
+// Fills in the result set and fetched pages of the crawls. Pages come back
+// ordered by URL and the result set is rebuilt from their positions
+func (r *PostgresRepository) loadPages(ctx context.Context, crawls []Metadata, byID map[string]int, ids []string) error {
+	rows, err := r.db.QueryContext(ctx, `SELECT p.crawl_id, p.url, p.result_position, p.fetched, p.depth,
+			p.status_code, p.content_type, p.size, p.response_time_ns, p.redirect_url, p.error,
+			COALESCE(pp.parent_url, '')
+		FROM pages p
+		LEFT JOIN page_parents pp ON pp.crawl_id = p.crawl_id AND pp.url = p.url
+		WHERE p.crawl_id = ANY($1::text[])
+		ORDER BY p.crawl_id, p.url`, textArray(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type resultURL struct {
+		url      string
+		position int64
+	}
+	results := map[string][]resultURL{}
+	for rows.Next() {
+		var (
+			crawlID      string
+			page         instance.PageRecord
+			position     sql.NullInt64
+			fetched      bool
+			responseTime int64
+		)
+		err = rows.Scan(&crawlID, &page.URL, &position, &fetched, &page.Depth, &page.StatusCode,
+			&page.ContentType, &page.Size, &responseTime, &page.RedirectURL, &page.Error,
+			&page.Parent,
+		)
+		if err != nil {
+			return err
+		}
+		if position.Valid {
+			results[crawlID] = append(results[crawlID], resultURL{url: page.URL, position: position.Int64})
+		}
+		if crawlRec := &crawls[byID[crawlID]]; fetched && crawlRec.Pages != nil {
+			page.ResponseTime = time.Duration(responseTime)
+			crawlRec.Pages = append(crawlRec.Pages, page)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for crawlID, res := range results {
+		sort.Slice(res, func(i, j int) bool {
+			return res[i].position < res[j].position
+		})
+		crawlRec := &crawls[byID[crawlID]]
+		for _, result := range res {
+			crawlRec.CrawlResultSet = append(crawlRec.CrawlResultSet, result.url)
+		}
+	}
+
+	return nil
+}
+
+func (r *PostgresRepository) loadErrors(ctx context.Context, crawls []Metadata, byID map[string]int, ids []string) error {
+	rows, err := r.db.QueryContext(ctx, `SELECT crawl_id, message FROM errors
+		WHERE crawl_id = ANY($1::text[])
+		ORDER BY crawl_id, position`, textArray(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var crawlID, msg string
+		if err = rows.Scan(&crawlID, &msg); err != nil {
+			return err
+		}
+		crawlRec := &crawls[byID[crawlID]]
+		crawlRec.ErrList = append(crawlRec.ErrList, errors.New(msg))
+	}
+	return rows.Err()
+}
+
+// Inserts the rows into the table in multi-row statements, each kept under
+// the Postgres limit on bind parameters. The table is given with its columns
+func insertRows(ctx context.Context, tx *sql.Tx, table string, columns int, rows [][]interface{}) error {
+	perStatement := maxBindParams / columns
+	for len(rows) > 0 {
+		batch := rows
+		if len(batch) > perStatement {
+			batch = batch[:perStatement]
+		}
+		rows = rows[len(batch):]
+
+		var query strings.Builder
+		query.WriteString("INSERT INTO " + table + " VALUES ")
+		args := make([]interface{}, 0, len(batch)*columns)
+		for i, row := range batch {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			query.WriteString("(")
+			for j := range row {
+				if j > 0 {
+					query.WriteString(", ")
+				}
+				args = append(args, row[j])
+				query.WriteString("$" + strconv.Itoa(len(args)))
+			}
+			query.WriteString(")")
+		}
+
+		if _, err := tx.ExecContext(ctx, query.String(), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Formats the values as a Postgres text array literal, so they can be bound
+// as a single parameter without the driver's array support
+func textArray(values []string) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	quoted := make([]string, 0, len(values))
+	for _, v := range values {
+		quoted = append(quoted, `"`+escape.Replace(v)+`"`)
+	}
+	return "{" + strings.Join(quoted, ",") + "}"
+}
+
+// Applies every embedded migration not recorded in schema_migrations, each in
+// its own transaction
+func (r *PostgresRepository) migrate(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    TEXT PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		version := strings.TrimSuffix(strings.TrimPrefix(name, "migrations/"), ".sql")
+		if err = r.applyMigration(ctx, version, name); err != nil {
+			return errors.Wrap(err, version)
+		}
+	}
+	return nil
+}
+
+func (r *PostgresRepository) applyMigration(ctx context.Context, version, name string) error {
+	script, err := migrations.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// concurrent starts wait here rather than applying the same migration twice
+	if _, err = tx.ExecContext(ctx, `LOCK TABLE schema_migrations IN EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+	var applied bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, version).Scan(&applied)
+	if err != nil || applied {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, string(script)); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES ($1, $2)`,
+		version, time.Now().UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Escapes the LIKE wildcards of a prefix so it is matched literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
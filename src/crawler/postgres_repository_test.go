@@ -0,0 +1,152 @@
+//go:build integration
+
+package crawler_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+
+	_ "github.com/lib/pq"
+	"github.com/sjain93/web-crawler-go/config"
+	"github.com/sjain93/web-crawler-go/src/crawler"
+	"github.com/sjain93/web-crawler-go/src/crawler/instance"
+	"github.com/sjain93/web-crawler-go/src/crawler/repotest"
+	"github.com/stretchr/testify/assert"
+)
+
+// Opens the database described by the CRAWLER_POSTGRES_* settings and empties
+// the crawl tables, the tests are skipped when it can't be reached
+func openTestDB(t *testing.T) *sql.DB {
+	settings, err := config.GetPostgresSettings()
+	assert.NoError(t, err)
+
+	db, err := sql.Open(settings.Driver, settings.ConnString())
+	if err != nil {
+		t.Fatalf("opening postgres: %v", err)
+	}
+	if err = db.Ping(); err != nil {
+		t.Skipf("postgres not reachable: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`DROP TABLE IF EXISTS errors, edges, page_parents, pages, crawls, schema_migrations`)
+	assert.NoError(t, err)
+	return db
+}
+
+func TestPostgresRepositoryConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) crawler.CrawlerRepoManager {
+		crawlerRepo, err := crawler.NewPostgresRepository(context.Background(), openTestDB(t))
+		assert.NoError(t, err)
+		return crawlerRepo
+	})
+}
+
+func TestPostgresRepository(t *testing.T) {
+	db := openTestDB(t)
+
+	crawlerRepo, err := crawler.NewPostgresRepository(context.Background(), db)
+	assert.NoError(t, err)
+
+	crawlRec := crawler.Metadata{
+		ID:             "monzo-2",
+		InitialURL:     "https://monzo.com/",
+		Host:           "monzo.com",
+		CrawlResultSet: []string{"https://monzo.com/", "https://monzo.com/100%_pricing/"},
+		Pages: []instance.PageRecord{
+			{URL: "https://monzo.com/", StatusCode: http.StatusOK},
+			{URL: "https://monzo.com/100%_pricing/", Parent: "https://monzo.com/", Depth: 1, StatusCode: http.StatusOK},
+		},
+	}
+	assert.NoError(t, crawlerRepo.Save(context.Background(), &crawlRec))
+
+	t.Run("Prefix wildcards are literal", func(t *testing.T) {
+		testCases := map[string]struct {
+			pattern  string
+			expected int
+		}{
+			"Wildcards in the URL": {"https://monzo.com/100%_", 1},
+			"Underscore":           {"https://monzo.com/1_0", 0},
+			"Percent":              {"https://monzo.com/%pricing", 0},
+		}
+
+		for name, tc := range testCases {
+			t.Run(name, func(t *testing.T) {
+				matches, err := crawlerRepo.SearchPages(
+					context.Background(),
+					crawler.PageSearch{Mode: crawler.SearchPrefix, Pattern: tc.pattern},
+				)
+				assert.NoError(t, err)
+				assert.Len(t, matches, tc.expected)
+			})
+		}
+	})
+
+	t.Run("Large crawls are saved in batches", func(t *testing.T) {
+		// more pages than fit in a single insert statement
+		large := crawler.Metadata{ID: "b5f3c9a2-6d1e-4f8b-9a7c-3e2d1f0a9b8c", InitialURL: "https://monzo.com/", Host: "monzo.com"}
+		for i := 0; i < 7000; i++ {
+			url := fmt.Sprintf("https://monzo.com/%05d/", i)
+			large.CrawlResultSet = append(large.CrawlResultSet, url)
+			large.Pages = append(large.Pages, instance.PageRecord{URL: url, Parent: "https://monzo.com/", StatusCode: http.StatusOK})
+			large.ErrList = append(large.ErrList, fmt.Errorf("error %d", i))
+		}
+		assert.NoError(t, crawlerRepo.Save(context.Background(), &large))
+
+		// saved with only its result set, so it comes back without pages
+		resultsOnly := crawler.Metadata{
+			ID:             "0e4d7c1b-2a9f-4b3e-8c6d-5f1a0b9e8d7c",
+			InitialURL:     "https://monzo.com/",
+			Host:           "monzo.com",
+			CrawlResultSet: []string{"https://monzo.com/"},
+		}
+		assert.NoError(t, crawlerRepo.Save(context.Background(), &resultsOnly))
+
+		crawls, err := crawlerRepo.GetCrawlsByHost(context.Background(), "monzo.com")
+		assert.NoError(t, err)
+		byID := map[string]crawler.Metadata{}
+		for _, crawlRec := range crawls {
+			byID[crawlRec.ID] = crawlRec
+		}
+		assert.Equal(t, large.CrawlResultSet, byID[large.ID].CrawlResultSet)
+		assert.Equal(t, large.Pages, byID[large.ID].Pages)
+		assert.Len(t, byID[large.ID].ErrList, 7000)
+		assert.Equal(t, resultsOnly.CrawlResultSet, byID[resultsOnly.ID].CrawlResultSet)
+		assert.Nil(t, byID[resultsOnly.ID].Pages)
+
+		assert.NoError(t, crawlerRepo.Delete(context.Background(), large.ID))
+		assert.NoError(t, crawlerRepo.Delete(context.Background(), resultsOnly.ID))
+	})
+
+	t.Run("Deletes cascade", func(t *testing.T) {
+		assert.NoError(t, crawlerRepo.Delete(context.Background(), "monzo-2"))
+
+		var pages, parents int
+		assert.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM pages WHERE crawl_id = 'monzo-2'`).Scan(&pages))
+		assert.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM page_parents WHERE crawl_id = 'monzo-2'`).Scan(&parents))
+		assert.Zero(t, pages)
+		assert.Zero(t, parents)
+	})
+
+	t.Run("Migrations are applied once", func(t *testing.T) {
+		_, err := crawler.NewPostgresRepository(context.Background(), db)
+		assert.NoError(t, err)
+
+		var applied int
+		assert.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&applied))
+		assert.Equal(t, 2, applied)
+	})
+
+	t.Run("Closed database is unavailable", func(t *testing.T) {
+		closed := openTestDB(t)
+		closedRepo, err := crawler.NewPostgresRepository(context.Background(), closed)
+		assert.NoError(t, err)
+		assert.NoError(t, closed.Close())
+
+		_, err = closedRepo.GetCrawlByID(context.Background(), "monzo-2")
+		assert.ErrorIs(t, err, crawler.ErrUnavailable)
+	})
+}
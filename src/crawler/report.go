@@ -0,0 +1,117 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/sjain93/web-crawler-go/src/util"
+)
+
+var ErrReportInvalid = errors.New("not a crawl report")
+
+// Errors are marshalled as empty objects, so the message of an error in a
+// report is lost unless it was written as a string
+var errNotInReport = errors.New("error message not recorded in report")
+
+// A crawl as read from a report, the errors are decoded separately
+type reportCrawl struct {
+	Metadata
+	ErrList []json.RawMessage
+}
+
+// Rebuilds the crawl record, reports written before hosts or fingerprints
+// were stored get them from the initial URL. The ID has to be a uuid like the
+// ones the service generates
+func (c reportCrawl) metadata() (Metadata, error) {
+	crawlRec := c.Metadata
+	if crawlRec.ID == "" {
+		return Metadata{}, errors.Wrap(ErrReportInvalid, "crawl is missing its id")
+	}
+	if !validID(crawlRec.ID) {
+		return Metadata{}, errors.Wrapf(ErrReportInvalid, "crawl id %q is not a uuid", crawlRec.ID)
+	}
+	if crawlRec.Host == "" {
+		host, err := util.GetHost(crawlRec.InitialURL)
+		if err != nil {
+			return Metadata{}, errors.Wrapf(ErrReportInvalid, "crawl %v: %v", crawlRec.ID, err)
+		}
+		crawlRec.Host = host
+	}
+	if crawlRec.Fingerprint == "" && crawlRec.InitialURL != "" {
+		if fingerprint, err := Fingerprint(crawlRec.InitialURL, crawlRec.Options); err == nil {
+			crawlRec.Fingerprint = fingerprint
+		}
+	}
+	if crawlRec.CrawlResultSet == nil {
+		crawlRec.CrawlResultSet = []string{}
+	}
+
+	crawlRec.ErrList = make([]error, 0, len(c.ErrList))
+	for _, raw := range c.ErrList {
+		var msg string
+		if json.Unmarshal(raw, &msg) == nil && msg != "" {
+			crawlRec.ErrList = append(crawlRec.ErrList, errors.New(msg))
+		} else {
+			crawlRec.ErrList = append(crawlRec.ErrList, errNotInReport)
+		}
+	}
+	return crawlRec, nil
+}
+
+// Reads a report, the JSON array of crawls written for the menu, and saves
+// its crawls in the repository one at a time. Crawl IDs that are already
+// stored are handled by the policy, and crawls keep their creation time
+func ImportReport(
+	ctx context.Context,
+	repo CrawlerRepoManager,
+	r io.Reader,
+	policy CollisionPolicy,
+) (ImportResult, error) {
+	result := ImportResult{Imported: []string{}, Skipped: []string{}, Renamed: map[string]string{}}
+	policy, err := policy.resolve()
+	if err != nil {
+		return result, err
+	}
+
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return result, errors.Wrap(ErrReportInvalid, "expected a JSON array of crawls")
+	}
+
+	for dec.More() {
+		var c reportCrawl
+		if err := dec.Decode(&c); err != nil {
+			return result, errors.Wrap(ErrReportInvalid, err.Error())
+		}
+		crawlRec, err := c.metadata()
+		if err != nil {
+			return result, err
+		}
+		if err = importCrawl(ctx, repo, crawlRec, policy, &result); err != nil {
+			return result, err
+		}
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return result, errors.Wrap(ErrReportInvalid, err.Error())
+	}
+	return result, nil
+}
+
+// This service method saves the crawls of a report file in the repository,
+// so they can be loaded, queried and diffed like any other crawl
+func (s *crawlerService) ImportReport(r io.Reader, policy CollisionPolicy) (ImportResult, error) {
+	result, err := ImportReport(context.Background(), s.crawlerRepo, r, policy)
+	if len(result.Imported) > 0 {
+		s.logger.Sugar().Infof(
+			"imported %v crawl(s) from report, %v renamed and %v skipped",
+			len(result.Imported),
+			len(result.Renamed),
+			len(result.Skipped),
+		)
+	}
+	return result, err
+}
@@ -0,0 +1,130 @@
+package crawler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sjain93/web-crawler-go/config"
+	"github.com/sjain93/web-crawler-go/src/crawler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportReport(t *testing.T) {
+	t.Run("Example report", func(t *testing.T) {
+		report, err := os.Open("../../example_reports/all.json")
+		assert.NoError(t, err)
+		defer report.Close()
+
+		crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+		assert.NoError(t, err)
+
+		result, err := crawler.ImportReport(context.Background(), crawlerRepo, report, crawler.CollisionSkip)
+		assert.NoError(t, err)
+		assert.Len(t, result.Imported, 3)
+
+		crawls, err := crawlerRepo.GetCrawlsByHost(context.Background(), "monzo.com")
+		assert.NoError(t, err)
+		if assert.Len(t, crawls, 1) {
+			assert.Equal(t, "085eeb21-4737-4b21-a501-680c8dc23e95", crawls[0].ID)
+			assert.Equal(t, 2023, crawls[0].CreatedAt.Year())
+			assert.NotEmpty(t, crawls[0].CrawlResultSet)
+			assert.NotEmpty(t, crawls[0].Fingerprint)
+		}
+	})
+
+	t.Run("Errors written as empty objects", func(t *testing.T) {
+		// the report is written the same way as the menu writes it
+		report, err := json.MarshalIndent([]crawler.Metadata{{
+			ID:             "6f1c2a9e-4b7d-4e3a-8c5f-9d0e1b2a3c4d",
+			InitialURL:     "https://monzo.com/",
+			Host:           "monzo.com",
+			CrawlResultSet: []string{"https://monzo.com/"},
+			ErrList:        []error{errors.New("error fetching page: https://monzo.com/help/")},
+			CreatedAt:      time.Date(2023, 11, 21, 1, 21, 6, 0, time.UTC),
+		}}, "", " ")
+		assert.NoError(t, err)
+		assert.Contains(t, string(report), `"ErrList": [
+   {}
+  ]`)
+
+		crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+		assert.NoError(t, err)
+		_, err = crawler.ImportReport(context.Background(), crawlerRepo, bytes.NewReader(report), "")
+		assert.NoError(t, err)
+
+		crawlRec, err := crawlerRepo.GetCrawlByID(context.Background(), "6f1c2a9e-4b7d-4e3a-8c5f-9d0e1b2a3c4d")
+		assert.NoError(t, err)
+		assert.Len(t, crawlRec.ErrList, 1)
+		assert.True(t, time.Date(2023, 11, 21, 1, 21, 6, 0, time.UTC).Equal(crawlRec.CreatedAt))
+	})
+
+	testCases := map[string]struct {
+		report   string
+		policy   crawler.CollisionPolicy
+		expected error
+	}{
+		"Not an array":   {report: `{"ID": "monzo-1"}`, expected: crawler.ErrReportInvalid},
+		"Truncated":      {report: `[{"ID": "monzo-1", "InitialURL": "https://monzo.com/"}`, expected: crawler.ErrReportInvalid},
+		"Missing ID":     {report: `[{"InitialURL": "https://monzo.com/"}]`, expected: crawler.ErrReportInvalid},
+		"Missing host":   {report: `[{"ID": "5d2e8f1a-3b7c-4a9d-b6e0-1c4f7a2d9e8b"}]`, expected: crawler.ErrReportInvalid},
+		"ID not a uuid":  {report: `[{"ID": "monzo-1", "Host": "monzo.com"}]`, expected: crawler.ErrReportInvalid},
+		"ID is a path":   {report: `[{"ID": "../5d2e8f1a-3b7c-4a9d-b6e0-1c4f7a2d9e8b", "Host": "monzo.com"}]`, expected: crawler.ErrReportInvalid},
+		"Errors as text": {report: `[{"ID": "5d2e8f1a-3b7c-4a9d-b6e0-1c4f7a2d9e8b", "Host": "monzo.com", "ErrList": ["timeout"]}]`},
+		"Host from URL":  {report: `[{"ID": "5d2e8f1a-3b7c-4a9d-b6e0-1c4f7a2d9e8b", "InitialURL": "https://monzo.com/"}]`},
+		"Empty report":   {report: `[]`},
+		"Unknown policy": {report: `[]`, policy: "overwrite", expected: crawler.ErrInvalidCollisionPolicy},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+			assert.NoError(t, err)
+
+			_, err = crawler.ImportReport(context.Background(), crawlerRepo, strings.NewReader(tc.report), tc.policy)
+			if tc.expected != nil {
+				assert.ErrorIs(t, err, tc.expected)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestImportReportService(t *testing.T) {
+	crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+	assert.NoError(t, err)
+	crawlerSvc, err := crawler.NewCrawlerService(crawler.WithRepository(crawlerRepo))
+	assert.NoError(t, err)
+
+	report := `[
+		{"ID": "a1b2c3d4-0000-4000-8000-000000000001", "InitialURL": "https://monzo.com/", "Host": "monzo.com",
+		 "CrawlResultSet": ["https://monzo.com/", "https://monzo.com/isa/"], "CreatedAt": "2023-11-20T10:00:00Z"},
+		{"ID": "a1b2c3d4-0000-4000-8000-000000000002", "InitialURL": "https://monzo.com/", "Host": "monzo.com",
+		 "CrawlResultSet": ["https://monzo.com/", "https://monzo.com/pricing/"], "CreatedAt": "2023-11-21T10:00:00Z"}
+	]`
+	result, err := crawlerSvc.ImportReport(strings.NewReader(report), crawler.CollisionSkip)
+	assert.NoError(t, err)
+	assert.Len(t, result.Imported, 2)
+
+	// importing the same report again skips every crawl
+	result, err = crawlerSvc.ImportReport(strings.NewReader(report), crawler.CollisionSkip)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Imported)
+	assert.Len(t, result.Skipped, 2)
+
+	// imported crawls can be loaded and diffed
+	crawls, err := crawlerSvc.GetCrawl("a1b2c3d4-0000-4000-8000-000000000001")
+	assert.NoError(t, err)
+	assert.Len(t, crawls, 1)
+
+	diff, err := crawlerSvc.DiffCrawls("a1b2c3d4-0000-4000-8000-000000000001", "a1b2c3d4-0000-4000-8000-000000000002")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"https://monzo.com/pricing/"}, diff.Added)
+	assert.Equal(t, []string{"https://monzo.com/isa/"}, diff.Removed)
+}
@@ -0,0 +1,312 @@
+package crawler
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/sjain93/web-crawler-go/src/crawler/instance"
+)
+
+var ErrUnknownReportFormat = errors.New("unknown report format")
+
+// Format of a crawl report, also used as the extension of report files
+type ReportFormat string
+
+const (
+	// the JSON array of crawls read back by ImportReport
+	ReportJSON ReportFormat = "json"
+	// one row per page
+	ReportCSV ReportFormat = "csv"
+	// one JSON page record per line
+	ReportNDJSON ReportFormat = "ndjson"
+)
+
+// Writes the crawls of a report as they are handed over. WriteCrawl starts a
+// crawl and is followed by each of its pages, Close finishes the report
+// without closing the underlying writer
+type ReportWriter interface {
+	WriteCrawl(crawlRec Metadata) error
+	WritePage(page instance.PageRecord) error
+	Close() error
+}
+
+var reportWriters = map[ReportFormat]func(w io.Writer) ReportWriter{
+	ReportJSON:   newJSONReportWriter,
+	ReportCSV:    newCSVReportWriter,
+	ReportNDJSON: newNDJSONReportWriter,
+}
+
+// Adds a report format, or replaces the writer of an existing one. Formats
+// are meant to be registered before any report is written, e.g. from init
+func RegisterReportFormat(format ReportFormat, newWriter func(w io.Writer) ReportWriter) {
+	reportWriters[format] = newWriter
+}
+
+// Every format a report can be written in, sorted by name
+func ReportFormats() []ReportFormat {
+	formats := make([]ReportFormat, 0, len(reportWriters))
+	for format := range reportWriters {
+		formats = append(formats, format)
+	}
+	sort.Slice(formats, func(i, j int) bool {
+		return formats[i] < formats[j]
+	})
+	return formats
+}
+
+// Returns a writer of the report format on top of w
+func NewReportWriter(format ReportFormat, w io.Writer) (ReportWriter, error) {
+	newWriter, ok := reportWriters[format]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownReportFormat, "%q", format)
+	}
+	return newWriter(w), nil
+}
+
+// Writes the crawls as an indented JSON array of crawl records, laid out as
+// json.MarshalIndent would. The pages of one crawl are held until the next
+// crawl starts, as they are part of its record
+type jsonReportWriter struct {
+	w       *bufio.Writer
+	crawl   *Metadata
+	written int
+}
+
+func newJSONReportWriter(w io.Writer) ReportWriter {
+	return &jsonReportWriter{w: bufio.NewWriter(w)}
+}
+
+func (r *jsonReportWriter) WriteCrawl(crawlRec Metadata) error {
+	if err := r.flushCrawl(); err != nil {
+		return err
+	}
+	crawlRec.Pages = []instance.PageRecord{}
+	r.crawl = &crawlRec
+	return nil
+}
+
+func (r *jsonReportWriter) WritePage(page instance.PageRecord) error {
+	if r.crawl == nil {
+		return errors.New("page written before its crawl")
+	}
+	r.crawl.Pages = append(r.crawl.Pages, page)
+	return nil
+}
+
+func (r *jsonReportWriter) flushCrawl() error {
+	if r.crawl == nil {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(r.crawl, " ", " ")
+	if err != nil {
+		return err
+	}
+	sep := ",\n "
+	if r.written == 0 {
+		sep = "[\n "
+	}
+	if _, err = r.w.WriteString(sep); err != nil {
+		return err
+	}
+	if _, err = r.w.Write(data); err != nil {
+		return err
+	}
+
+	r.crawl = nil
+	r.written++
+	return nil
+}
+
+func (r *jsonReportWriter) Close() error {
+	if err := r.flushCrawl(); err != nil {
+		return err
+	}
+	end := "\n]\n"
+	if r.written == 0 {
+		end = "[]\n"
+	}
+	if _, err := r.w.WriteString(end); err != nil {
+		return err
+	}
+	return r.w.Flush()
+}
+
+// columns of a CSV report, the response time is in milliseconds
+var csvReportHeader = []string{
+	"crawl_id",
+	"url",
+	"status",
+	"depth",
+	"parent",
+	"content_type",
+	"size",
+	"response_time_ms",
+}
+
+// Crawls stored before page records were kept only have their links, the
+// CSV and NDJSON writers write those as pages when a crawl has no pages
+type crawlLinks struct {
+	links []string
+	pages int
+}
+
+func (c *crawlLinks) start(crawlRec Metadata) {
+	c.links = crawlRec.CrawlResultSet
+	c.pages = 0
+}
+
+func (c *crawlLinks) finish(write func(page instance.PageRecord) error) error {
+	links := c.links
+	c.links = nil
+	if c.pages > 0 {
+		return nil
+	}
+	for _, url := range links {
+		if err := write(instance.PageRecord{URL: url}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes a header and then one row per page, rows are written as they come
+type csvReportWriter struct {
+	w       *csv.Writer
+	crawlID string
+	header  bool
+	links   crawlLinks
+}
+
+func newCSVReportWriter(w io.Writer) ReportWriter {
+	return &csvReportWriter{w: csv.NewWriter(w)}
+}
+
+func (r *csvReportWriter) writeHeader() error {
+	if r.header {
+		return nil
+	}
+	r.header = true
+	return r.w.Write(csvReportHeader)
+}
+
+func (r *csvReportWriter) WriteCrawl(crawlRec Metadata) error {
+	if err := r.links.finish(r.writeRow); err != nil {
+		return err
+	}
+	r.crawlID = crawlRec.ID
+	r.links.start(crawlRec)
+	return r.writeHeader()
+}
+
+func (r *csvReportWriter) WritePage(page instance.PageRecord) error {
+	r.links.pages++
+	return r.writeRow(page)
+}
+
+func (r *csvReportWriter) writeRow(page instance.PageRecord) error {
+	return r.w.Write([]string{
+		r.crawlID,
+		page.URL,
+		strconv.Itoa(page.StatusCode),
+		strconv.Itoa(page.Depth),
+		page.Parent,
+		page.ContentType,
+		strconv.FormatInt(page.Size, 10),
+		strconv.FormatInt(page.ResponseTime.Milliseconds(), 10),
+	})
+}
+
+// An empty report still gets its header
+func (r *csvReportWriter) Close() error {
+	if err := r.links.finish(r.writeRow); err != nil {
+		return err
+	}
+	if err := r.writeHeader(); err != nil {
+		return err
+	}
+	r.w.Flush()
+	return r.w.Error()
+}
+
+// A line of an NDJSON report, the page record along with its crawl
+type ndjsonPage struct {
+	CrawlID string
+	instance.PageRecord
+}
+
+// Writes one JSON encoded page per line as they come
+type ndjsonReportWriter struct {
+	w       *bufio.Writer
+	enc     *json.Encoder
+	crawlID string
+	links   crawlLinks
+}
+
+func newNDJSONReportWriter(w io.Writer) ReportWriter {
+	bw := bufio.NewWriter(w)
+	return &ndjsonReportWriter{w: bw, enc: json.NewEncoder(bw)}
+}
+
+func (r *ndjsonReportWriter) WriteCrawl(crawlRec Metadata) error {
+	if err := r.links.finish(r.writeLine); err != nil {
+		return err
+	}
+	r.crawlID = crawlRec.ID
+	r.links.start(crawlRec)
+	return nil
+}
+
+func (r *ndjsonReportWriter) WritePage(page instance.PageRecord) error {
+	r.links.pages++
+	return r.writeLine(page)
+}
+
+func (r *ndjsonReportWriter) writeLine(page instance.PageRecord) error {
+	return r.enc.Encode(ndjsonPage{CrawlID: r.crawlID, PageRecord: page})
+}
+
+func (r *ndjsonReportWriter) Close() error {
+	if err := r.links.finish(r.writeLine); err != nil {
+		return err
+	}
+	return r.w.Flush()
+}
+
+// This service method hands the crawls to the report writer one page at a
+// time, pages are streamed from the page store when it holds the crawl. The
+// writer is left open
+func (s *crawlerService) WriteReport(rw ReportWriter, crawls []Metadata) error {
+	for _, crawlRec := range crawls {
+		if err := rw.WriteCrawl(crawlRec); err != nil {
+			return err
+		}
+		if err := s.reportPages(crawlRec, rw.WritePage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads the pages of a crawl from the page store, or its record otherwise
+func (s *crawlerService) reportPages(crawlRec Metadata, fn func(page instance.PageRecord) error) error {
+	if s.pageStore != nil {
+		err := s.pageStore.ScanPages(crawlRec.ID, fn)
+		if err == nil || !errors.Is(err, ErrPagesNotFound) {
+			return err
+		}
+	}
+
+	for _, page := range crawlRec.Pages {
+		if err := fn(page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package crawler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sjain93/web-crawler-go/config"
+	"github.com/sjain93/web-crawler-go/src/crawler"
+	"github.com/sjain93/web-crawler-go/src/crawler/instance"
+	"github.com/sjain93/web-crawler-go/src/crawler/instance/instancetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func reportCrawls() []crawler.Metadata {
+	return []crawler.Metadata{
+		{
+			ID:             "0f3e9a5c-2b1d-4c7e-8a6f-5d4c3b2a1e0f",
+			InitialURL:     "https://monzo.com/",
+			Host:           "monzo.com",
+			CrawlResultSet: []string{"https://monzo.com/", "https://monzo.com/pricing/"},
+			ErrList:        []error{},
+			Pages: []instance.PageRecord{
+				{
+					URL:          "https://monzo.com/",
+					StatusCode:   http.StatusOK,
+					ContentType:  "text/html",
+					Size:         2048,
+					ResponseTime: 150 * time.Millisecond,
+				},
+				{
+					URL:          "https://monzo.com/pricing/",
+					Parent:       "https://monzo.com/",
+					Depth:        1,
+					StatusCode:   http.StatusNotFound,
+					ContentType:  "text/html",
+					Size:         512,
+					ResponseTime: 42 * time.Millisecond,
+				},
+			},
+			CreatedAt: time.Date(2023, 11, 21, 1, 21, 6, 0, time.UTC),
+		},
+		{
+			// stored before page records were kept
+			ID:             "7c6b5a49-3827-4615-9a0b-1c2d3e4f5a6b",
+			InitialURL:     "https://www.koho.ca/",
+			Host:           "www.koho.ca",
+			CrawlResultSet: []string{"https://www.koho.ca/"},
+			ErrList:        []error{},
+			Pages:          []instance.PageRecord{},
+			CreatedAt:      time.Date(2023, 11, 20, 9, 0, 0, 0, time.UTC),
+		},
+	}
+}
+
+// Writes the report with a service that has no page store
+func writeTestReport(t *testing.T, format crawler.ReportFormat, crawls []crawler.Metadata) string {
+	crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+	assert.NoError(t, err)
+	crawlerSvc, err := crawler.NewCrawlerService(crawler.WithRepository(crawlerRepo))
+	assert.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	rw, err := crawler.NewReportWriter(format, buf)
+	assert.NoError(t, err)
+	assert.NoError(t, crawlerSvc.WriteReport(rw, crawls))
+	assert.NoError(t, rw.Close())
+	return buf.String()
+}
+
+func TestReportWriters(t *testing.T) {
+	t.Run("JSON matches the crawl records", func(t *testing.T) {
+		expected, err := json.MarshalIndent(reportCrawls(), "", " ")
+		assert.NoError(t, err)
+		report := writeTestReport(t, crawler.ReportJSON, reportCrawls())
+		assert.Equal(t, string(expected)+"\n", report)
+
+		// and can be imported again
+		crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+		assert.NoError(t, err)
+		result, err := crawler.ImportReport(context.Background(), crawlerRepo, strings.NewReader(report), "")
+		assert.NoError(t, err)
+		assert.Len(t, result.Imported, 2)
+	})
+
+	t.Run("CSV has a row per page", func(t *testing.T) {
+		rows, err := csv.NewReader(strings.NewReader(writeTestReport(t, crawler.ReportCSV, reportCrawls()))).ReadAll()
+		assert.NoError(t, err)
+		assert.Equal(t, [][]string{
+			{"crawl_id", "url", "status", "depth", "parent", "content_type", "size", "response_time_ms"},
+			{"0f3e9a5c-2b1d-4c7e-8a6f-5d4c3b2a1e0f", "https://monzo.com/", "200", "0", "", "text/html", "2048", "150"},
+			{
+				"0f3e9a5c-2b1d-4c7e-8a6f-5d4c3b2a1e0f", "https://monzo.com/pricing/", "404", "1",
+				"https://monzo.com/", "text/html", "512", "42",
+			},
+			{"7c6b5a49-3827-4615-9a0b-1c2d3e4f5a6b", "https://www.koho.ca/", "0", "0", "", "", "0", "0"},
+		}, rows)
+	})
+
+	t.Run("NDJSON has a line per page", func(t *testing.T) {
+		lines := strings.Split(strings.TrimSpace(writeTestReport(t, crawler.ReportNDJSON, reportCrawls())), "\n")
+		assert.Len(t, lines, 3)
+
+		var page struct {
+			CrawlID string
+			instance.PageRecord
+		}
+		assert.NoError(t, json.Unmarshal([]byte(lines[1]), &page))
+		assert.Equal(t, "0f3e9a5c-2b1d-4c7e-8a6f-5d4c3b2a1e0f", page.CrawlID)
+		assert.Equal(t, reportCrawls()[0].Pages[1], page.PageRecord)
+
+		assert.NoError(t, json.Unmarshal([]byte(lines[2]), &page))
+		assert.Equal(t, "https://www.koho.ca/", page.URL)
+	})
+
+	t.Run("Empty reports", func(t *testing.T) {
+		testCases := map[crawler.ReportFormat]string{
+			crawler.ReportJSON:   "[]\n",
+			crawler.ReportCSV:    "crawl_id,url,status,depth,parent,content_type,size,response_time_ms\n",
+			crawler.ReportNDJSON: "",
+		}
+		for format, expected := range testCases {
+			assert.Equal(t, expected, writeTestReport(t, format, []crawler.Metadata{}), format)
+		}
+	})
+
+	t.Run("Unknown format", func(t *testing.T) {
+		_, err := crawler.NewReportWriter("xml", &bytes.Buffer{})
+		assert.ErrorIs(t, err, crawler.ErrUnknownReportFormat)
+		assert.Equal(t, []crawler.ReportFormat{"csv", "json", "ndjson"}, crawler.ReportFormats())
+	})
+}
+
+func TestWriteReportStreamsPages(t *testing.T) {
+	crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+	assert.NoError(t, err)
+	pageStore, err := crawler.NewPageStore(t.TempDir(), 0)
+	assert.NoError(t, err)
+	defer pageStore.Close()
+
+	crawlerSvc, err := crawler.NewCrawlerService(
+		crawler.WithRepository(crawlerRepo),
+		crawler.WithPageStore(pageStore),
+		crawler.WithCrawlerFactory(&instancetest.Factory{
+			Links: []string{"https://www.koho.ca/", "https://www.koho.ca/pricing/"},
+		}),
+	)
+	assert.NoError(t, err)
+
+	crawls, err := crawlerSvc.CrawlSite(crawler.Metadata{InitialURL: "https://www.koho.ca/"}, crawler.CrawlOptions{})
+	assert.NoError(t, err)
+
+	// the pages come from the page store rather than the record
+	crawlRec := crawls[0]
+	crawlRec.Pages = nil
+	buf := &bytes.Buffer{}
+	rw, err := crawler.NewReportWriter(crawler.ReportNDJSON, buf)
+	assert.NoError(t, err)
+	assert.NoError(t, crawlerSvc.WriteReport(rw, []crawler.Metadata{crawlRec}))
+	assert.NoError(t, rw.Close())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[1], `"URL":"https://www.koho.ca/pricing/"`)
+	assert.Contains(t, lines[1], `"StatusCode":200`)
+}
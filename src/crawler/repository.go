@@ -1,12 +1,15 @@
 package crawler
 
 import (
-	"sort"
+	"context"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 
 	"github.com/sjain93/web-crawler-go/config"
+	"github.com/sjain93/web-crawler-go/src/crawler/instance"
 )
 
 var (
@@ -14,6 +17,8 @@ var (
 	ErrRecordNotFound    = errors.New("record not found")
 	ErrInvalidDataType   = errors.New("invalid user data type")
 	ErrUniqueKeyViolated = errors.New("duplicated key not allowed")
+	ErrInvalidSearch     = errors.New("search needs a pattern and an exact, prefix or regex mode")
+	ErrInvalidID         = errors.New("crawl id must be a uuid")
 )
 
 // Shared model for Service and Repository layer
@@ -23,71 +28,153 @@ type Metadata struct {
 	Host           string
 	CrawlResultSet []string
 	ErrList        []error
-	CreatedAt      time.Time
+	// response details of every fetched page, sorted by URL
+	Pages     []instance.PageRecord
+	CreatedAt time.Time
+	// the resolved options the crawl was run with
+	Options CrawlOptions
+	// identifies the normalized initial URL and crawl options, used to decide
+	// if a stored crawl can answer a new request
+	Fingerprint string
+}
+
+// The fields of a stored crawl needed to list crawls without loading their
+// pages, result set and errors
+type CrawlRef struct {
+	ID        string
+	Host      string
+	CreatedAt time.Time
 }
 
 // Publiv interface for the repository layer, if the datastore is changed
-// the new implementation simply needs to satisfy this interface
+// the new implementation simply needs to satisfy this interface and pass the
+// conformance suite in repotest. Every error returned is a *RepoError
 type CrawlerRepoManager interface {
-	Save(crawlRec *Metadata) error
-	GetCrawlHistory() ([]Metadata, error)
-	GetCrawlByID(crawlRec *Metadata) (Metadata, error)
-	GetCrawlsByHost(crawlRec *Metadata) ([]Metadata, error)
+	Save(ctx context.Context, crawlRec *Metadata) error
+	GetCrawlHistory(ctx context.Context) ([]Metadata, error)
+	ListCrawls(ctx context.Context) ([]CrawlRef, error)
+	GetCrawlByID(ctx context.Context, id string) (Metadata, error)
+	GetCrawlsByHost(ctx context.Context, host string) ([]Metadata, error)
+	Delete(ctx context.Context, id string) error
+	SearchPages(ctx context.Context, q PageSearch) ([]PageMatch, error)
 }
 
 type CrawlerRepository struct {
-	memstore config.MemoryStore
+	// guards the indexes so they always agree with the store, the store
+	// itself is safe to share
+	mu       sync.RWMutex
+	memstore *config.MemoryStore[Metadata]
+	// crawl IDs of each host sorted most recent first, and the URLs of every
+	// crawl, kept up to date by Save and Delete
+	hosts hostIndex
+	urls  *urlIndex
 }
 
 // Create a new repository instance
-func NewCrawlerRepository(inMemStore config.MemoryStore) (CrawlerRepoManager, error) {
+func NewCrawlerRepository(inMemStore *config.MemoryStore[Metadata]) (CrawlerRepoManager, error) {
 	if inMemStore != nil {
-		return &CrawlerRepository{
+		r := &CrawlerRepository{
 			memstore: inMemStore,
-		}, nil
+			hosts:    hostIndex{},
+			urls:     newURLIndex(),
+		}
+		// records already in the store are indexed up front
+		for _, crawlRec := range inMemStore.Values() {
+			r.hosts.add(crawlRec)
+			r.urls.add(crawlRec)
+		}
+		return r, nil
 	}
 
 	return &CrawlerRepository{}, ErrNoDatastore
 }
 
+// Copies the slices of a crawl, so records in the store never share them with
+// callers. Nil slices stay nil
+func (m Metadata) clone() Metadata {
+	m.CrawlResultSet = cloneSlice(m.CrawlResultSet)
+	m.ErrList = cloneSlice(m.ErrList)
+	m.Pages = cloneSlice(m.Pages)
+	return m
+}
+
+func cloneSlice[T any](s []T) []T {
+	if s == nil {
+		return nil
+	}
+	return append(make([]T, 0, len(s)), s...)
+}
+
+// Crawls are stamped with the time they are saved, unless they already carry
+// one, e.g. when imported from an archive
+func creationTime(crawlRec Metadata) time.Time {
+	if crawlRec.CreatedAt.IsZero() {
+		return time.Now().UTC()
+	}
+	return crawlRec.CreatedAt.UTC()
+}
+
 // Records a crawl request
-func (r *CrawlerRepository) Save(crawlRec *Metadata) error {
-	_, ok := r.memstore[crawlRec.ID]
-	if ok {
-		return ErrUniqueKeyViolated
+func (r *CrawlerRepository) Save(ctx context.Context, crawlRec *Metadata) error {
+	if err := contextError(ctx, "save", crawlRec.ID); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	crawlRec.CreatedAt = creationTime(*crawlRec)
+	if !r.memstore.Insert(crawlRec.ID, crawlRec.clone()) {
+		return conflictError("save", crawlRec.ID)
 	}
-	crawlRec.CreatedAt = time.Now().UTC()
-	r.memstore[crawlRec.ID] = *crawlRec
+	r.hosts.add(*crawlRec)
+	r.urls.add(*crawlRec)
 
 	return nil
 }
 
 // Returns a crawl request provided the request ID
-func (r *CrawlerRepository) GetCrawlByID(crawlRec *Metadata) (Metadata, error) {
-	val, ok := r.memstore[crawlRec.ID]
-	if !ok {
-		return *crawlRec, ErrRecordNotFound
+func (r *CrawlerRepository) GetCrawlByID(ctx context.Context, id string) (Metadata, error) {
+	if err := contextError(ctx, "get", id); err != nil {
+		return Metadata{}, err
 	}
 
-	cR, ok := val.(Metadata)
+	cR, ok := r.memstore.Get(id)
 	if !ok {
-		return *crawlRec, ErrInvalidDataType
+		return Metadata{}, notFoundError("get", id)
 	}
-	crawlRec = &cR
 
-	return *crawlRec, nil
+	return cR.clone(), nil
 }
 
 // Returns all crawl requests from memory store
-func (r *CrawlerRepository) GetCrawlHistory() ([]Metadata, error) {
-	var crawls []Metadata
+func (r *CrawlerRepository) GetCrawlHistory(ctx context.Context) ([]Metadata, error) {
+	if err := contextError(ctx, "history", ""); err != nil {
+		return []Metadata{}, err
+	}
 
-	for _, cR := range r.memstore {
-		crawlRecord, ok := cR.(Metadata)
-		if !ok {
-			return crawls, ErrInvalidDataType
+	crawls := r.memstore.Values()
+	for i := range crawls {
+		crawls[i] = crawls[i].clone()
+	}
+	return crawls, nil
+}
+
+// Lists every stored crawl from the host index, without copying the crawls
+// out of the store
+func (r *CrawlerRepository) ListCrawls(ctx context.Context) ([]CrawlRef, error) {
+	if err := contextError(ctx, "list", ""); err != nil {
+		return []CrawlRef{}, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	crawls := []CrawlRef{}
+	for host, entries := range r.hosts {
+		for _, entry := range entries {
+			crawls = append(crawls, CrawlRef{ID: entry.id, Host: host, CreatedAt: entry.createdAt})
 		}
-		crawls = append(crawls, crawlRecord)
 	}
 
 	return crawls, nil
@@ -95,26 +182,67 @@ func (r *CrawlerRepository) GetCrawlHistory() ([]Metadata, error) {
 
 // Returns all crawl requests from memory that match the provided Host
 // (in sorted order from most recent to last)
-func (r *CrawlerRepository) GetCrawlsByHost(crawlRec *Metadata) ([]Metadata, error) {
-	var crawls []Metadata
+func (r *CrawlerRepository) GetCrawlsByHost(ctx context.Context, host string) ([]Metadata, error) {
+	if err := contextError(ctx, "get by host", ""); err != nil {
+		return []Metadata{}, err
+	}
 
-	for _, cR := range r.memstore {
-		storedRecord, ok := cR.(Metadata)
-		if !ok {
-			return crawls, ErrInvalidDataType
-		}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-		if storedRecord.Host == crawlRec.Host {
-			crawls = append(crawls, storedRecord)
+	entries := r.hosts[host]
+	crawls := make([]Metadata, 0, len(entries))
+	for _, entry := range entries {
+		if storedRecord, ok := r.memstore.Get(entry.id); ok {
+			crawls = append(crawls, storedRecord.clone())
 		}
 	}
 
-	// time ordered sort
-	if len(crawls) > 1 {
-		sort.Slice(crawls, func(i, j int) bool {
-			return crawls[i].CreatedAt.After(crawls[j].CreatedAt)
-		})
+	return crawls, nil
+}
+
+// Removes a crawl request from the memory store
+func (r *CrawlerRepository) Delete(ctx context.Context, id string) error {
+	if err := contextError(ctx, "delete", id); err != nil {
+		return err
 	}
 
-	return crawls, nil
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	crawlRec, ok := r.memstore.Delete(id)
+	if !ok {
+		return notFoundError("delete", id)
+	}
+	r.hosts.remove(crawlRec)
+	r.urls.remove(crawlRec)
+
+	return nil
+}
+
+// Returns the pages of every stored crawl with a URL matching the search,
+// oldest crawl first. Candidates are found through the URL index
+func (r *CrawlerRepository) SearchPages(ctx context.Context, q PageSearch) ([]PageMatch, error) {
+	if err := contextError(ctx, "search", ""); err != nil {
+		return []PageMatch{}, err
+	}
+	match, err := newURLMatcher(q)
+	if err != nil {
+		return []PageMatch{}, newRepoError(KindInvalid, "search", "", err)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return collectMatches(r.urls, match, func(id string) (Metadata, bool, error) {
+		crawlRec, ok := r.memstore.Get(id)
+		return crawlRec, ok, nil
+	})
+}
+
+// Crawl IDs are generated as a uuid in its canonical form, backends that name
+// files after the ID rely on it
+func validID(id string) bool {
+	parsed, err := uuid.Parse(id)
+	return err == nil && parsed.String() == id
 }
@@ -1,17 +1,20 @@
 package crawler_test
 
 import (
+	"context"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/sjain93/web-crawler-go/config"
 	"github.com/sjain93/web-crawler-go/src/crawler"
+	"github.com/sjain93/web-crawler-go/src/crawler/repotest"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestSave(t *testing.T) {
-	inMemDB := config.GetInMemoryStore()
+	inMemDB := config.GetInMemoryStore[crawler.Metadata]()
 	preLoad(inMemDB, crawler.Metadata{ID: "d28becaf-afb8-422a-a88f-00759050a965"})
 
 	crawlerRepo, err := crawler.NewCrawlerRepository(inMemDB)
@@ -43,9 +46,9 @@ func TestSave(t *testing.T) {
 
 	for name, tc := range testCases {
 		t.Run(name, func(t *testing.T) {
-			err := crawlerRepo.Save(&tc.metadata)
+			err := crawlerRepo.Save(context.Background(), &tc.metadata)
 			if tc.expectedErr != nil {
-				assert.Equal(t, tc.expectedErr, err)
+				assert.ErrorIs(t, err, tc.expectedErr)
 			} else {
 				assert.NoError(t, err)
 			}
@@ -54,7 +57,7 @@ func TestSave(t *testing.T) {
 }
 
 func TestGetByID(t *testing.T) {
-	inMemDB := config.GetInMemoryStore()
+	inMemDB := config.GetInMemoryStore[crawler.Metadata]()
 	preLoad(inMemDB, crawler.Metadata{ID: "085eeb21-4737-4b21-a501-680c8dc23e95"})
 
 	crawlerRepo, err := crawler.NewCrawlerRepository(inMemDB)
@@ -76,14 +79,46 @@ func TestGetByID(t *testing.T) {
 
 	for name, tc := range testCases {
 		t.Run(name, func(t *testing.T) {
-			_, err := crawlerRepo.GetCrawlByID(&tc.metadata)
-			assert.Equal(t, tc.expectedErr, err)
+			_, err := crawlerRepo.GetCrawlByID(context.Background(), tc.metadata.ID)
+			assert.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	inMemDB := config.GetInMemoryStore[crawler.Metadata]()
+	preLoad(inMemDB, crawler.Metadata{ID: "6a1c4c1e-96a4-4bd4-8b0f-2b4c4ffb8f0e"})
+
+	crawlerRepo, err := crawler.NewCrawlerRepository(inMemDB)
+	assert.NoError(t, err)
+
+	testCases := map[string]struct {
+		id          string
+		expectedErr error
+	}{
+		"Success": {
+			id:          "6a1c4c1e-96a4-4bd4-8b0f-2b4c4ffb8f0e",
+			expectedErr: nil,
+		},
+		"Failure": {
+			id:          uuid.NewString(),
+			expectedErr: crawler.ErrRecordNotFound,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := crawlerRepo.Delete(context.Background(), tc.id)
+			assert.ErrorIs(t, err, tc.expectedErr)
+
+			_, err = crawlerRepo.GetCrawlByID(context.Background(), tc.id)
+			assert.ErrorIs(t, err, crawler.ErrRecordNotFound)
 		})
 	}
 }
 
 func TestGetByHost(t *testing.T) {
-	inMemDB := config.GetInMemoryStore()
+	inMemDB := config.GetInMemoryStore[crawler.Metadata]()
 	setupMockData(inMemDB)
 
 	crawlerRepo, err := crawler.NewCrawlerRepository(inMemDB)
@@ -109,7 +144,7 @@ func TestGetByHost(t *testing.T) {
 
 	for name, tc := range testCases {
 		t.Run(name, func(t *testing.T) {
-			crawls, err := crawlerRepo.GetCrawlsByHost(&tc.metadata)
+			crawls, err := crawlerRepo.GetCrawlsByHost(context.Background(), tc.metadata.Host)
 			assert.NoError(t, err)
 			assert.Equal(t, tc.expectedResultLen, len(crawls))
 
@@ -120,7 +155,7 @@ func TestGetByHost(t *testing.T) {
 	}
 }
 
-func setupMockData(db config.MemoryStore) {
+func setupMockData(db *config.MemoryStore[crawler.Metadata]) {
 	now := time.Now().UTC()
 	earlierRun := now.Add(time.Duration(-30) * time.Minute)
 
@@ -158,11 +193,64 @@ func setupMockData(db config.MemoryStore) {
 	preLoad(db, records...)
 }
 
-func preLoad(db config.MemoryStore, data ...crawler.Metadata) {
+func preLoad(db *config.MemoryStore[crawler.Metadata], data ...crawler.Metadata) {
 	for _, d := range data {
 		if d.CreatedAt.IsZero() {
 			d.CreatedAt = time.Now().UTC()
 		}
-		db[d.ID] = d
+		db.Put(d.ID, d)
+	}
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+	assert.NoError(t, err)
+
+	const writers, crawlsPerWriter = 8, 25
+	hosts := []string{"monzo.com", "www.koho.ca"}
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < crawlsPerWriter; i++ {
+				crawlRec := crawler.Metadata{ID: uuid.NewString(), Host: hosts[(w+i)%len(hosts)]}
+				assert.NoError(t, crawlerRepo.Save(context.Background(), &crawlRec))
+
+				// readers and deletes interleaved with the writes
+				_, err := crawlerRepo.GetCrawlsByHost(context.Background(), crawlRec.Host)
+				assert.NoError(t, err)
+				if i%5 == 0 {
+					assert.NoError(t, crawlerRepo.Delete(context.Background(), crawlRec.ID))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	history, err := crawlerRepo.GetCrawlHistory(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, history, writers*crawlsPerWriter*4/5)
+
+	total := 0
+	for _, host := range hosts {
+		crawls, err := crawlerRepo.GetCrawlsByHost(context.Background(), host)
+		assert.NoError(t, err)
+		total += len(crawls)
+
+		for i := 1; i < len(crawls); i++ {
+			assert.False(t, crawls[i].CreatedAt.After(crawls[i-1].CreatedAt))
+		}
 	}
+	assert.Equal(t, len(history), total)
+}
+
+func TestCrawlerRepositoryConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) crawler.CrawlerRepoManager {
+		crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+		assert.NoError(t, err)
+		return crawlerRepo
+	})
 }
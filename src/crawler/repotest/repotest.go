@@ -0,0 +1,416 @@
+// Package repotest is the conformance suite every crawler.CrawlerRepoManager
+// implementation is expected to pass, so backends can be swapped without the
+// service noticing
+package repotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sjain93/web-crawler-go/src/crawler"
+	"github.com/sjain93/web-crawler-go/src/crawler/instance"
+	"github.com/stretchr/testify/assert"
+)
+
+// Builds an empty repository, called once per test case
+type NewRepo func(t *testing.T) crawler.CrawlerRepoManager
+
+// Runs the conformance suite against the backend built by newRepo
+func Run(t *testing.T, newRepo NewRepo) {
+	t.Run("Save and get", func(t *testing.T) { testSaveAndGet(t, newRepo(t)) })
+	t.Run("Copies", func(t *testing.T) { testCopies(t, newRepo(t)) })
+	t.Run("Conflict", func(t *testing.T) { testConflict(t, newRepo(t)) })
+	t.Run("Not found", func(t *testing.T) { testNotFound(t, newRepo(t)) })
+	t.Run("Crawls by host", func(t *testing.T) { testCrawlsByHost(t, newRepo(t)) })
+	t.Run("Creation time", func(t *testing.T) { testCreationTime(t, newRepo(t)) })
+	t.Run("History", func(t *testing.T) { testHistory(t, newRepo(t)) })
+	t.Run("List", func(t *testing.T) { testList(t, newRepo(t)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, newRepo(t)) })
+	t.Run("Search", func(t *testing.T) { testSearch(t, newRepo(t)) })
+	t.Run("Cancelled context", func(t *testing.T) { testCancelled(t, newRepo(t)) })
+	t.Run("Concurrent saves", func(t *testing.T) { testConcurrentSaves(t, newRepo(t)) })
+}
+
+// A crawl with every field a backend has to keep
+func fullCrawl(id string) crawler.Metadata {
+	return crawler.Metadata{
+		ID:         id,
+		InitialURL: "https://monzo.com/",
+		Host:       "monzo.com",
+		CrawlResultSet: []string{
+			"https://monzo.com/",
+			"https://monzo.com/pricing/",
+			"https://monzo.com/isa/",
+		},
+		ErrList: []error{errors.New("error fetching page: https://monzo.com/help/")},
+		Pages: []instance.PageRecord{
+			{URL: "https://monzo.com/", StatusCode: http.StatusOK, ContentType: "text/html", Size: 1024},
+			{URL: "https://monzo.com/gone/", Parent: "https://monzo.com/", Depth: 1, StatusCode: http.StatusNotFound},
+			{
+				URL:          "https://monzo.com/pricing/",
+				Parent:       "https://monzo.com/",
+				Depth:        1,
+				StatusCode:   http.StatusOK,
+				ResponseTime: 120 * time.Millisecond,
+				RedirectURL:  "https://monzo.com/pricing",
+			},
+		},
+		Options: crawler.CrawlOptions{
+			Scope:    "/",
+			MaxDepth: 3,
+			MaxPages: 500,
+			Workers:  8,
+			Timeout:  10 * time.Second,
+		},
+		Fingerprint: "5f0c1e",
+	}
+}
+
+func save(t *testing.T, repo crawler.CrawlerRepoManager, crawls ...crawler.Metadata) []crawler.Metadata {
+	saved := []crawler.Metadata{}
+	for _, crawlRec := range crawls {
+		assert.NoError(t, repo.Save(context.Background(), &crawlRec))
+		saved = append(saved, crawlRec)
+	}
+	return saved
+}
+
+func testSaveAndGet(t *testing.T, repo crawler.CrawlerRepoManager) {
+	expected := fullCrawl(crawlID("saved"))
+	before := time.Now()
+	assert.NoError(t, repo.Save(context.Background(), &expected))
+	assert.False(t, expected.CreatedAt.IsZero(), "Save sets the creation time")
+	assert.WithinDuration(t, before, expected.CreatedAt, time.Minute)
+
+	crawlRec, err := repo.GetCrawlByID(context.Background(), expected.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, expected.ID, crawlRec.ID)
+	assert.Equal(t, expected.InitialURL, crawlRec.InitialURL)
+	assert.Equal(t, expected.Host, crawlRec.Host)
+	assert.Equal(t, expected.CrawlResultSet, crawlRec.CrawlResultSet)
+	assert.Equal(t, expected.Pages, crawlRec.Pages)
+	assert.Equal(t, expected.Options, crawlRec.Options)
+	assert.Equal(t, expected.Fingerprint, crawlRec.Fingerprint)
+	assert.True(t, expected.CreatedAt.Equal(crawlRec.CreatedAt))
+	if assert.Len(t, crawlRec.ErrList, 1) {
+		assert.EqualError(t, crawlRec.ErrList[0], expected.ErrList[0].Error())
+	}
+}
+
+func testCopies(t *testing.T, repo crawler.CrawlerRepoManager) {
+	// changes to a saved or returned crawl don't reach the stored one
+	crawlRec := fullCrawl(crawlID("copied"))
+	assert.NoError(t, repo.Save(context.Background(), &crawlRec))
+	crawlRec.CrawlResultSet[0] = "https://monzo.com/changed/"
+	crawlRec.Pages[0].StatusCode = http.StatusTeapot
+	crawlRec.ErrList[0] = errors.New("changed")
+
+	for i := 0; i < 2; i++ {
+		stored, err := repo.GetCrawlByID(context.Background(), crawlID("copied"))
+		assert.NoError(t, err)
+		assert.Equal(t, fullCrawl("").CrawlResultSet, stored.CrawlResultSet)
+		assert.Equal(t, fullCrawl("").Pages, stored.Pages)
+		if assert.Len(t, stored.ErrList, 1) {
+			assert.EqualError(t, stored.ErrList[0], fullCrawl("").ErrList[0].Error())
+		}
+
+		stored.CrawlResultSet[0] = "https://monzo.com/changed/"
+		stored.Pages[0].StatusCode = http.StatusTeapot
+		stored.ErrList[0] = errors.New("changed")
+	}
+}
+
+func testConflict(t *testing.T, repo crawler.CrawlerRepoManager) {
+	save(t, repo, crawler.Metadata{ID: crawlID("conflict"), Host: "monzo.com"})
+
+	dup := crawler.Metadata{ID: crawlID("conflict"), Host: "www.koho.ca"}
+	err := repo.Save(context.Background(), &dup)
+	assert.ErrorIs(t, err, crawler.ErrUniqueKeyViolated)
+	assert.Equal(t, crawler.KindConflict, crawler.KindOf(err))
+
+	// the first crawl is left untouched
+	crawlRec, err := repo.GetCrawlByID(context.Background(), crawlID("conflict"))
+	assert.NoError(t, err)
+	assert.Equal(t, "monzo.com", crawlRec.Host)
+}
+
+func testNotFound(t *testing.T, repo crawler.CrawlerRepoManager) {
+	_, err := repo.GetCrawlByID(context.Background(), "missing")
+	assert.ErrorIs(t, err, crawler.ErrRecordNotFound)
+	assert.Equal(t, crawler.KindNotFound, crawler.KindOf(err))
+
+	var repoErr *crawler.RepoError
+	if assert.ErrorAs(t, err, &repoErr) {
+		assert.Equal(t, "missing", repoErr.ID)
+	}
+
+	err = repo.Delete(context.Background(), "missing")
+	assert.ErrorIs(t, err, crawler.ErrRecordNotFound)
+	assert.Equal(t, crawler.KindNotFound, crawler.KindOf(err))
+}
+
+func testCrawlsByHost(t *testing.T, repo crawler.CrawlerRepoManager) {
+	// creation times may tie, in which case the ID decides
+	older, newer := "b0d3c8a4-1f2e-4b6a-9c7d-0e5f4a3b2c1d", "a7e1f9c2-3d4b-4e8a-b6c5-2f1e0d9c8b7a"
+	save(t, repo,
+		crawler.Metadata{ID: older, Host: "monzo.com"},
+		crawler.Metadata{ID: crawlID("koho"), Host: "www.koho.ca"},
+		crawler.Metadata{ID: newer, Host: "monzo.com"},
+	)
+
+	crawls, err := repo.GetCrawlsByHost(context.Background(), "monzo.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{newer, older}, ids(crawls))
+
+	crawls, err = repo.GetCrawlsByHost(context.Background(), "spacy.io")
+	assert.NoError(t, err)
+	assert.NotNil(t, crawls)
+	assert.Empty(t, crawls)
+}
+
+func testCreationTime(t *testing.T, repo crawler.CrawlerRepoManager) {
+	// crawls that already carry a creation time, e.g. imported ones, keep it
+	older := time.Date(2023, 5, 1, 9, 30, 0, 0, time.UTC)
+	newer := older.Add(48 * time.Hour)
+	save(t, repo,
+		crawler.Metadata{ID: crawlID("imported-newer"), Host: "monzo.com", CreatedAt: newer},
+		crawler.Metadata{ID: crawlID("imported-older"), Host: "monzo.com", CreatedAt: older},
+	)
+
+	crawlRec, err := repo.GetCrawlByID(context.Background(), crawlID("imported-older"))
+	assert.NoError(t, err)
+	assert.True(t, older.Equal(crawlRec.CreatedAt))
+
+	crawls, err := repo.GetCrawlsByHost(context.Background(), "monzo.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{crawlID("imported-newer"), crawlID("imported-older")}, ids(crawls))
+}
+
+func testHistory(t *testing.T, repo crawler.CrawlerRepoManager) {
+	crawls, err := repo.GetCrawlHistory(context.Background())
+	assert.NoError(t, err)
+	assert.NotNil(t, crawls)
+	assert.Empty(t, crawls)
+
+	save(t, repo,
+		crawler.Metadata{ID: crawlID("1"), Host: "monzo.com"},
+		crawler.Metadata{ID: crawlID("2"), Host: "www.koho.ca"},
+		crawler.Metadata{ID: crawlID("3"), Host: "spacy.io"},
+	)
+	crawls, err = repo.GetCrawlHistory(context.Background())
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{crawlID("1"), crawlID("2"), crawlID("3")}, ids(crawls))
+}
+
+func testList(t *testing.T, repo crawler.CrawlerRepoManager) {
+	crawls, err := repo.ListCrawls(context.Background())
+	assert.NoError(t, err)
+	assert.NotNil(t, crawls)
+	assert.Empty(t, crawls)
+
+	created := time.Date(2023, 5, 1, 9, 30, 0, 0, time.UTC)
+	save(t, repo,
+		fullCrawl(crawlID("full")),
+		crawler.Metadata{ID: crawlID("dated"), Host: "www.koho.ca", CreatedAt: created},
+	)
+	assert.NoError(t, repo.Delete(context.Background(), crawlID("full")))
+	save(t, repo, crawler.Metadata{ID: crawlID("kept"), Host: "monzo.com", CreatedAt: created.Add(time.Hour)})
+
+	crawls, err = repo.ListCrawls(context.Background())
+	assert.NoError(t, err)
+	sort.Slice(crawls, func(i, j int) bool { return crawls[i].CreatedAt.Before(crawls[j].CreatedAt) })
+	expected := []crawler.CrawlRef{
+		{ID: crawlID("dated"), Host: "www.koho.ca", CreatedAt: created},
+		{ID: crawlID("kept"), Host: "monzo.com", CreatedAt: created.Add(time.Hour)},
+	}
+	if assert.Len(t, crawls, len(expected)) {
+		for i := range expected {
+			assert.Equal(t, expected[i].ID, crawls[i].ID)
+			assert.Equal(t, expected[i].Host, crawls[i].Host)
+			assert.True(t, expected[i].CreatedAt.Equal(crawls[i].CreatedAt))
+		}
+	}
+}
+
+func testDelete(t *testing.T, repo crawler.CrawlerRepoManager) {
+	save(t, repo, fullCrawl(crawlID("deleted")), crawler.Metadata{ID: crawlID("kept"), Host: "monzo.com"})
+
+	assert.NoError(t, repo.Delete(context.Background(), crawlID("deleted")))
+	_, err := repo.GetCrawlByID(context.Background(), crawlID("deleted"))
+	assert.ErrorIs(t, err, crawler.ErrRecordNotFound)
+	assert.ErrorIs(t, repo.Delete(context.Background(), crawlID("deleted")), crawler.ErrRecordNotFound)
+
+	crawls, err := repo.GetCrawlsByHost(context.Background(), "monzo.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{crawlID("kept")}, ids(crawls))
+
+	matches, err := repo.SearchPages(context.Background(), crawler.PageSearch{
+		Mode:    crawler.SearchPrefix,
+		Pattern: "https://monzo.com/",
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+
+	// the ID can be used again
+	save(t, repo, crawler.Metadata{ID: crawlID("deleted"), Host: "www.koho.ca"})
+}
+
+func testSearch(t *testing.T, repo crawler.CrawlerRepoManager) {
+	saved := save(t, repo,
+		fullCrawl(crawlID("with-pages")),
+		crawler.Metadata{
+			ID:             crawlID("result-set-only"),
+			Host:           "monzo.com",
+			CrawlResultSet: []string{"https://monzo.com/", "https://monzo.com/isa/"},
+		},
+	)
+
+	testCases := map[string]struct {
+		search   crawler.PageSearch
+		expected []string
+	}{
+		"Exact": {
+			search: crawler.PageSearch{Mode: crawler.SearchExact, Pattern: "https://monzo.com/"},
+			expected: []string{
+				crawlID("with-pages") + " https://monzo.com/",
+				crawlID("result-set-only") + " https://monzo.com/",
+			},
+		},
+		"Prefix": {
+			search: crawler.PageSearch{Mode: crawler.SearchPrefix, Pattern: "https://monzo.com/g"},
+			expected: []string{
+				crawlID("with-pages") + " https://monzo.com/gone/",
+			},
+		},
+		// crawls with page records are searched on the pages they fetched
+		"Regex": {
+			search: crawler.PageSearch{Mode: crawler.SearchRegex, Pattern: `/isa/$`},
+			expected: []string{
+				crawlID("result-set-only") + " https://monzo.com/isa/",
+			},
+		},
+		"No match": {
+			search:   crawler.PageSearch{Mode: crawler.SearchExact, Pattern: "https://monzo.com/help/"},
+			expected: []string{},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			matches, err := repo.SearchPages(context.Background(), tc.search)
+			assert.NoError(t, err)
+
+			// crawls saved in the same instant may come back in either order
+			got := []string{}
+			for _, m := range matches {
+				got = append(got, fmt.Sprintf("%v %v", m.CrawlID, m.Page.URL))
+			}
+			sort.Strings(got)
+			sort.Strings(tc.expected)
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+
+	t.Run("Page records are returned", func(t *testing.T) {
+		matches, err := repo.SearchPages(context.Background(), crawler.PageSearch{
+			Mode:    crawler.SearchExact,
+			Pattern: "https://monzo.com/pricing/",
+		})
+		assert.NoError(t, err)
+		if assert.Len(t, matches, 1) {
+			assert.Equal(t, saved[0].Pages[2], matches[0].Page)
+			assert.True(t, saved[0].CreatedAt.Equal(matches[0].CreatedAt))
+		}
+	})
+
+	t.Run("Invalid search", func(t *testing.T) {
+		_, err := repo.SearchPages(context.Background(), crawler.PageSearch{Mode: crawler.SearchRegex, Pattern: "("})
+		assert.ErrorIs(t, err, crawler.ErrInvalidSearch)
+		assert.Equal(t, crawler.KindInvalid, crawler.KindOf(err))
+	})
+}
+
+func testCancelled(t *testing.T, repo crawler.CrawlerRepoManager) {
+	save(t, repo, crawler.Metadata{ID: crawlID("cancelled"), Host: "monzo.com"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	crawlRec := crawler.Metadata{ID: crawlID("not-saved"), Host: "monzo.com"}
+	_, getErr := repo.GetCrawlByID(ctx, crawlID("cancelled"))
+	_, hostErr := repo.GetCrawlsByHost(ctx, "monzo.com")
+	_, historyErr := repo.GetCrawlHistory(ctx)
+	_, listErr := repo.ListCrawls(ctx)
+	_, searchErr := repo.SearchPages(ctx, crawler.PageSearch{Mode: crawler.SearchExact, Pattern: "https://monzo.com/"})
+
+	for name, err := range map[string]error{
+		"save":        repo.Save(ctx, &crawlRec),
+		"get":         getErr,
+		"get by host": hostErr,
+		"history":     historyErr,
+		"list":        listErr,
+		"search":      searchErr,
+		"delete":      repo.Delete(ctx, crawlID("cancelled")),
+	} {
+		assert.ErrorIs(t, err, context.Canceled, name)
+		assert.ErrorIs(t, err, crawler.ErrUnavailable, name)
+		assert.Equal(t, crawler.KindUnavailable, crawler.KindOf(err), name)
+	}
+
+	// nothing was changed by the cancelled calls
+	_, err := repo.GetCrawlByID(context.Background(), crawlID("cancelled"))
+	assert.NoError(t, err)
+	_, err = repo.GetCrawlByID(context.Background(), crawlID("not-saved"))
+	assert.ErrorIs(t, err, crawler.ErrRecordNotFound)
+}
+
+func testConcurrentSaves(t *testing.T, repo crawler.CrawlerRepoManager) {
+	const writers, saves = 4, 10
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < saves; i++ {
+				crawlRec := crawler.Metadata{
+					ID:             crawlID(fmt.Sprintf("writer-%d-%02d", w, i)),
+					Host:           fmt.Sprintf("host-%d.com", w),
+					CrawlResultSet: []string{fmt.Sprintf("https://host-%d.com/%d/", w, i)},
+				}
+				assert.NoError(t, repo.Save(context.Background(), &crawlRec))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	crawls, err := repo.GetCrawlHistory(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, crawls, writers*saves)
+
+	for w := 0; w < writers; w++ {
+		crawls, err = repo.GetCrawlsByHost(context.Background(), fmt.Sprintf("host-%d.com", w))
+		assert.NoError(t, err)
+		assert.Len(t, crawls, saves)
+	}
+}
+
+// Crawl IDs are uuids, tests derive them from a readable name
+func crawlID(name string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(name)).String()
+}
+
+func ids(crawls []crawler.Metadata) []string {
+	res := []string{}
+	for _, crawlRec := range crawls {
+		res = append(res, crawlRec.ID)
+	}
+	return res
+}
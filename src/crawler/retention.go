@@ -0,0 +1,112 @@
+package crawler
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Which stored crawls are kept, crawls breaking either limit are deleted.
+// Zero values disable a limit, and a non-positive interval disables the
+// periodic pruning
+type RetentionPolicy struct {
+	// number of most recent crawls kept per host
+	KeepLast int
+	// crawls older than this are deleted
+	MaxAge time.Duration
+	// how often the policy is applied in the background
+	Interval time.Duration
+}
+
+func (p RetentionPolicy) enabled() bool {
+	return p.KeepLast > 0 || p.MaxAge > 0
+}
+
+// This service method removes a stored crawl
+func (s *crawlerService) DeleteCrawl(id string) error {
+	err := s.crawlerRepo.Delete(context.Background(), id)
+	if err != nil && errors.Is(err, ErrRecordNotFound) {
+		return ErrSvcRecordNotFound
+	} else if err != nil {
+		return err
+	}
+	s.deletePages(id)
+	s.notifier.forget(id)
+
+	s.logger.Sugar().Infof("deleted crawl %v", id)
+	return nil
+}
+
+// This service method applies the retention policy of the service config
+// once, returning the IDs of the deleted crawls
+func (s *crawlerService) PruneCrawls() ([]string, error) {
+	policy := s.cfg.Retention
+	deleted := []string{}
+	if !policy.enabled() {
+		return deleted, nil
+	}
+
+	// only the host and age of a crawl decide if it is kept
+	crawls, err := s.crawlerRepo.ListCrawls(context.Background())
+	if err != nil {
+		return deleted, err
+	}
+
+	byHost := map[string][]CrawlRef{}
+	for _, crawl := range crawls {
+		byHost[crawl.Host] = append(byHost[crawl.Host], crawl)
+	}
+
+	cutoff := s.now().UTC().Add(-policy.MaxAge)
+	for _, hostCrawls := range byHost {
+		// most recent first, so everything past KeepLast is older
+		sort.Slice(hostCrawls, func(i, j int) bool {
+			return hostCrawls[i].CreatedAt.After(hostCrawls[j].CreatedAt)
+		})
+
+		for i, crawl := range hostCrawls {
+			expired := policy.MaxAge > 0 && crawl.CreatedAt.Before(cutoff)
+			surplus := policy.KeepLast > 0 && i >= policy.KeepLast
+			if !expired && !surplus {
+				continue
+			}
+
+			err = s.crawlerRepo.Delete(context.Background(), crawl.ID)
+			if errors.Is(err, ErrRecordNotFound) {
+				// removed in the meantime, by whoever deleted it
+				continue
+			} else if err != nil {
+				return deleted, err
+			}
+			s.deletePages(crawl.ID)
+			s.notifier.forget(crawl.ID)
+			deleted = append(deleted, crawl.ID)
+		}
+	}
+
+	sort.Strings(deleted)
+	if len(deleted) > 0 {
+		s.logger.Sugar().Infof("retention policy removed %d crawl(s)", len(deleted))
+		s.compactPages()
+	}
+	return deleted, nil
+}
+
+// Prunes stored crawls on the policy interval until the service is closed
+func (s *crawlerService) runRetention() {
+	ticker := time.NewTicker(s.cfg.Retention.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+			if _, err := s.PruneCrawls(); err != nil {
+				s.logger.Sugar().Warnf("unable to apply retention policy: %v", err.Error())
+			}
+		}
+	}
+}
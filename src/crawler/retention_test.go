@@ -0,0 +1,168 @@
+package crawler_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sjain93/web-crawler-go/config"
+	"github.com/sjain93/web-crawler-go/src/crawler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeleteCrawl(t *testing.T) {
+	inMemDB := config.GetInMemoryStore[crawler.Metadata]()
+	preLoad(inMemDB, crawler.Metadata{ID: "1f9b0a52-7a3e-4d8e-9d67-3e7c2b5f4a10"})
+	crawlerRepo, err := crawler.NewCrawlerRepository(inMemDB)
+	assert.NoError(t, err)
+
+	crawlerSvc, err := crawler.NewCrawlerService(crawler.WithRepository(crawlerRepo))
+	assert.NoError(t, err)
+
+	testCases := map[string]struct {
+		id          string
+		expectedErr error
+	}{
+		"Happy Path - deletes crawl": {
+			id: "1f9b0a52-7a3e-4d8e-9d67-3e7c2b5f4a10",
+		},
+		"Error - unknown crawl": {
+			id:          "00000000-0000-0000-0000-000000000000",
+			expectedErr: crawler.ErrSvcRecordNotFound,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedErr, crawlerSvc.DeleteCrawl(tc.id))
+
+			_, err := crawlerSvc.GetCrawl(tc.id)
+			assert.ErrorIs(t, err, crawler.ErrSvcRecordNotFound)
+		})
+	}
+}
+
+func TestPruneCrawls(t *testing.T) {
+	now := time.Date(2023, time.March, 15, 12, 0, 0, 0, time.UTC)
+	crawls := []crawler.Metadata{
+		{ID: "monzo-1h", Host: "monzo.com", CreatedAt: now.Add(-time.Hour)},
+		{ID: "monzo-2d", Host: "monzo.com", CreatedAt: now.Add(-48 * time.Hour)},
+		{ID: "monzo-3d", Host: "monzo.com", CreatedAt: now.Add(-72 * time.Hour)},
+		{ID: "koho-1h", Host: "www.koho.ca", CreatedAt: now.Add(-time.Hour)},
+		{ID: "koho-10d", Host: "www.koho.ca", CreatedAt: now.Add(-240 * time.Hour)},
+	}
+
+	testCases := map[string]struct {
+		policy          crawler.RetentionPolicy
+		expectedDeleted []string
+	}{
+		"No policy": {
+			policy:          crawler.RetentionPolicy{},
+			expectedDeleted: []string{},
+		},
+		"Keep last per host": {
+			policy:          crawler.RetentionPolicy{KeepLast: 1},
+			expectedDeleted: []string{"koho-10d", "monzo-2d", "monzo-3d"},
+		},
+		"Max age": {
+			policy:          crawler.RetentionPolicy{MaxAge: 60 * time.Hour},
+			expectedDeleted: []string{"koho-10d", "monzo-3d"},
+		},
+		"Both limits": {
+			policy:          crawler.RetentionPolicy{KeepLast: 2, MaxAge: 24 * time.Hour},
+			expectedDeleted: []string{"koho-10d", "monzo-2d", "monzo-3d"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			inMemDB := config.GetInMemoryStore[crawler.Metadata]()
+			preLoad(inMemDB, crawls...)
+			crawlerRepo, err := crawler.NewCrawlerRepository(inMemDB)
+			assert.NoError(t, err)
+
+			svcConfig := crawler.NewDefaultServiceConfig()
+			svcConfig.Retention = tc.policy
+			crawlerSvc, err := crawler.NewCrawlerService(
+				crawler.WithRepository(crawlerRepo),
+				crawler.WithConfig(*svcConfig),
+				crawler.WithClock(func() time.Time { return now }),
+			)
+			assert.NoError(t, err)
+
+			deleted, err := crawlerSvc.PruneCrawls()
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedDeleted, deleted)
+
+			history, err := crawlerSvc.GetCrawlHistory()
+			assert.NoError(t, err)
+			assert.Len(t, history, len(crawls)-len(tc.expectedDeleted))
+		})
+	}
+
+	t.Run("Crawls removed in the meantime are not reported", func(t *testing.T) {
+		inMemDB := config.GetInMemoryStore[crawler.Metadata]()
+		preLoad(inMemDB, crawls...)
+		crawlerRepo, err := crawler.NewCrawlerRepository(inMemDB)
+		assert.NoError(t, err)
+
+		svcConfig := crawler.NewDefaultServiceConfig()
+		svcConfig.Retention = crawler.RetentionPolicy{KeepLast: 1}
+		crawlerSvc, err := crawler.NewCrawlerService(
+			crawler.WithRepository(&racingDeleteRepo{CrawlerRepoManager: crawlerRepo, ids: map[string]bool{"monzo-2d": true}}),
+			crawler.WithConfig(*svcConfig),
+		)
+		assert.NoError(t, err)
+
+		deleted, err := crawlerSvc.PruneCrawls()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"koho-10d", "monzo-3d"}, deleted)
+
+		history, err := crawlerSvc.GetCrawlHistory()
+		assert.NoError(t, err)
+		assert.Len(t, history, 2)
+	})
+
+	t.Run("Policy is applied on an interval", func(t *testing.T) {
+		inMemDB := config.GetInMemoryStore[crawler.Metadata]()
+		preLoad(inMemDB, crawls...)
+		crawlerRepo, err := crawler.NewCrawlerRepository(inMemDB)
+		assert.NoError(t, err)
+
+		svcConfig := crawler.NewDefaultServiceConfig()
+		svcConfig.Retention = crawler.RetentionPolicy{KeepLast: 1, Interval: 5 * time.Millisecond}
+		crawlerSvc, err := crawler.NewCrawlerService(
+			crawler.WithRepository(crawlerRepo),
+			crawler.WithConfig(*svcConfig),
+		)
+		assert.NoError(t, err)
+		defer crawlerSvc.Close()
+
+		assert.Eventually(t, func() bool {
+			history, err := crawlerSvc.GetCrawlHistory()
+			if err != nil || len(history) != 2 {
+				return false
+			}
+			ids := []string{history[0].ID, history[1].ID}
+			sort.Strings(ids)
+			return assert.ObjectsAreEqual([]string{"koho-1h", "monzo-1h"}, ids)
+		}, time.Second, 5*time.Millisecond)
+	})
+}
+
+// Repository where the provided crawls are deleted by someone else just
+// before they are deleted through it
+type racingDeleteRepo struct {
+	crawler.CrawlerRepoManager
+	ids map[string]bool
+}
+
+func (r *racingDeleteRepo) Delete(ctx context.Context, id string) error {
+	if r.ids[id] {
+		if err := r.CrawlerRepoManager.Delete(ctx, id); err != nil {
+			return err
+		}
+	}
+	return r.CrawlerRepoManager.Delete(ctx, id)
+}
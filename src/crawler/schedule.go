@@ -0,0 +1,305 @@
+package crawler
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/sjain93/web-crawler-go/src/util"
+)
+
+// number of runs kept on each recurring crawl. The history only lives in
+// memory, the crawls themselves are kept in the repository
+const maxScheduleHistory = 100
+
+// When a recurring crawl runs, either every Interval or whenever the cron
+// expression matches (evaluated in UTC)
+type Schedule struct {
+	Interval time.Duration
+	Cron     string
+}
+
+// Parses a schedule from a duration (e.g. "6h") or a cron expression
+// (e.g. "0 3 * * *" or "@daily")
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if interval, err := time.ParseDuration(spec); err == nil {
+		return Schedule{Interval: interval}, Schedule{Interval: interval}.validate()
+	}
+
+	if _, err := util.ParseCron(spec); err != nil {
+		return Schedule{}, ErrSvcInvalidSchedule
+	}
+	return Schedule{Cron: spec}, nil
+}
+
+func (sch Schedule) String() string {
+	if sch.Cron != "" {
+		return sch.Cron
+	}
+	return "every " + sch.Interval.String()
+}
+
+// Exactly one of a positive interval or a valid cron expression must be set
+func (sch Schedule) validate() error {
+	if (sch.Interval > 0) == (sch.Cron != "") {
+		return ErrSvcInvalidSchedule
+	}
+	if sch.Cron != "" {
+		if _, err := util.ParseCron(sch.Cron); err != nil {
+			return ErrSvcInvalidSchedule
+		}
+	}
+	return nil
+}
+
+// Returns the next run after the given time, zero if there is none
+func (sch Schedule) next(after time.Time) time.Time {
+	if sch.Interval > 0 {
+		return after.Add(sch.Interval)
+	}
+
+	cron, err := util.ParseCron(sch.Cron)
+	if err != nil {
+		return time.Time{}
+	}
+	return cron.Next(after.UTC())
+}
+
+// A site crawled on a schedule, every run is recorded in History
+type RecurringCrawl struct {
+	ID         string
+	InitialURL string
+	Options    CrawlOptions
+	Schedule   Schedule
+	CreatedAt  time.Time
+	NextRunAt  time.Time
+	// true while a run is in progress, runs due in the meantime are skipped
+	Running bool
+	History []ScheduledRun
+}
+
+// A single run of a recurring crawl, JobID refers to the background job that
+// ran it and CrawlID to the stored crawl
+type ScheduledRun struct {
+	JobID      string
+	CrawlID    string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// the previous run was still going when this one was due
+	Skipped bool
+	Error   string
+}
+
+// Internal bookkeeping for a recurring crawl, stop ends its timer loop
+type scheduledCrawl struct {
+	mu   sync.Mutex
+	rec  RecurringCrawl
+	stop chan struct{}
+}
+
+func (sc *scheduledCrawl) snapshot() RecurringCrawl {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	rec := sc.rec
+	rec.History = append([]ScheduledRun{}, sc.rec.History...)
+	return rec
+}
+
+func (sc *scheduledCrawl) setNextRun(next time.Time) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.rec.NextRunAt = next
+}
+
+// Marks the crawl as running, a run that is due while the previous one is
+// still going is recorded as skipped and false is returned
+func (sc *scheduledCrawl) begin(now time.Time) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.rec.Running {
+		sc.record(ScheduledRun{StartedAt: now, FinishedAt: now, Skipped: true})
+		return false
+	}
+	sc.rec.Running = true
+	return true
+}
+
+func (sc *scheduledCrawl) finish(run ScheduledRun) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.rec.Running = false
+	sc.record(run)
+}
+
+// Appends a run, dropping the oldest once the history is full. Must be
+// called with the lock held
+func (sc *scheduledCrawl) record(run ScheduledRun) {
+	sc.rec.History = append(sc.rec.History, run)
+	if len(sc.rec.History) > maxScheduleHistory {
+		sc.rec.History = sc.rec.History[len(sc.rec.History)-maxScheduleHistory:]
+	}
+}
+
+// This service method registers a site to be crawled on a schedule, each run
+// is a fresh crawl saved in the repository. The returned ID identifies the
+// recurring crawl
+func (s *crawlerService) ScheduleCrawl(
+	crawlRec Metadata,
+	opts CrawlOptions,
+	schedule Schedule,
+) (string, error) {
+	if _, err := util.GetHost(crawlRec.InitialURL); err != nil {
+		return "", err
+	}
+	if err := schedule.validate(); err != nil {
+		return "", err
+	}
+
+	sc := &scheduledCrawl{
+		rec: RecurringCrawl{
+			ID:         uuid.NewString(),
+			InitialURL: crawlRec.InitialURL,
+			Options:    opts,
+			Schedule:   schedule,
+			CreatedAt:  s.now().UTC(),
+			History:    []ScheduledRun{},
+		},
+		stop: make(chan struct{}),
+	}
+
+	s.schedulesMu.Lock()
+	s.schedules[sc.rec.ID] = sc
+	s.schedulesMu.Unlock()
+
+	go s.runSchedule(sc)
+	s.logger.Sugar().Infof("scheduled crawl %v of %v %v", sc.rec.ID, crawlRec.InitialURL, schedule)
+
+	return sc.rec.ID, nil
+}
+
+// Waits for each run to be due and starts it, until the crawl is unscheduled
+func (s *crawlerService) runSchedule(sc *scheduledCrawl) {
+	for {
+		now := s.now().UTC()
+		next := sc.rec.Schedule.next(now)
+		sc.setNextRun(next)
+		if next.IsZero() {
+			s.logger.Sugar().Warnf("scheduled crawl %v has no upcoming runs", sc.rec.ID)
+			return
+		}
+
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-sc.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		startedAt := s.now().UTC()
+		if !s.beginRun(sc, startedAt) {
+			continue
+		}
+
+		// the crawl runs on its own so the next run is still checked on time
+		go func() {
+			defer s.scheduledRuns.Done()
+			sc.finish(s.runScheduled(sc, startedAt))
+		}()
+	}
+}
+
+// Marks a run of the recurring crawl as in progress, false if the previous run
+// is still going or the crawl was unscheduled in the meantime
+func (s *crawlerService) beginRun(sc *scheduledCrawl, startedAt time.Time) bool {
+	// registered under the lock so Close can't miss a run that is starting
+	s.schedulesMu.Lock()
+	defer s.schedulesMu.Unlock()
+
+	if s.schedules[sc.rec.ID] != sc {
+		return false
+	}
+	if !sc.begin(startedAt) {
+		s.logger.Sugar().Infof("skipping scheduled crawl %v, previous run still in progress", sc.rec.ID)
+		return false
+	}
+	s.scheduledRuns.Add(1)
+	return true
+}
+
+// Runs the crawl as a background job, so it counts against the cap on
+// concurrent crawls, and waits for the job to finish
+func (s *crawlerService) runScheduled(sc *scheduledCrawl, startedAt time.Time) ScheduledRun {
+	opts := sc.rec.Options
+	opts.ForceRefresh = true
+	run := ScheduledRun{StartedAt: startedAt}
+
+	job, err := s.startJob(Metadata{InitialURL: sc.rec.InitialURL}, opts)
+	if err == nil {
+		<-job.done
+		status := job.snapshot()
+		run.JobID, run.CrawlID = status.ID, status.CrawlID
+		switch status.Status {
+		case JobFailed:
+			err = errors.New(status.Error)
+		case JobCancelled:
+			err = ErrSvcCrawlCancelled
+		}
+	}
+	if err != nil {
+		s.logger.Sugar().Errorf("scheduled crawl %v failed: %v", sc.rec.ID, err.Error())
+		run.Error = err.Error()
+	}
+	run.FinishedAt = s.now().UTC()
+
+	return run
+}
+
+func (s *crawlerService) GetSchedule(id string) (RecurringCrawl, error) {
+	s.schedulesMu.Lock()
+	sc, ok := s.schedules[id]
+	s.schedulesMu.Unlock()
+	if !ok {
+		return RecurringCrawl{}, ErrSvcScheduleNotFound
+	}
+
+	return sc.snapshot(), nil
+}
+
+// Returns every recurring crawl, oldest first
+func (s *crawlerService) ListSchedules() []RecurringCrawl {
+	s.schedulesMu.Lock()
+	recs := make([]RecurringCrawl, 0, len(s.schedules))
+	for _, sc := range s.schedules {
+		recs = append(recs, sc.snapshot())
+	}
+	s.schedulesMu.Unlock()
+
+	sort.Slice(recs, func(i, j int) bool {
+		return recs[i].CreatedAt.Before(recs[j].CreatedAt)
+	})
+	return recs
+}
+
+// Stops future runs of a recurring crawl, a run in progress is left to finish
+func (s *crawlerService) Unschedule(id string) error {
+	s.schedulesMu.Lock()
+	defer s.schedulesMu.Unlock()
+
+	sc, ok := s.schedules[id]
+	if !ok {
+		return ErrSvcScheduleNotFound
+	}
+	close(sc.stop)
+	delete(s.schedules, id)
+
+	return nil
+}
@@ -0,0 +1,193 @@
+package crawler_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sjain93/web-crawler-go/config"
+	"github.com/sjain93/web-crawler-go/src/crawler"
+	"github.com/sjain93/web-crawler-go/src/crawler/instance/instancetest"
+	"github.com/sjain93/web-crawler-go/src/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSchedule(t *testing.T) {
+	testCases := map[string]struct {
+		spec        string
+		expected    crawler.Schedule
+		expectedErr error
+	}{
+		"Interval": {
+			spec:     "6h",
+			expected: crawler.Schedule{Interval: 6 * time.Hour},
+		},
+		"Cron expression": {
+			spec:     "0 3 * * 1-5",
+			expected: crawler.Schedule{Cron: "0 3 * * 1-5"},
+		},
+		"Cron macro": {
+			spec:     "@daily",
+			expected: crawler.Schedule{Cron: "@daily"},
+		},
+		"Negative interval": {
+			spec:        "-1h",
+			expectedErr: crawler.ErrSvcInvalidSchedule,
+		},
+		"Neither": {
+			spec:        "every day",
+			expectedErr: crawler.ErrSvcInvalidSchedule,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			schedule, err := crawler.ParseSchedule(tc.spec)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, schedule)
+		})
+	}
+}
+
+func TestScheduleCrawl(t *testing.T) {
+	newService := func(factory *instancetest.Factory) crawler.CrawlerServiceManager {
+		crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+		assert.NoError(t, err)
+		crawlerSvc, err := crawler.NewCrawlerService(
+			crawler.WithRepository(crawlerRepo),
+			crawler.WithCrawlerFactory(factory),
+		)
+		assert.NoError(t, err)
+		return crawlerSvc
+	}
+
+	t.Run("Validation", func(t *testing.T) {
+		testCases := map[string]struct {
+			initialURL  string
+			schedule    crawler.Schedule
+			expectedErr error
+		}{
+			"Invalid URL": {
+				initialURL:  "ww.monzo.com",
+				schedule:    crawler.Schedule{Interval: time.Hour},
+				expectedErr: util.ErrUtilInvalidHost,
+			},
+			"Missing schedule": {
+				initialURL:  "https://monzo.com/",
+				expectedErr: crawler.ErrSvcInvalidSchedule,
+			},
+			"Interval and cron": {
+				initialURL:  "https://monzo.com/",
+				schedule:    crawler.Schedule{Interval: time.Hour, Cron: "@daily"},
+				expectedErr: crawler.ErrSvcInvalidSchedule,
+			},
+			"Invalid cron": {
+				initialURL:  "https://monzo.com/",
+				schedule:    crawler.Schedule{Cron: "0 25 * * *"},
+				expectedErr: crawler.ErrSvcInvalidSchedule,
+			},
+		}
+
+		crawlerSvc := newService(&instancetest.Factory{})
+		for name, tc := range testCases {
+			t.Run(name, func(t *testing.T) {
+				_, err := crawlerSvc.ScheduleCrawl(
+					crawler.Metadata{InitialURL: tc.initialURL},
+					crawler.CrawlOptions{},
+					tc.schedule,
+				)
+				assert.ErrorIs(t, err, tc.expectedErr)
+			})
+		}
+		assert.Empty(t, crawlerSvc.ListSchedules())
+	})
+
+	t.Run("Runs are skipped while the previous one is going", func(t *testing.T) {
+		factory := &instancetest.Factory{Block: make(chan struct{})}
+		crawlerSvc := newService(factory)
+
+		id, err := crawlerSvc.ScheduleCrawl(
+			crawler.Metadata{InitialURL: "https://monzo.com/"},
+			crawler.CrawlOptions{},
+			crawler.Schedule{Interval: 10 * time.Millisecond},
+		)
+		assert.NoError(t, err)
+
+		// the first run blocks, so the runs due after it are skipped
+		assert.Eventually(t, func() bool {
+			rec, err := crawlerSvc.GetSchedule(id)
+			return err == nil && rec.Running && len(rec.History) > 0
+		}, time.Second, 5*time.Millisecond)
+		rec, _ := crawlerSvc.GetSchedule(id)
+		assert.True(t, rec.History[0].Skipped)
+		assert.Len(t, factory.Crawlers(), 1)
+
+		close(factory.Block)
+		assert.Eventually(t, func() bool {
+			rec, _ := crawlerSvc.GetSchedule(id)
+			for _, run := range rec.History {
+				if run.CrawlID != "" {
+					return true
+				}
+			}
+			return false
+		}, time.Second, 5*time.Millisecond)
+
+		assert.NoError(t, crawlerSvc.Unschedule(id))
+		assert.ErrorIs(t, crawlerSvc.Unschedule(id), crawler.ErrSvcScheduleNotFound)
+		_, err = crawlerSvc.GetSchedule(id)
+		assert.ErrorIs(t, err, crawler.ErrSvcScheduleNotFound)
+
+		// every completed run is a separate crawl in the repository
+		history, err := crawlerSvc.GetCrawlHistory()
+		assert.NoError(t, err)
+		assert.NotEmpty(t, history)
+		for _, crawlRec := range history {
+			assert.Equal(t, "https://monzo.com/", crawlRec.InitialURL)
+		}
+	})
+
+	t.Run("Runs are queued as jobs and waited on when closing", func(t *testing.T) {
+		factory := &instancetest.Factory{Block: make(chan struct{})}
+		crawlerSvc := newService(factory)
+
+		// both crawl slots are taken, so the scheduled run has to queue
+		for _, initURL := range []string{"https://go.dev/", "https://go.dev/blog/"} {
+			_, err := crawlerSvc.StartCrawl(crawler.Metadata{InitialURL: initURL}, crawler.CrawlOptions{})
+			assert.NoError(t, err)
+		}
+		id, err := crawlerSvc.ScheduleCrawl(
+			crawler.Metadata{InitialURL: "https://monzo.com/"},
+			crawler.CrawlOptions{},
+			crawler.Schedule{Interval: 10 * time.Millisecond},
+		)
+		assert.NoError(t, err)
+
+		assert.Eventually(t, func() bool {
+			jobs := crawlerSvc.ListJobs()
+			return len(jobs) == 3 && jobs[2].Status == crawler.JobQueued
+		}, time.Second, 5*time.Millisecond)
+		assert.Len(t, factory.Crawlers(), 2)
+
+		closed := make(chan struct{})
+		go func() {
+			crawlerSvc.Close()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+			t.Fatal("service closed with a scheduled run in progress")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(factory.Block)
+		<-closed
+		jobs := crawlerSvc.ListJobs()
+		assert.Equal(t, crawler.JobCompleted, jobs[2].Status)
+		_, err = crawlerSvc.GetSchedule(id)
+		assert.ErrorIs(t, err, crawler.ErrSvcScheduleNotFound)
+	})
+}
@@ -0,0 +1,75 @@
+package crawler
+
+import "sync"
+
+// A queued unit of work and its priority
+type scheduledJob struct {
+	priority int
+	run      func()
+}
+
+// jobScheduler caps the number of background crawls running at the same time,
+// the rest wait in a queue ordered by priority and then by submission (FIFO)
+type jobScheduler struct {
+	mu         sync.Mutex
+	maxRunning int
+	running    int
+	// kept in submission order, so the first of equal priority jobs is oldest
+	queue []scheduledJob
+}
+
+// A non-positive limit runs every job as soon as it is submitted
+func newJobScheduler(maxRunning int) *jobScheduler {
+	return &jobScheduler{maxRunning: maxRunning}
+}
+
+// Runs the job straight away if there is capacity, otherwise queues it
+func (js *jobScheduler) submit(priority int, run func()) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	job := scheduledJob{priority: priority, run: run}
+	if js.maxRunning <= 0 || js.running < js.maxRunning {
+		js.running++
+		go js.exec(job)
+		return
+	}
+
+	js.queue = append(js.queue, job)
+}
+
+// Runs the job and then hands its slot to the next job in the queue
+func (js *jobScheduler) exec(job scheduledJob) {
+	for {
+		job.run()
+
+		js.mu.Lock()
+		next, ok := js.next()
+		if !ok {
+			js.running--
+			js.mu.Unlock()
+			return
+		}
+		js.mu.Unlock()
+		job = next
+	}
+}
+
+// Pops the highest priority job, ties go to the job submitted first. Must be
+// called with the lock held
+func (js *jobScheduler) next() (scheduledJob, bool) {
+	if len(js.queue) == 0 {
+		return scheduledJob{}, false
+	}
+
+	best := 0
+	for i, job := range js.queue[1:] {
+		if job.priority > js.queue[best].priority {
+			best = i + 1
+		}
+	}
+
+	job := js.queue[best]
+	js.queue = append(js.queue[:best], js.queue[best+1:]...)
+	return job, true
+}
@@ -0,0 +1,177 @@
+package crawler
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sjain93/web-crawler-go/src/crawler/instance"
+)
+
+type SearchMode string
+
+const (
+	SearchExact  SearchMode = "exact"
+	SearchPrefix SearchMode = "prefix"
+	SearchRegex  SearchMode = "regex"
+)
+
+// Finds the pages of stored crawls by URL, the pattern is an exact URL, a
+// URL prefix or a regular expression depending on the mode
+type PageSearch struct {
+	Mode    SearchMode
+	Pattern string
+}
+
+// A page found by a search and the crawl it belongs to
+type PageMatch struct {
+	CrawlID   string
+	CreatedAt time.Time
+	Page      instance.PageRecord
+}
+
+// A validated search, the regex is compiled once per search
+type urlMatcher struct {
+	mode    SearchMode
+	pattern string
+	re      *regexp.Regexp
+}
+
+func newURLMatcher(q PageSearch) (urlMatcher, error) {
+	m := urlMatcher{mode: q.Mode, pattern: q.Pattern}
+	if q.Pattern == "" {
+		return m, ErrInvalidSearch
+	}
+
+	switch q.Mode {
+	case SearchExact, SearchPrefix:
+	case SearchRegex:
+		re, err := regexp.Compile(q.Pattern)
+		if err != nil {
+			return m, errors.Wrap(ErrInvalidSearch, err.Error())
+		}
+		m.re = re
+	default:
+		return m, ErrInvalidSearch
+	}
+	return m, nil
+}
+
+func (m urlMatcher) matches(url string) bool {
+	switch m.mode {
+	case SearchExact:
+		return url == m.pattern
+	case SearchPrefix:
+		return strings.HasPrefix(url, m.pattern)
+	default:
+		return m.re.MatchString(url)
+	}
+}
+
+// Returns the record of a crawled URL, crawls stored without page records
+// only know the URL itself
+func pageRecord(crawlRec Metadata, url string) instance.PageRecord {
+	// page records are sorted by URL
+	i := sort.Search(len(crawlRec.Pages), func(i int) bool {
+		return crawlRec.Pages[i].URL >= url
+	})
+	if i < len(crawlRec.Pages) && crawlRec.Pages[i].URL == url {
+		return crawlRec.Pages[i]
+	}
+	return instance.PageRecord{URL: url}
+}
+
+// Looks up the URLs matched in the index and returns a match per crawl that
+// found each of them, oldest crawl first. Crawls the lookup can't find are
+// skipped
+func collectMatches(
+	urls *urlIndex,
+	match urlMatcher,
+	get func(id string) (Metadata, bool, error),
+) ([]PageMatch, error) {
+	matches := []PageMatch{}
+	for url, ids := range urls.search(match) {
+		for id := range ids {
+			crawlRec, ok, err := get(id)
+			if err != nil {
+				return []PageMatch{}, err
+			}
+			if !ok {
+				continue
+			}
+			matches = append(matches, PageMatch{
+				CrawlID:   crawlRec.ID,
+				CreatedAt: crawlRec.CreatedAt,
+				Page:      pageRecord(crawlRec, url),
+			})
+		}
+	}
+	sortPageMatches(matches)
+
+	return matches, nil
+}
+
+// Oldest crawl first, so the first match shows when a page first appeared
+func sortPageMatches(matches []PageMatch) {
+	sort.Slice(matches, func(i, j int) bool {
+		if !matches[i].CreatedAt.Equal(matches[j].CreatedAt) {
+			return matches[i].CreatedAt.Before(matches[j].CreatedAt)
+		}
+		if matches[i].CrawlID != matches[j].CrawlID {
+			return matches[i].CrawlID < matches[j].CrawlID
+		}
+		return matches[i].Page.URL < matches[j].Page.URL
+	})
+}
+
+// This service method finds every stored crawl that saw a page matching the
+// search, oldest crawl first
+func (s *crawlerService) SearchCrawls(q PageSearch) ([]PageMatch, error) {
+	matches, err := s.crawlerRepo.SearchPages(context.Background(), q)
+	if err != nil && errors.Is(err, ErrInvalidSearch) {
+		return []PageMatch{}, ErrSvcInvalidSearch
+	} else if err != nil {
+		return []PageMatch{}, err
+	}
+	if err = s.matchPages(matches); err != nil {
+		return []PageMatch{}, err
+	}
+
+	return matches, nil
+}
+
+// Fills in the page records of matches found in crawls saved without them,
+// reading the pages of each crawl from the page store once
+func (s *crawlerService) matchPages(matches []PageMatch) error {
+	if s.pageStore == nil {
+		return nil
+	}
+
+	// indexes of the matches without a fetched page, by crawl and URL
+	missing := map[string]map[string][]int{}
+	for i, m := range matches {
+		if m.Page.StatusCode != 0 || m.Page.Error != "" {
+			continue
+		}
+		if missing[m.CrawlID] == nil {
+			missing[m.CrawlID] = map[string][]int{}
+		}
+		missing[m.CrawlID][m.Page.URL] = append(missing[m.CrawlID][m.Page.URL], i)
+	}
+
+	for id, urls := range missing {
+		err := s.pageStore.ScanPages(id, func(page instance.PageRecord) error {
+			for _, i := range urls[page.URL] {
+				matches[i].Page = page
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, ErrPagesNotFound) {
+			return err
+		}
+	}
+	return nil
+}
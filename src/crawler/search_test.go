@@ -0,0 +1,172 @@
+package crawler_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sjain93/web-crawler-go/config"
+	"github.com/sjain93/web-crawler-go/src/crawler"
+	"github.com/sjain93/web-crawler-go/src/crawler/instance"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchPages(t *testing.T) {
+	now := time.Date(2023, time.March, 15, 12, 0, 0, 0, time.UTC)
+
+	inMemDB := config.GetInMemoryStore[crawler.Metadata]()
+	preLoad(inMemDB,
+		crawler.Metadata{
+			ID:             "monzo-1",
+			Host:           "monzo.com",
+			CrawlResultSet: []string{"https://monzo.com/", "https://monzo.com/isa/"},
+			CreatedAt:      now.Add(-48 * time.Hour),
+		},
+		crawler.Metadata{
+			ID:   "monzo-2",
+			Host: "monzo.com",
+			Pages: []instance.PageRecord{
+				{URL: "https://monzo.com/", StatusCode: http.StatusOK},
+				{URL: "https://monzo.com/isa/", StatusCode: http.StatusOK},
+				{URL: "https://monzo.com/pricing/", StatusCode: http.StatusOK, ContentType: "text/html"},
+			},
+			CreatedAt: now.Add(-24 * time.Hour),
+		},
+		crawler.Metadata{
+			ID:             "koho-1",
+			Host:           "www.koho.ca",
+			CrawlResultSet: []string{"https://www.koho.ca/", "https://www.koho.ca/pricing/"},
+			CreatedAt:      now,
+		},
+	)
+	crawlerRepo, err := crawler.NewCrawlerRepository(inMemDB)
+	assert.NoError(t, err)
+
+	type match struct {
+		crawlID string
+		url     string
+	}
+
+	testCases := map[string]struct {
+		search      crawler.PageSearch
+		expected    []match
+		expectedErr error
+	}{
+		"Exact": {
+			search: crawler.PageSearch{Mode: crawler.SearchExact, Pattern: "https://monzo.com/isa/"},
+			expected: []match{
+				{"monzo-1", "https://monzo.com/isa/"},
+				{"monzo-2", "https://monzo.com/isa/"},
+			},
+		},
+		"Prefix": {
+			search: crawler.PageSearch{Mode: crawler.SearchPrefix, Pattern: "https://monzo.com/"},
+			expected: []match{
+				{"monzo-1", "https://monzo.com/"},
+				{"monzo-1", "https://monzo.com/isa/"},
+				{"monzo-2", "https://monzo.com/"},
+				{"monzo-2", "https://monzo.com/isa/"},
+				{"monzo-2", "https://monzo.com/pricing/"},
+			},
+		},
+		"Regex - first appearance of a path": {
+			search: crawler.PageSearch{Mode: crawler.SearchRegex, Pattern: `/pricing/?$`},
+			expected: []match{
+				{"monzo-2", "https://monzo.com/pricing/"},
+				{"koho-1", "https://www.koho.ca/pricing/"},
+			},
+		},
+		"No match": {
+			search:   crawler.PageSearch{Mode: crawler.SearchExact, Pattern: "https://monzo.com/help/"},
+			expected: []match{},
+		},
+		"Invalid regex": {
+			search:      crawler.PageSearch{Mode: crawler.SearchRegex, Pattern: "(pricing"},
+			expectedErr: crawler.ErrInvalidSearch,
+		},
+		"Unknown mode": {
+			search:      crawler.PageSearch{Mode: "glob", Pattern: "*"},
+			expectedErr: crawler.ErrInvalidSearch,
+		},
+		"Empty pattern": {
+			search:      crawler.PageSearch{Mode: crawler.SearchPrefix},
+			expectedErr: crawler.ErrInvalidSearch,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			matches, err := crawlerRepo.SearchPages(context.Background(), tc.search)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+
+			res := []match{}
+			for _, m := range matches {
+				res = append(res, match{m.CrawlID, m.Page.URL})
+			}
+			assert.Equal(t, tc.expected, res)
+		})
+	}
+
+	t.Run("Page records are returned", func(t *testing.T) {
+		matches, err := crawlerRepo.SearchPages(context.Background(), crawler.PageSearch{
+			Mode:    crawler.SearchExact,
+			Pattern: "https://monzo.com/pricing/",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []crawler.PageMatch{{
+			CrawlID:   "monzo-2",
+			CreatedAt: now.Add(-24 * time.Hour),
+			Page: instance.PageRecord{
+				URL:         "https://monzo.com/pricing/",
+				StatusCode:  http.StatusOK,
+				ContentType: "text/html",
+			},
+		}}, matches)
+	})
+
+	t.Run("Index follows saves and deletes", func(t *testing.T) {
+		search := crawler.PageSearch{Mode: crawler.SearchPrefix, Pattern: "https://spacy.io/"}
+
+		crawlRec := crawler.Metadata{
+			ID:             "spacy-1",
+			Host:           "spacy.io",
+			CrawlResultSet: []string{"https://spacy.io/", "https://spacy.io/usage/"},
+		}
+		assert.NoError(t, crawlerRepo.Save(context.Background(), &crawlRec))
+		matches, err := crawlerRepo.SearchPages(context.Background(), search)
+		assert.NoError(t, err)
+		assert.Len(t, matches, 2)
+
+		assert.NoError(t, crawlerRepo.Delete(context.Background(), "spacy-1"))
+		matches, err = crawlerRepo.SearchPages(context.Background(), search)
+		assert.NoError(t, err)
+		assert.Empty(t, matches)
+	})
+}
+
+func TestSearchCrawls(t *testing.T) {
+	inMemDB := config.GetInMemoryStore[crawler.Metadata]()
+	preLoad(inMemDB, crawler.Metadata{
+		ID:             "5eb020a4-54cc-4b57-b19f-cbd33a2df881",
+		Host:           "monzo.com",
+		CrawlResultSet: []string{"https://monzo.com/", "https://monzo.com/pricing/"},
+	})
+	crawlerRepo, err := crawler.NewCrawlerRepository(inMemDB)
+	assert.NoError(t, err)
+
+	crawlerSvc, err := crawler.NewCrawlerService(crawler.WithRepository(crawlerRepo))
+	assert.NoError(t, err)
+
+	matches, err := crawlerSvc.SearchCrawls(crawler.PageSearch{Mode: crawler.SearchRegex, Pattern: "pricing"})
+	assert.NoError(t, err)
+	assert.Len(t, matches, 1)
+	assert.Equal(t, "5eb020a4-54cc-4b57-b19f-cbd33a2df881", matches[0].CrawlID)
+
+	_, err = crawlerSvc.SearchCrawls(crawler.PageSearch{Mode: crawler.SearchRegex, Pattern: "["})
+	assert.ErrorIs(t, err, crawler.ErrSvcInvalidSearch)
+}
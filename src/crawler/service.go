@@ -1,6 +1,8 @@
 package crawler
 
 import (
+	"context"
+	"io"
 	"sync"
 	"time"
 
@@ -11,83 +13,452 @@ import (
 	"go.uber.org/zap"
 )
 
-var (
-	once sync.Once
-	svc  *crawlerService
+const (
+	// how often the state of an in progress crawl is written to the checkpoint store
+	checkpointInterval = 30 * time.Second
+	// how long a previous crawl of the same site is reused before recrawling
+	DefaultCacheTTL = 24 * time.Hour
+	// default number of background crawls allowed to run at the same time
+	defaultMaxConcurrentCrawls = 2
+	// default number of requests in flight across every crawl
+	defaultMaxInFlightRequests = 650
 )
 
+// Service wide settings shared by every crawl
+type ServiceConfig struct {
+	// a non-positive TTL disables reuse of previous crawls
+	CacheTTL time.Duration
+	// background crawls beyond this limit are queued, zero is unlimited
+	MaxConcurrentCrawls int
+	// total requests in flight across all crawls, zero is unlimited
+	MaxInFlightRequests uint
+	// fills in any crawl setting a request leaves unset
+	DefaultOptions CrawlOptions
+	// limits on the stored crawls, nothing is pruned by default
+	Retention RetentionPolicy
+	// how long Close waits for webhook deliveries in progress, zero uses the
+	// 30 second default
+	WebhookDrainTimeout time.Duration
+}
+
+// Setup service config based on default values
+func NewDefaultServiceConfig() *ServiceConfig {
+	return &ServiceConfig{
+		CacheTTL:            DefaultCacheTTL,
+		MaxConcurrentCrawls: defaultMaxConcurrentCrawls,
+		MaxInFlightRequests: defaultMaxInFlightRequests,
+	}
+}
+
 // service errors
 var (
-	ErrSvcRecordExists   = errors.New("target record id already exists")
-	ErrSvcRecordNotFound = errors.New("target was not found")
-	ErrSvcHostNotFound   = errors.New("provide resource is missing domain")
-	ErrSvcProcessError   = errors.New("there was an error during the crawl process")
+	ErrSvcRecordExists     = errors.New("target record id already exists")
+	ErrSvcRecordNotFound   = errors.New("target was not found")
+	ErrSvcHostNotFound     = errors.New("provide resource is missing domain")
+	ErrSvcProcessError     = errors.New("there was an error during the crawl process")
+	ErrSvcNoCheckpoints    = errors.New("checkpointing is not configured")
+	ErrSvcNoCheckpoint     = errors.New("no checkpoint exists for the crawl id")
+	ErrSvcCrawlCancelled   = errors.New("the crawl was cancelled")
+	ErrSvcCrawlRunning     = errors.New("the crawl is still running")
+	ErrSvcJobNotFound      = errors.New("crawl job was not found")
+	ErrSvcJobFinished      = errors.New("crawl job has already finished")
+	ErrSvcNoRepository     = errors.New("no crawler repository provided")
+	ErrSvcInvalidSchedule  = errors.New("schedule needs either a positive interval or a valid cron expression")
+	ErrSvcScheduleNotFound = errors.New("scheduled crawl was not found")
+	ErrSvcInvalidQuery     = errors.New("crawl history query is invalid")
+	ErrSvcInvalidCursor    = errors.New("crawl history cursor is invalid")
+	ErrSvcInvalidSearch    = errors.New("page search is invalid")
 )
 
 // Public interface for accessing the service
 type CrawlerServiceManager interface {
-	CrawlSite(crawlRec Metadata) ([]Metadata, error)
+	CrawlSite(crawlRec Metadata, opts CrawlOptions) ([]Metadata, error)
+	GetCachedCrawl(crawlRec Metadata, opts CrawlOptions) ([]Metadata, error)
 	GetCrawlHistory() ([]Metadata, error)
+	QueryCrawls(q CrawlQuery) (CrawlHistoryPage, error)
+	SearchCrawls(q PageSearch) ([]PageMatch, error)
 	GetCrawl(id string) ([]Metadata, error)
+	ResumeCrawl(id string) ([]Metadata, error)
+	ListCheckpoints() ([]Checkpoint, error)
+	StartCrawl(crawlRec Metadata, opts CrawlOptions) (string, error)
+	GetJob(id string) (Job, error)
+	CancelJob(id string) error
+	ListJobs() []Job
+	DiffCrawls(idA, idB string) (CrawlDiff, error)
+	ScheduleCrawl(crawlRec Metadata, opts CrawlOptions, schedule Schedule) (string, error)
+	GetSchedule(id string) (RecurringCrawl, error)
+	ListSchedules() []RecurringCrawl
+	Unschedule(id string) error
+	GetWebhookDeliveries(crawlID string) []WebhookDelivery
+	DeleteCrawl(id string) error
+	PruneCrawls() ([]string, error)
+	StreamPages(id string, fn func(page instance.PageRecord) error) error
+	ExportCrawls(w io.Writer, ids ...string) (int, error)
+	ImportCrawls(r io.Reader, policy CollisionPolicy) (ImportResult, error)
+	ImportReport(r io.Reader, policy CollisionPolicy) (ImportResult, error)
+	WriteReport(rw ReportWriter, crawls []Metadata) error
+	Close()
 }
 
 type crawlerService struct {
-	logger      *zap.Logger
-	crawlerRepo CrawlerRepoManager
+	logger         *zap.Logger
+	crawlerRepo    CrawlerRepoManager
+	checkpointRepo CheckpointManager
+	pageStore      PageStoreManager
+	crawlerFactory CrawlerFactory
+	webhooks       []WebhookConfig
+	cfg            ServiceConfig
+	now            func() time.Time
+	// background crawls started through StartCrawl, keyed by job ID
+	jobsMu    sync.Mutex
+	jobs      map[string]*crawlJob
+	scheduler *jobScheduler
+	// shared by every crawler instance to cap requests in flight
+	fetchLimiter util.Semaphore
+	// crawls in progress keyed by fingerprint, and the background job (if any)
+	// responsible for each fingerprint
+	inflightMu sync.Mutex
+	inflight   map[string]*inflightCrawl
+	activeJobs map[string]string
+	// IDs of the crawls running in this service, their checkpoints are not
+	// offered for resuming
+	running map[string]struct{}
+	// recurring crawls keyed by their ID, and their runs in progress
+	schedulesMu   sync.Mutex
+	schedules     map[string]*scheduledCrawl
+	scheduledRuns sync.WaitGroup
+	// posts a summary of every finished crawl to the configured webhooks
+	notifier *webhookNotifier
+	// closed once the service stops its background work
+	closeOnce sync.Once
+	closed    chan struct{}
 }
 
-func NewCrawlerService(r CrawlerRepoManager, l *zap.Logger) CrawlerServiceManager {
-	once.Do(func() {
-		svc = &crawlerService{
-			logger:      l,
-			crawlerRepo: r,
-		}
-	})
-	return svc
+// A crawl in progress that other callers with the same fingerprint wait on,
+// the results are only read once done is closed
+type inflightCrawl struct {
+	done   chan struct{}
+	crawls []Metadata
+	err    error
+}
+
+// Functional options used to configure the service
+type ServiceOption func(*crawlerService)
+
+// Sets the repository crawl results are saved in, required
+func WithRepository(r CrawlerRepoManager) ServiceOption {
+	return func(s *crawlerService) {
+		s.crawlerRepo = r
+	}
+}
+
+// Sets the logger, logging is disabled by default
+func WithLogger(l *zap.Logger) ServiceOption {
+	return func(s *crawlerService) {
+		s.logger = l
+	}
+}
+
+// Enables checkpointing of in progress crawls so they can be resumed
+func WithCheckpoints(cp CheckpointManager) ServiceOption {
+	return func(s *crawlerService) {
+		s.checkpointRepo = cp
+	}
+}
+
+// Writes the page records of every crawl to the page store as they are
+// fetched, so they can be streamed back with StreamPages
+func WithPageStore(ps PageStoreManager) ServiceOption {
+	return func(s *crawlerService) {
+		s.pageStore = ps
+	}
+}
+
+// Sets the clock used for cache expiry and job timestamps
+func WithClock(now func() time.Time) ServiceOption {
+	return func(s *crawlerService) {
+		s.now = now
+	}
+}
+
+// Replaces the factory used to construct crawler instances
+func WithCrawlerFactory(f CrawlerFactory) ServiceOption {
+	return func(s *crawlerService) {
+		s.crawlerFactory = f
+	}
+}
+
+// Replaces the default service config
+func WithConfig(cfg ServiceConfig) ServiceOption {
+	return func(s *crawlerService) {
+		s.cfg = cfg
+	}
+}
+
+// Sets the webhooks notified when a crawl completes or fails
+func WithWebhooks(hooks ...WebhookConfig) ServiceOption {
+	return func(s *crawlerService) {
+		s.webhooks = append(s.webhooks, hooks...)
+	}
+}
+
+// Every call returns an independent service, a repository must be provided
+// while everything else falls back to a default
+func NewCrawlerService(opts ...ServiceOption) (CrawlerServiceManager, error) {
+	s := &crawlerService{
+		logger:         zap.NewNop(),
+		crawlerFactory: instanceFactory{},
+		cfg:            *NewDefaultServiceConfig(),
+		now:            time.Now,
+		jobs:           map[string]*crawlJob{},
+		inflight:       map[string]*inflightCrawl{},
+		activeJobs:     map[string]string{},
+		running:        map[string]struct{}{},
+		schedules:      map[string]*scheduledCrawl{},
+		closed:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.crawlerRepo == nil {
+		return &crawlerService{}, ErrSvcNoRepository
+	}
+
+	s.scheduler = newJobScheduler(s.cfg.MaxConcurrentCrawls)
+	s.notifier = newWebhookNotifier(s.webhooks, s.now)
+	if s.cfg.Retention.enabled() && s.cfg.Retention.Interval > 0 {
+		go s.runRetention()
+	}
+	if s.cfg.MaxInFlightRequests > 0 {
+		s.fetchLimiter = util.NewSemaphore(s.cfg.MaxInFlightRequests)
+	}
+
+	return s, nil
 }
 
 // This service method validates the URL passed in, checks to see if there are any
 // previous crawls that match the seach criteria and optionally executes a new crawl
 // by initializing an instance of the crawler. Results are saved in the mem store
-func (s *crawlerService) CrawlSite(crawlRec Metadata) ([]Metadata, error) {
+func (s *crawlerService) CrawlSite(crawlRec Metadata, opts CrawlOptions) ([]Metadata, error) {
+	return s.crawlSite(crawlRec, opts, nil)
+}
+
+// Shared by CrawlSite and background jobs, the job is nil for synchronous crawls
+func (s *crawlerService) crawlSite(
+	crawlRec Metadata,
+	opts CrawlOptions,
+	job *crawlJob,
+) ([]Metadata, error) {
 	host, err := util.GetHost(crawlRec.InitialURL)
 	if err != nil {
 		return []Metadata{}, err
 	}
 	crawlRec.Host = host
-	s.logger.Sugar().Info("valid host")
 
-	// opportunity to early exit if crawler results exist already
-	prevCrawls, err := s.crawlerRepo.GetCrawlsByHost(&crawlRec)
+	opts = s.resolveOptions(opts)
+	crawlRec.Options = opts.forStorage()
+
+	crawlRec.Fingerprint, err = Fingerprint(crawlRec.InitialURL, opts)
 	if err != nil {
 		return []Metadata{}, err
 	}
-	if len(prevCrawls) > 0 && inTimeSpan(prevCrawls[0].CreatedAt) {
+
+	// only one crawl per fingerprint runs at a time, concurrent callers wait
+	// for it and share its results
+	s.inflightMu.Lock()
+	if call, ok := s.inflight[crawlRec.Fingerprint]; ok {
+		s.inflightMu.Unlock()
 		s.logger.Sugar().Infof(
-			"previous results exist for host - %v",
-			crawlRec.Host,
+			"crawl of %v already in progress, waiting for its results",
+			crawlRec.InitialURL,
 		)
-		return []Metadata{prevCrawls[0]}, nil
+		<-call.done
+		return call.crawls, call.err
+	}
+	call := &inflightCrawl{done: make(chan struct{})}
+	s.inflight[crawlRec.Fingerprint] = call
+	s.inflightMu.Unlock()
+
+	// the cache is checked after registering, so a caller arriving once the
+	// crawl is done finds its saved results instead of crawling again
+	call.crawls, call.err = s.cachedOrNewCrawl(crawlRec, opts, job)
+
+	s.inflightMu.Lock()
+	delete(s.inflight, crawlRec.Fingerprint)
+	s.inflightMu.Unlock()
+	close(call.done)
+
+	return call.crawls, call.err
+}
+
+func (s *crawlerService) cachedOrNewCrawl(
+	crawlRec Metadata,
+	opts CrawlOptions,
+	job *crawlJob,
+) ([]Metadata, error) {
+	// opportunity to early exit if crawler results exist already
+	prevCrawls, err := s.GetCachedCrawl(crawlRec, opts)
+	if err != nil {
+		return []Metadata{}, err
+	}
+	if len(prevCrawls) > 0 {
+		return prevCrawls, nil
 	}
 
 	// Populate metadata with a new ID for this crawl
 	crawlRec.ID = uuid.NewString()
 
 	// init new crawler
-	crawler, err := instance.NewCrawler(
-		crawlRec.InitialURL,
-		instance.Config{
-			WokerSetting: util.SetupDefaultConcurrency(),
-			HttpClient:   util.NewDefaultHTTPClient(),
-		},
-	)
+	crawler, err := s.crawlerFactory.NewCrawler(crawlRec.InitialURL, s.newInstanceConfig(crawlRec.ID, opts))
+	if err != nil {
+		return []Metadata{}, err
+	}
+
+	return s.runCrawl(crawlRec, opts, crawler, job)
+}
+
+// This service method lists the interrupted crawls that can be resumed, most
+// recently checkpointed first. Running crawls checkpoint as well and are left
+// out
+func (s *crawlerService) ListCheckpoints() ([]Checkpoint, error) {
+	if s.checkpointRepo == nil {
+		return []Checkpoint{}, ErrSvcNoCheckpoints
+	}
+	checkpoints, err := s.checkpointRepo.List()
 	if err != nil {
+		return []Checkpoint{}, err
+	}
+
+	s.inflightMu.Lock()
+	defer s.inflightMu.Unlock()
+	interrupted := []Checkpoint{}
+	for _, cp := range checkpoints {
+		if _, ok := s.running[cp.ID]; !ok {
+			interrupted = append(interrupted, cp)
+		}
+	}
+	return interrupted, nil
+}
+
+// This service method picks up an interrupted crawl from its last checkpoint,
+// pages visited before the interruption are not fetched again
+func (s *crawlerService) ResumeCrawl(id string) ([]Metadata, error) {
+	if s.checkpointRepo == nil {
+		return []Metadata{}, ErrSvcNoCheckpoints
+	}
+
+	cp, err := s.checkpointRepo.Get(id)
+	if err != nil && errors.Is(err, ErrCheckpointNotFound) {
+		return []Metadata{}, ErrSvcNoCheckpoint
+	} else if err != nil {
 		return []Metadata{}, err
 	}
 
-	s.logger.Sugar().Info("beginning new web crawl, this may take some time")
+	// the crawl may have been saved right before the process stopped
+	crawlRec, err := s.crawlerRepo.GetCrawlByID(context.Background(), cp.ID)
+	if err == nil {
+		s.logger.Sugar().Infof("crawl %v already completed, discarding checkpoint", cp.ID)
+		return []Metadata{crawlRec}, s.checkpointRepo.Delete(cp.ID)
+	} else if !errors.Is(err, ErrRecordNotFound) {
+		return []Metadata{}, err
+	}
+
+	fingerprint, err := Fingerprint(cp.InitialURL, cp.Options)
+	if err != nil {
+		return []Metadata{}, err
+	}
+
+	// a running crawl is not resumed a second time, and the resumed crawl is
+	// shared with new crawls of the same target like any other crawl
+	s.inflightMu.Lock()
+	_, running := s.running[cp.ID]
+	_, inflight := s.inflight[fingerprint]
+	if running || inflight {
+		s.inflightMu.Unlock()
+		return []Metadata{}, ErrSvcCrawlRunning
+	}
+	call := &inflightCrawl{done: make(chan struct{})}
+	s.inflight[fingerprint] = call
+	s.inflightMu.Unlock()
+
+	call.crawls, call.err = s.resumeCrawl(cp, fingerprint)
+
+	s.inflightMu.Lock()
+	delete(s.inflight, fingerprint)
+	s.inflightMu.Unlock()
+	close(call.done)
+
+	return call.crawls, call.err
+}
+
+func (s *crawlerService) resumeCrawl(cp Checkpoint, fingerprint string) ([]Metadata, error) {
+	crawler, err := s.crawlerFactory.NewCrawlerFromState(cp.State, s.newInstanceConfig(cp.ID, cp.Options))
+	if err != nil {
+		return []Metadata{}, err
+	}
+
+	s.logger.Sugar().Infof(
+		"resuming crawl with %v visited and %v pending link(s)",
+		len(cp.State.Visited),
+		len(cp.State.Pending),
+	)
+	return s.runCrawl(
+		Metadata{
+			ID:          cp.ID,
+			InitialURL:  cp.InitialURL,
+			Host:        cp.Host,
+			Options:     cp.Options.forStorage(),
+			Fingerprint: fingerprint,
+		},
+		cp.Options,
+		crawler,
+		nil,
+	)
+}
+
+// Executes the crawl while periodically checkpointing its progress, then
+// saves the results in the mem store
+func (s *crawlerService) runCrawl(
+	crawlRec Metadata,
+	opts CrawlOptions,
+	crawler instance.CrawlerIManager,
+	job *crawlJob,
+) (crawls []Metadata, err error) {
+	// webhooks are told about every crawl that ran, whatever the outcome
+	startedAt := s.now()
+	defer func() {
+		s.notifyCrawl(crawlRec, startedAt, err)
+	}()
+
+	// attaching the crawler lets the job report progress and stop the crawl
+	if job != nil && !job.attach(crawlRec.ID, crawler) {
+		return []Metadata{}, ErrSvcCrawlCancelled
+	}
+
+	s.inflightMu.Lock()
+	s.running[crawlRec.ID] = struct{}{}
+	s.inflightMu.Unlock()
+	defer func() {
+		s.inflightMu.Lock()
+		delete(s.running, crawlRec.ID)
+		s.inflightMu.Unlock()
+	}()
+
+	s.logger.Sugar().Infof("beginning web crawl %v, this may take some time", crawlRec.ID)
 	// execute the crawl
+	stop := s.startCheckpoints(crawlRec, opts, crawler)
 	crawler.Process()
+	stop()
+
+	// a cancelled crawl is not cached, its final checkpoint allows it to be
+	// resumed later on
+	if job != nil && job.isCancelled() {
+		s.saveCheckpoint(crawlRec, opts, crawler)
+		s.discardPages(crawlRec.ID)
+		s.logger.Sugar().Infof("crawl %v cancelled", crawlRec.ID)
+		return []Metadata{crawlRec}, ErrSvcCrawlCancelled
+	}
 
 	// Populating the metadata object
 	errList := crawler.GetErrors()
@@ -98,27 +469,145 @@ func (s *crawlerService) CrawlSite(crawlRec Metadata) ([]Metadata, error) {
 
 	validLinks := crawler.GetLinks()
 	crawlRec.CrawlResultSet = validLinks
+	crawlRec.Pages = crawler.GetPages()
 
 	s.logger.Sugar().Info("crawl complete, caching results")
-	err = s.crawlerRepo.Save(&crawlRec)
+	// with a page store the page records are only kept there, the repository
+	// holds the rest of the crawl
+	stored := crawlRec
+	if s.pageStore != nil {
+		stored.Pages = nil
+	}
+	err = s.crawlerRepo.Save(context.Background(), &stored)
+	crawlRec.CreatedAt = stored.CreatedAt
+	if err != nil {
+		s.discardPages(crawlRec.ID)
+	}
 	if err != nil && errors.Is(err, ErrUniqueKeyViolated) {
 		return []Metadata{crawlRec}, ErrSvcRecordExists
 	} else if err != nil {
 		return []Metadata{crawlRec}, err
 	}
 
+	// the checkpoint is only needed until the results are persisted
+	if s.checkpointRepo != nil {
+		if err = s.checkpointRepo.Delete(crawlRec.ID); err != nil {
+			s.logger.Sugar().Warnf("unable to remove checkpoint: %v", err.Error())
+		}
+	}
+
 	return []Metadata{crawlRec}, nil
 }
 
+// Writes checkpoints on an interval until the returned function is called,
+// which blocks until the last write has finished
+func (s *crawlerService) startCheckpoints(
+	crawlRec Metadata,
+	opts CrawlOptions,
+	crawler instance.CrawlerIManager,
+) func() {
+	if s.checkpointRepo == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(checkpointInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.saveCheckpoint(crawlRec, opts, crawler)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// Persists the current state of the crawler, failures are only logged since
+// the crawl itself can carry on without a checkpoint
+func (s *crawlerService) saveCheckpoint(
+	crawlRec Metadata,
+	opts CrawlOptions,
+	crawler instance.CrawlerIManager,
+) {
+	if s.checkpointRepo == nil {
+		return
+	}
+
+	cp := Checkpoint{
+		ID:         crawlRec.ID,
+		InitialURL: crawlRec.InitialURL,
+		Host:       crawlRec.Host,
+		Options:    opts,
+		State:      crawler.Snapshot(),
+	}
+	if err := s.checkpointRepo.Save(&cp); err != nil {
+		s.logger.Sugar().Warnf("unable to checkpoint crawl: %v", err.Error())
+	}
+}
+
+// This method returns the most recent crawl of the same start URL and options
+// if it is still within the cache TTL, the slice is empty when a new crawl is
+// needed
+func (s *crawlerService) GetCachedCrawl(crawlRec Metadata, opts CrawlOptions) ([]Metadata, error) {
+	host, err := util.GetHost(crawlRec.InitialURL)
+	if err != nil {
+		return []Metadata{}, err
+	}
+	crawlRec.Host = host
+	s.logger.Sugar().Info("valid host")
+
+	if opts.ForceRefresh || s.cfg.CacheTTL <= 0 {
+		return []Metadata{}, nil
+	}
+
+	opts = s.resolveOptions(opts)
+	fingerprint, err := Fingerprint(crawlRec.InitialURL, opts)
+	if err != nil {
+		return []Metadata{}, err
+	}
+
+	// crawls of the host are sorted most recent first, so the first crawl with
+	// a matching fingerprint is the only candidate
+	prevCrawls, err := s.crawlerRepo.GetCrawlsByHost(context.Background(), crawlRec.Host)
+	if err != nil {
+		return []Metadata{}, err
+	}
+	for _, prevCrawl := range prevCrawls {
+		if crawlFingerprint(prevCrawl) != fingerprint {
+			continue
+		}
+		if !inTimeSpan(prevCrawl.CreatedAt, s.now().UTC(), s.cfg.CacheTTL) {
+			break
+		}
+
+		s.logger.Sugar().Infof(
+			"previous results exist for host - %v",
+			crawlRec.Host,
+		)
+		return []Metadata{prevCrawl}, nil
+	}
+
+	return []Metadata{}, nil
+}
+
 // This method retrieves an existing crawl proviuded ID
 func (s *crawlerService) GetCrawl(id string) ([]Metadata, error) {
-	c := Metadata{ID: id}
-
-	crawlRec, err := s.crawlerRepo.GetCrawlByID(&c)
+	crawlRec, err := s.crawlerRepo.GetCrawlByID(context.Background(), id)
 	if err != nil && errors.Is(err, ErrRecordNotFound) {
-		return []Metadata{crawlRec}, ErrSvcRecordNotFound
+		return []Metadata{{ID: id}}, ErrSvcRecordNotFound
 	} else if err != nil {
-		return []Metadata{crawlRec}, err
+		return []Metadata{{ID: id}}, err
 	}
 	s.logger.Sugar().Info("crawl found")
 	return []Metadata{crawlRec}, nil
@@ -126,14 +615,102 @@ func (s *crawlerService) GetCrawl(id string) ([]Metadata, error) {
 
 // This method retrieves all existing crawls being persisted
 func (s *crawlerService) GetCrawlHistory() ([]Metadata, error) {
-	return s.crawlerRepo.GetCrawlHistory()
+	return s.crawlerRepo.GetCrawlHistory(context.Background())
+}
+
+// Compares two stored crawls, the first ID is treated as the earlier crawl
+func (s *crawlerService) DiffCrawls(idA, idB string) (CrawlDiff, error) {
+	crawls := make([]Metadata, 0, 2)
+	for _, id := range []string{idA, idB} {
+		crawlRec, err := s.crawlerRepo.GetCrawlByID(context.Background(), id)
+		if err != nil && errors.Is(err, ErrRecordNotFound) {
+			return CrawlDiff{}, errors.Wrapf(ErrSvcRecordNotFound, "crawl %v", id)
+		} else if err != nil {
+			return CrawlDiff{}, err
+		}
+		if err = s.loadPages(&crawlRec); err != nil {
+			return CrawlDiff{}, err
+		}
+		crawls = append(crawls, crawlRec)
+	}
+
+	return DiffCrawls(crawls[0], crawls[1]), nil
+}
+
+// Stops the background work of the service, recurring crawls and retention
+// pruning, then waits a bounded time for webhook deliveries in progress.
+// Crawls in progress are left to finish
+func (s *crawlerService) Close() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+
+	// once unscheduled no new run starts, the runs in progress are waited on
+	// so the stores they write to can be closed after the service
+	for _, rec := range s.ListSchedules() {
+		_ = s.Unschedule(rec.ID)
+	}
+	s.scheduledRuns.Wait()
+
+	drainTimeout := s.cfg.WebhookDrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = defaultWebhookDrainTimeout
+	}
+	s.notifier.close(drainTimeout)
 }
 
 // HELPERS ----------------------------------------------------------------
-func inTimeSpan(check time.Time) bool {
-	end := time.Now().UTC()
-	start := end.Add(time.Duration(-24) * time.Hour)
+// Resolves the options of a request against the service defaults and then the
+// crawler defaults, so the stored options record exactly how the crawl ran.
+// Explicit NoLimit and NoScope settings win over the defaults
+func (s *crawlerService) resolveOptions(opts CrawlOptions) CrawlOptions {
+	opts = opts.withDefaults(s.cfg.DefaultOptions)
+	return opts.withDefaults(CrawlOptions{
+		Workers: util.SetupDefaultConcurrency().TotalWorkers,
+		Timeout: util.NewDefaultHTTPClient().Timeout,
+	}).withoutSentinels()
+}
+
+// Builds the crawler config for a single crawl, the options override the
+// default worker count and HTTP timeout
+func (s *crawlerService) newInstanceConfig(id string, opts CrawlOptions) instance.Config {
+	workers := util.SetupDefaultConcurrency()
+	if opts.Workers > 0 {
+		workers = util.SetupConcurrency(opts.Workers)
+	}
+	client := util.NewDefaultHTTPClient()
+	if opts.Timeout > 0 {
+		client = util.NewHTTPClient(opts.Timeout)
+	}
+
+	return instance.Config{
+		WokerSetting: workers,
+		HttpClient:   client,
+		Scope:        opts.Scope,
+		MaxDepth:     opts.MaxDepth,
+		MaxPages:     opts.MaxPages,
+		FetchLimiter: s.fetchLimiter,
+		OnPage:       s.pageWriter(id),
+	}
+}
+
+// Crawls saved before fingerprints were recorded always covered the whole
+// site with no limits, which is the fingerprint of the zero value options
+func crawlFingerprint(crawlRec Metadata) string {
+	if crawlRec.Fingerprint != "" {
+		return crawlRec.Fingerprint
+	}
+
+	fingerprint, err := Fingerprint(crawlRec.InitialURL, CrawlOptions{})
+	if err != nil {
+		return ""
+	}
+	return fingerprint
+}
+
+func inTimeSpan(check, end time.Time, ttl time.Duration) bool {
+	start := end.Add(-ttl)
 
-	// start is always 24 hours prior to end
+	// start is always one TTL prior to end
 	return !check.Before(start) && !check.After(end)
 }
@@ -1,10 +1,22 @@
 package crawler_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/sjain93/web-crawler-go/config"
 	"github.com/sjain93/web-crawler-go/src/crawler"
+	"github.com/sjain93/web-crawler-go/src/crawler/instance"
+	"github.com/sjain93/web-crawler-go/src/crawler/instance/instancetest"
+	"github.com/sjain93/web-crawler-go/src/util"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 )
@@ -13,17 +25,47 @@ func TestCrawlSiteWithCache(t *testing.T) {
 	logger, err := zap.NewProduction()
 	assert.NoError(t, err)
 
-	inMemDB := config.GetInMemoryStore()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<a href="/about">about</a>`)
+	}))
+	defer server.Close()
+
+	// the same server is reachable under two hosts so that the cached and
+	// refreshed cases don't depend on the order they run in
+	localURL := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+	inMemDB := config.GetInMemoryStore[crawler.Metadata]()
 	setupMockData(inMemDB)
+	preLoad(
+		inMemDB,
+		crawler.Metadata{
+			ID:         "2f6c2d0e-3c4e-4c8e-9f61-3a4f0f0b7d55",
+			InitialURL: server.URL,
+			Host:       "127.0.0.1",
+			CreatedAt:  time.Now().UTC().Add(-2 * time.Hour),
+		},
+		crawler.Metadata{
+			ID:         "7b0e9d0c-8f1e-4a55-a5a2-5d1c1f3e8b21",
+			InitialURL: localURL,
+			Host:       "localhost",
+			CreatedAt:  time.Now().UTC().Add(-2 * time.Hour),
+		},
+	)
 
 	crawlerRepo, err := crawler.NewCrawlerRepository(inMemDB)
 	assert.NoError(t, err)
 
-	crawlerSvc := crawler.NewCrawlerService(crawlerRepo, logger)
+	crawlerSvc, err := crawler.NewCrawlerService(
+		crawler.WithRepository(crawlerRepo),
+		crawler.WithLogger(logger),
+	)
+	assert.NoError(t, err)
 
 	testCases := map[string]struct {
 		initialURL        string
+		opts              crawler.CrawlOptions
 		expectedID        string
+		staleID           string
 		expectedResultLen int
 	}{
 		"Crawl Site succesfully checks and returns a valid past crawl": {
@@ -31,14 +73,618 @@ func TestCrawlSiteWithCache(t *testing.T) {
 			expectedID:        "5eb020a4-54cc-4b57-b19f-cbd33a2df881",
 			expectedResultLen: 1,
 		},
+		"Crawl Site reuses a crawl within the TTL": {
+			initialURL:        server.URL,
+			expectedID:        "2f6c2d0e-3c4e-4c8e-9f61-3a4f0f0b7d55",
+			expectedResultLen: 1,
+		},
+		"Crawl Site matches on the normalized URL": {
+			initialURL:        server.URL + "/#mainContent",
+			expectedID:        "2f6c2d0e-3c4e-4c8e-9f61-3a4f0f0b7d55",
+			expectedResultLen: 1,
+		},
+		"Different crawl options miss the cache": {
+			initialURL:        server.URL,
+			opts:              crawler.CrawlOptions{Scope: "/about"},
+			staleID:           "2f6c2d0e-3c4e-4c8e-9f61-3a4f0f0b7d55",
+			expectedResultLen: 1,
+		},
+		"Force refresh skips the cache": {
+			initialURL:        localURL,
+			opts:              crawler.CrawlOptions{ForceRefresh: true},
+			staleID:           "7b0e9d0c-8f1e-4a55-a5a2-5d1c1f3e8b21",
+			expectedResultLen: 1,
+		},
 	}
 
 	for name, tc := range testCases {
 		t.Run(name, func(t *testing.T) {
-			output, err := crawlerSvc.CrawlSite(crawler.Metadata{InitialURL: tc.initialURL})
+			output, err := crawlerSvc.CrawlSite(crawler.Metadata{InitialURL: tc.initialURL}, tc.opts)
 			assert.NoError(t, err)
 			assert.Len(t, output, tc.expectedResultLen)
-			assert.Equal(t, tc.expectedID, output[0].ID)
+			if tc.expectedID != "" {
+				assert.Equal(t, tc.expectedID, output[0].ID)
+			} else {
+				assert.NotEqual(t, tc.staleID, output[0].ID)
+				assert.Contains(t, output[0].CrawlResultSet, tc.initialURL+"/about")
+			}
+		})
+	}
+}
+
+func TestCrawlJobs(t *testing.T) {
+	logger, err := zap.NewProduction()
+	assert.NoError(t, err)
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		fmt.Fprint(w, `<a href="/slow">slow</a>`)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+	assert.NoError(t, err)
+
+	crawlerSvc, err := crawler.NewCrawlerService(
+		crawler.WithRepository(crawlerRepo),
+		crawler.WithLogger(logger),
+	)
+	assert.NoError(t, err)
+
+	t.Run("Cancel a running job", func(t *testing.T) {
+		jobID, err := crawlerSvc.StartCrawl(crawler.Metadata{InitialURL: server.URL}, crawler.CrawlOptions{})
+		assert.NoError(t, err)
+
+		assert.Eventually(t, func() bool {
+			job, err := crawlerSvc.GetJob(jobID)
+			return err == nil && job.Status == crawler.JobRunning && job.Progress.Visited == 1
+		}, time.Second, 5*time.Millisecond)
+
+		assert.NoError(t, crawlerSvc.CancelJob(jobID))
+		assert.Eventually(t, func() bool {
+			job, err := crawlerSvc.GetJob(jobID)
+			return err == nil && job.Status == crawler.JobCancelled
+		}, time.Second, 5*time.Millisecond)
+
+		assert.Equal(t, crawler.ErrSvcJobFinished, crawlerSvc.CancelJob(jobID))
+	})
+
+	t.Run("Completed job references the stored crawl", func(t *testing.T) {
+		opts := crawler.CrawlOptions{Scope: "/about"}
+		jobID, err := crawlerSvc.StartCrawl(crawler.Metadata{InitialURL: server.URL}, opts)
+		assert.NoError(t, err)
+
+		var job crawler.Job
+		assert.Eventually(t, func() bool {
+			job, err = crawlerSvc.GetJob(jobID)
+			return err == nil && job.Status == crawler.JobCompleted
+		}, time.Second, 5*time.Millisecond)
+
+		crawls, err := crawlerSvc.GetCrawl(job.CrawlID)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{server.URL}, crawls[0].CrawlResultSet)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		_, err := crawlerSvc.StartCrawl(crawler.Metadata{InitialURL: "ww.monzo.com"}, crawler.CrawlOptions{})
+		assert.Error(t, err)
+
+		_, err = crawlerSvc.GetJob(uuid.NewString())
+		assert.Equal(t, crawler.ErrSvcJobNotFound, err)
+		assert.Equal(t, crawler.ErrSvcJobNotFound, crawlerSvc.CancelJob(uuid.NewString()))
+	})
+
+	assert.Len(t, crawlerSvc.ListJobs(), 2)
+}
+
+func TestCrawlJobQueue(t *testing.T) {
+	logger, err := zap.NewProduction()
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// every page blocks until its crawl is cancelled
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+	assert.NoError(t, err)
+
+	cfg := crawler.NewDefaultServiceConfig()
+	cfg.MaxConcurrentCrawls = 2
+	crawlerSvc, err := crawler.NewCrawlerService(
+		crawler.WithRepository(crawlerRepo),
+		crawler.WithLogger(logger),
+		crawler.WithConfig(*cfg),
+	)
+	assert.NoError(t, err)
+
+	start := func(path string, priority int) string {
+		jobID, err := crawlerSvc.StartCrawl(
+			crawler.Metadata{InitialURL: server.URL + path},
+			crawler.CrawlOptions{Priority: priority},
+		)
+		assert.NoError(t, err)
+		return jobID
+	}
+	status := func(jobID string) crawler.JobStatus {
+		job, err := crawlerSvc.GetJob(jobID)
+		assert.NoError(t, err)
+		return job.Status
+	}
+
+	first, second := start("/1", 0), start("/2", 0)
+	assert.Eventually(t, func() bool {
+		return status(first) == crawler.JobRunning && status(second) == crawler.JobRunning
+	}, time.Second, 5*time.Millisecond)
+
+	low, high := start("/3", 0), start("/4", 10)
+	assert.Equal(t, crawler.JobQueued, status(low))
+	assert.Equal(t, crawler.JobQueued, status(high))
+
+	// the freed slot goes to the higher priority job
+	assert.NoError(t, crawlerSvc.CancelJob(first))
+	assert.Eventually(t, func() bool {
+		return status(high) == crawler.JobRunning
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, crawler.JobQueued, status(low))
+
+	// a queued job can be cancelled before it starts
+	assert.NoError(t, crawlerSvc.CancelJob(low))
+	assert.Equal(t, crawler.JobCancelled, status(low))
+
+	assert.NoError(t, crawlerSvc.CancelJob(second))
+	assert.NoError(t, crawlerSvc.CancelJob(high))
+	assert.Eventually(t, func() bool {
+		return status(second) == crawler.JobCancelled && status(high) == crawler.JobCancelled
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestCrawlDeduplication(t *testing.T) {
+	logger, err := zap.NewProduction()
+	assert.NoError(t, err)
+
+	var mu sync.Mutex
+	hits := 0
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits++
+		mu.Unlock()
+		<-release
+	}))
+	defer server.Close()
+
+	crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+	assert.NoError(t, err)
+
+	crawlerSvc, err := crawler.NewCrawlerService(
+		crawler.WithRepository(crawlerRepo),
+		crawler.WithLogger(logger),
+	)
+	assert.NoError(t, err)
+
+	t.Run("Concurrent callers share a single crawl", func(t *testing.T) {
+		results := make([][]crawler.Metadata, 3)
+		var wg sync.WaitGroup
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				crawls, err := crawlerSvc.CrawlSite(
+					crawler.Metadata{InitialURL: server.URL + "/dedupe"},
+					crawler.CrawlOptions{ForceRefresh: true},
+				)
+				assert.NoError(t, err)
+				results[i] = crawls
+			}(i)
+		}
+
+		assert.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return hits == 1
+		}, time.Second, 5*time.Millisecond)
+		// give the other callers time to join before the crawl finishes
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		assert.Equal(t, 1, hits)
+		for _, crawls := range results {
+			assert.Equal(t, results[0][0].ID, crawls[0].ID)
+		}
+	})
+
+	t.Run("Background jobs for the same target are reused", func(t *testing.T) {
+		crawlRec := crawler.Metadata{InitialURL: server.URL + "/jobs"}
+		first, err := crawlerSvc.StartCrawl(crawlRec, crawler.CrawlOptions{})
+		assert.NoError(t, err)
+		second, err := crawlerSvc.StartCrawl(crawlRec, crawler.CrawlOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, first, second)
+
+		other, err := crawlerSvc.StartCrawl(crawlRec, crawler.CrawlOptions{MaxDepth: 1})
+		assert.NoError(t, err)
+		assert.NotEqual(t, first, other)
+	})
+}
+
+func TestNewCrawlerService(t *testing.T) {
+	inMemDB := config.GetInMemoryStore[crawler.Metadata]()
+	setupMockData(inMemDB)
+	crawlerRepo, err := crawler.NewCrawlerRepository(inMemDB)
+	assert.NoError(t, err)
+
+	emptyRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+	assert.NoError(t, err)
+
+	testCases := map[string]struct {
+		opts        []crawler.ServiceOption
+		expectedErr error
+		expectedLen int
+	}{
+		"Missing repository": {
+			opts:        []crawler.ServiceOption{},
+			expectedErr: crawler.ErrSvcNoRepository,
+		},
+		"Independent instance - mock data": {
+			opts:        []crawler.ServiceOption{crawler.WithRepository(crawlerRepo)},
+			expectedLen: 1,
+		},
+		"Independent instance - empty repository": {
+			opts:        []crawler.ServiceOption{crawler.WithRepository(emptyRepo)},
+			expectedLen: 0,
+		},
+		"Clock moved past the cache TTL": {
+			opts: []crawler.ServiceOption{
+				crawler.WithRepository(crawlerRepo),
+				crawler.WithClock(func() time.Time {
+					return time.Now().Add(crawler.DefaultCacheTTL + time.Hour)
+				}),
+			},
+			expectedLen: 0,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			crawlerSvc, err := crawler.NewCrawlerService(tc.opts...)
+			if tc.expectedErr != nil {
+				assert.Equal(t, tc.expectedErr, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			cached, err := crawlerSvc.GetCachedCrawl(
+				crawler.Metadata{InitialURL: "https://monzo.com/"},
+				crawler.CrawlOptions{},
+			)
+			assert.NoError(t, err)
+			assert.Len(t, cached, tc.expectedLen)
+		})
+	}
+}
+
+func TestResumeCrawl(t *testing.T) {
+	var mu sync.Mutex
+	hits := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		fmt.Fprint(w, `<a href="/">home</a><a href="/pending">pending</a>`)
+	}))
+	defer server.Close()
+
+	checkpointRepo, err := crawler.NewCheckpointRepository(t.TempDir())
+	assert.NoError(t, err)
+	assert.NoError(t, checkpointRepo.Save(&crawler.Checkpoint{
+		ID:         "0d6b6b8e-7f0a-4b7a-9e59-5c3f3f6f2a10",
+		InitialURL: server.URL + "/",
+		Host:       "127.0.0.1",
+		State: instance.State{
+			InitialURL: server.URL + "/",
+			Visited:    []string{server.URL + "/"},
+			Pending:    []instance.Link{{URL: server.URL + "/pending", Depth: 1}},
+		},
+	}))
+
+	crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+	assert.NoError(t, err)
+
+	testCases := map[string]struct {
+		opts        []crawler.ServiceOption
+		id          string
+		expectedErr error
+	}{
+		"Resumes from the checkpoint": {
+			opts: []crawler.ServiceOption{
+				crawler.WithRepository(crawlerRepo),
+				crawler.WithCheckpoints(checkpointRepo),
+			},
+			id: "0d6b6b8e-7f0a-4b7a-9e59-5c3f3f6f2a10",
+		},
+		"Missing checkpoint": {
+			opts: []crawler.ServiceOption{
+				crawler.WithRepository(crawlerRepo),
+				crawler.WithCheckpoints(checkpointRepo),
+			},
+			id:          uuid.NewString(),
+			expectedErr: crawler.ErrSvcNoCheckpoint,
+		},
+		"Checkpoints not configured": {
+			opts:        []crawler.ServiceOption{crawler.WithRepository(crawlerRepo)},
+			id:          "0d6b6b8e-7f0a-4b7a-9e59-5c3f3f6f2a10",
+			expectedErr: crawler.ErrSvcNoCheckpoints,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			crawlerSvc, err := crawler.NewCrawlerService(tc.opts...)
+			assert.NoError(t, err)
+
+			crawls, err := crawlerSvc.ResumeCrawl(tc.id)
+			if tc.expectedErr != nil {
+				assert.Equal(t, tc.expectedErr, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.id, crawls[0].ID)
+			assert.ElementsMatch(
+				t,
+				[]string{server.URL + "/", server.URL + "/pending"},
+				crawls[0].CrawlResultSet,
+			)
+			assert.Zero(t, hits["/"])
+
+			_, err = checkpointRepo.Get(tc.id)
+			assert.Equal(t, crawler.ErrCheckpointNotFound, err)
+		})
+	}
+}
+
+func TestResumeRunningCrawl(t *testing.T) {
+	id := "0d6b6b8e-7f0a-4b7a-9e59-5c3f3f6f2a10"
+	checkpointRepo, err := crawler.NewCheckpointRepository(t.TempDir())
+	assert.NoError(t, err)
+	assert.NoError(t, checkpointRepo.Save(&crawler.Checkpoint{
+		ID:         id,
+		InitialURL: "https://monzo.com/",
+		Host:       "monzo.com",
+		State:      instance.State{InitialURL: "https://monzo.com/"},
+	}))
+
+	crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+	assert.NoError(t, err)
+	factory := &instancetest.Factory{Block: make(chan struct{})}
+	crawlerSvc, err := crawler.NewCrawlerService(
+		crawler.WithRepository(crawlerRepo),
+		crawler.WithCheckpoints(checkpointRepo),
+		crawler.WithCrawlerFactory(factory),
+	)
+	assert.NoError(t, err)
+
+	resumed := make(chan error, 1)
+	go func() {
+		_, err := crawlerSvc.ResumeCrawl(id)
+		resumed <- err
+	}()
+
+	// once the crawl runs its checkpoint is no longer offered for resuming
+	assert.Eventually(t, func() bool {
+		checkpoints, err := crawlerSvc.ListCheckpoints()
+		return err == nil && len(checkpoints) == 0
+	}, time.Second, 5*time.Millisecond)
+
+	_, err = crawlerSvc.ResumeCrawl(id)
+	assert.Equal(t, crawler.ErrSvcCrawlRunning, err)
+
+	close(factory.Block)
+	assert.NoError(t, <-resumed)
+	assert.Len(t, factory.Crawlers(), 1)
+}
+
+// Repository that fails every save with the provided error
+type failingSaveRepo struct {
+	crawler.CrawlerRepoManager
+	err error
+}
+
+func (r *failingSaveRepo) Save(ctx context.Context, crawlRec *crawler.Metadata) error {
+	return r.err
+}
+
+func TestCrawlSite(t *testing.T) {
+	newRepo := func() crawler.CrawlerRepoManager {
+		crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+		assert.NoError(t, err)
+		return crawlerRepo
+	}
+
+	testCases := map[string]struct {
+		initialURL    string
+		opts          crawler.CrawlOptions
+		repo          crawler.CrawlerRepoManager
+		factory       *instancetest.Factory
+		expectedErr   error
+		expectedLinks []string
+		expectedSaved bool
+	}{
+		"New crawl is saved": {
+			initialURL: "https://monzo.com/",
+			repo:       newRepo(),
+			factory: &instancetest.Factory{
+				Links: []string{"https://monzo.com/", "https://monzo.com/isa/"},
+			},
+			expectedLinks: []string{"https://monzo.com/", "https://monzo.com/isa/"},
+			expectedSaved: true,
+		},
+		"Crawl errors are recorded": {
+			initialURL: "https://monzo.com/",
+			repo:       newRepo(),
+			factory: &instancetest.Factory{
+				Errors: []error{errors.New("error fetching page: https://monzo.com/isa/")},
+			},
+			expectedLinks: []string{"https://monzo.com/"},
+			expectedSaved: true,
+		},
+		"Invalid URL": {
+			initialURL:  "ww.monzo.com",
+			repo:        newRepo(),
+			factory:     &instancetest.Factory{},
+			expectedErr: util.ErrUtilInvalidHost,
+		},
+		"Crawler cannot be built": {
+			initialURL:  "https://monzo.com/",
+			repo:        newRepo(),
+			factory:     &instancetest.Factory{Err: errors.New("crawler has invalid or missing config")},
+			expectedErr: errors.New("crawler has invalid or missing config"),
+		},
+		"Save conflict": {
+			initialURL:  "https://monzo.com/",
+			repo:        &failingSaveRepo{CrawlerRepoManager: newRepo(), err: crawler.ErrUniqueKeyViolated},
+			factory:     &instancetest.Factory{},
+			expectedErr: crawler.ErrSvcRecordExists,
+		},
+		"Save failure": {
+			initialURL:  "https://monzo.com/",
+			repo:        &failingSaveRepo{CrawlerRepoManager: newRepo(), err: crawler.ErrInvalidDataType},
+			factory:     &instancetest.Factory{},
+			expectedErr: crawler.ErrInvalidDataType,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			crawlerSvc, err := crawler.NewCrawlerService(
+				crawler.WithRepository(tc.repo),
+				crawler.WithCrawlerFactory(tc.factory),
+			)
+			assert.NoError(t, err)
+
+			crawls, err := crawlerSvc.CrawlSite(crawler.Metadata{InitialURL: tc.initialURL}, tc.opts)
+			if tc.expectedErr != nil {
+				assert.Equal(t, tc.expectedErr.Error(), err.Error())
+				return
+			}
+			assert.NoError(t, err)
+			assert.Len(t, crawls, 1)
+			assert.Equal(t, tc.expectedLinks, crawls[0].CrawlResultSet)
+			assert.Equal(t, tc.factory.Errors, crawls[0].ErrList)
+
+			stored, err := crawlerSvc.GetCrawl(crawls[0].ID)
+			assert.NoError(t, err)
+			assert.Equal(t, crawls[0].ID, stored[0].ID)
+		})
+	}
+}
+
+func TestCrawlSiteOptions(t *testing.T) {
+	defaultWorkers := util.SetupDefaultConcurrency().TotalWorkers
+	defaultTimeout := util.NewDefaultHTTPClient().Timeout
+
+	testCases := map[string]struct {
+		defaults        crawler.CrawlOptions
+		opts            crawler.CrawlOptions
+		expectedOptions crawler.CrawlOptions
+	}{
+		"Crawler defaults": {
+			opts: crawler.CrawlOptions{},
+			expectedOptions: crawler.CrawlOptions{
+				Workers: defaultWorkers,
+				Timeout: defaultTimeout,
+			},
+		},
+		"Request overrides": {
+			opts: crawler.CrawlOptions{
+				ForceRefresh: true,
+				Priority:     5,
+				Workers:      10,
+				Timeout:      5 * time.Second,
+				Scope:        "/blog/",
+				MaxDepth:     2,
+				MaxPages:     100,
+			},
+			expectedOptions: crawler.CrawlOptions{
+				Workers:  10,
+				Timeout:  5 * time.Second,
+				Scope:    "/blog/",
+				MaxDepth: 2,
+				MaxPages: 100,
+			},
+		},
+		"Service defaults fill unset options": {
+			defaults: crawler.CrawlOptionsFromConfig(config.CrawlDefaults{
+				Workers:  50,
+				MaxDepth: 3,
+				MaxPages: 1000,
+			}),
+			opts: crawler.CrawlOptions{MaxPages: 10},
+			expectedOptions: crawler.CrawlOptions{
+				Workers:  50,
+				Timeout:  defaultTimeout,
+				MaxDepth: 3,
+				MaxPages: 10,
+			},
+		},
+		"Request clears service defaults": {
+			defaults: crawler.CrawlOptionsFromConfig(config.CrawlDefaults{
+				Scope:    "/blog/",
+				MaxDepth: 3,
+				MaxPages: 1000,
+			}),
+			opts: crawler.CrawlOptions{
+				Scope:    crawler.NoScope,
+				MaxDepth: crawler.NoLimit,
+				MaxPages: crawler.NoLimit,
+			},
+			expectedOptions: crawler.CrawlOptions{
+				Workers: defaultWorkers,
+				Timeout: defaultTimeout,
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+			assert.NoError(t, err)
+
+			cfg := crawler.NewDefaultServiceConfig()
+			cfg.DefaultOptions = tc.defaults
+			factory := &instancetest.Factory{}
+			crawlerSvc, err := crawler.NewCrawlerService(
+				crawler.WithRepository(crawlerRepo),
+				crawler.WithCrawlerFactory(factory),
+				crawler.WithConfig(*cfg),
+			)
+			assert.NoError(t, err)
+
+			crawls, err := crawlerSvc.CrawlSite(crawler.Metadata{InitialURL: "https://monzo.com/"}, tc.opts)
+			assert.NoError(t, err)
+
+			// the stored record holds the options the crawl actually ran with
+			stored, err := crawlerSvc.GetCrawl(crawls[0].ID)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedOptions, stored[0].Options)
+
+			configs := factory.Configs()
+			assert.Len(t, configs, 1)
+			assert.Equal(t, tc.expectedOptions.Workers, configs[0].WokerSetting.TotalWorkers)
+			assert.Equal(t, tc.expectedOptions.Timeout, configs[0].HttpClient.Timeout)
+			assert.Equal(t, tc.expectedOptions.Scope, configs[0].Scope)
+			assert.Equal(t, tc.expectedOptions.MaxDepth, configs[0].MaxDepth)
+			assert.Equal(t, tc.expectedOptions.MaxPages, configs[0].MaxPages)
 		})
 	}
 }
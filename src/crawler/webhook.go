@@ -0,0 +1,370 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/sjain93/web-crawler-go/src/util"
+)
+
+var errNotifierClosed = errors.New("webhook notifier is closed")
+
+const (
+	// default number of times a notification is sent before giving up
+	defaultWebhookAttempts = 5
+	// default wait before the first retry, doubled after every failed attempt
+	defaultWebhookBackoff = time.Second
+	// how long a single delivery attempt may take
+	webhookTimeout = 10 * time.Second
+	// default time Close waits for deliveries in progress
+	defaultWebhookDrainTimeout = 30 * time.Second
+	// number of crawls whose deliveries are kept, the oldest are dropped
+	maxWebhookDeliveryCrawls = 1000
+
+	// request headers identifying and authenticating a notification
+	WebhookSignatureHeader = "X-Crawler-Signature"
+	WebhookEventHeader     = "X-Crawler-Event"
+	WebhookDeliveryHeader  = "X-Crawler-Delivery"
+)
+
+type WebhookEvent string
+
+const (
+	EventCrawlCompleted WebhookEvent = "crawl.completed"
+	EventCrawlFailed    WebhookEvent = "crawl.failed"
+)
+
+// A URL notified whenever a crawl finishes. Requests are signed with Secret,
+// zero attempts or backoff fall back to the defaults
+type WebhookConfig struct {
+	URL         string
+	Secret      string
+	MaxAttempts int
+	Backoff     time.Duration
+}
+
+// The JSON body posted to every webhook
+type CrawlNotification struct {
+	Event      WebhookEvent
+	CrawlID    string
+	InitialURL string
+	Host       string
+	PageCount  int
+	ErrorCount int
+	StartedAt  time.Time
+	// how long the crawl ran, in milliseconds
+	DurationMs int64
+	// set when the crawl failed
+	Error string
+	// changes since the previous crawl of the same target, nil if there is none
+	Diff *CrawlDiff
+}
+
+// Every attempt made to send a notification to one webhook
+type WebhookDelivery struct {
+	ID        string
+	CrawlID   string
+	URL       string
+	Event     WebhookEvent
+	Delivered bool
+	Attempts  []DeliveryAttempt
+}
+
+type DeliveryAttempt struct {
+	At time.Time
+	// zero when no response was received
+	StatusCode int
+	Error      string
+}
+
+// Sends notifications in the background and records their deliveries
+type webhookNotifier struct {
+	hooks  []WebhookConfig
+	client *http.Client
+	now    func() time.Time
+	// deliveries in progress, and the context cancelling the ones still
+	// running once close gives up on them
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// deliveries keyed by crawl ID, and the crawl IDs in the order their
+	// first delivery started so the oldest can be dropped
+	mu         sync.Mutex
+	deliveries map[string][]*WebhookDelivery
+	crawls     []string
+	closed     bool
+}
+
+func newWebhookNotifier(hooks []WebhookConfig, now func() time.Time) *webhookNotifier {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &webhookNotifier{
+		hooks:      hooks,
+		client:     util.NewHTTPClient(webhookTimeout),
+		now:        now,
+		ctx:        ctx,
+		cancel:     cancel,
+		deliveries: map[string][]*WebhookDelivery{},
+	}
+}
+
+// Waits up to the timeout for the deliveries in progress to finish, their
+// remaining attempts are abandoned after that. Notifications are refused once
+// closed
+func (n *webhookNotifier) close(timeout time.Duration) {
+	n.mu.Lock()
+	n.closed = true
+	n.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		n.cancel()
+		<-done
+	}
+	n.cancel()
+}
+
+// Starts a delivery of the notification to every webhook, without waiting
+// for any of them to finish
+func (n *webhookNotifier) notify(notification CrawlNotification) error {
+	if len(n.hooks) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range n.hooks {
+		delivery := &WebhookDelivery{
+			ID:       uuid.NewString(),
+			CrawlID:  notification.CrawlID,
+			URL:      hook.URL,
+			Event:    notification.Event,
+			Attempts: []DeliveryAttempt{},
+		}
+		n.mu.Lock()
+		if n.closed {
+			n.mu.Unlock()
+			return errNotifierClosed
+		}
+		n.record(delivery)
+		n.wg.Add(1)
+		n.mu.Unlock()
+
+		go func(hook WebhookConfig) {
+			defer n.wg.Done()
+			n.deliver(hook, delivery, body)
+		}(hook)
+	}
+	return nil
+}
+
+// Posts the body until it is accepted or the attempts run out, waiting
+// twice as long after each failure. Client errors other than 429 are not
+// retried since sending the same request again will not change the outcome.
+// Delivery stops early when the notifier gives up on it
+func (n *webhookNotifier) deliver(hook WebhookConfig, delivery *WebhookDelivery, body []byte) {
+	attempts := hook.MaxAttempts
+	if attempts <= 0 {
+		attempts = defaultWebhookAttempts
+	}
+	backoff := hook.Backoff
+	if backoff <= 0 {
+		backoff = defaultWebhookBackoff
+	}
+
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-n.ctx.Done():
+				timer.Stop()
+				return
+			}
+			backoff *= 2
+		}
+
+		attempt := DeliveryAttempt{At: n.now().UTC()}
+		statusCode, err := n.post(hook, delivery, body)
+		attempt.StatusCode = statusCode
+		if err != nil {
+			attempt.Error = err.Error()
+		}
+
+		delivered := err == nil
+		n.mu.Lock()
+		delivery.Attempts = append(delivery.Attempts, attempt)
+		delivery.Delivered = delivered
+		n.mu.Unlock()
+
+		retry := statusCode == 0 || statusCode >= http.StatusInternalServerError ||
+			statusCode == http.StatusTooManyRequests
+		if delivered || !retry {
+			return
+		}
+	}
+}
+
+func (n *webhookNotifier) post(hook WebhookConfig, delivery *WebhookDelivery, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, string(delivery.Event))
+	req.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	if hook.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhookBody(hook.Secret, body))
+	}
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+// Keeps the delivery, dropping the deliveries of the oldest crawl once too
+// many crawls are tracked. Must be called with the lock held
+func (n *webhookNotifier) record(delivery *WebhookDelivery) {
+	if _, ok := n.deliveries[delivery.CrawlID]; !ok {
+		n.crawls = append(n.crawls, delivery.CrawlID)
+	}
+	n.deliveries[delivery.CrawlID] = append(n.deliveries[delivery.CrawlID], delivery)
+
+	if len(n.crawls) > maxWebhookDeliveryCrawls {
+		delete(n.deliveries, n.crawls[0])
+		n.crawls = n.crawls[1:]
+	}
+}
+
+// Drops the deliveries of a deleted crawl, deliveries still in progress carry
+// on without being recorded
+func (n *webhookNotifier) forget(crawlID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, ok := n.deliveries[crawlID]; !ok {
+		return
+	}
+	delete(n.deliveries, crawlID)
+	for i, id := range n.crawls {
+		if id == crawlID {
+			n.crawls = append(n.crawls[:i], n.crawls[i+1:]...)
+			break
+		}
+	}
+}
+
+// Returns copies of the deliveries made for a crawl, in the order the
+// webhooks are configured
+func (n *webhookNotifier) get(crawlID string) []WebhookDelivery {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	deliveries := []WebhookDelivery{}
+	for _, d := range n.deliveries[crawlID] {
+		delivery := *d
+		delivery.Attempts = append([]DeliveryAttempt{}, d.Attempts...)
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries
+}
+
+// Computes the signature header value of a body, receivers recompute it with
+// the shared secret and compare using hmac.Equal
+func SignWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Builds the notification of a finished crawl, startedAt is when the crawl
+// began and err is its outcome
+func (s *crawlerService) notifyCrawl(crawlRec Metadata, startedAt time.Time, err error) {
+	if len(s.webhooks) == 0 {
+		return
+	}
+
+	notification := CrawlNotification{
+		Event:      EventCrawlCompleted,
+		CrawlID:    crawlRec.ID,
+		InitialURL: crawlRec.InitialURL,
+		Host:       crawlRec.Host,
+		PageCount:  len(crawlRec.CrawlResultSet),
+		ErrorCount: len(crawlRec.ErrList),
+		StartedAt:  startedAt.UTC(),
+		DurationMs: s.now().Sub(startedAt).Milliseconds(),
+	}
+	if len(crawlRec.Pages) > 0 {
+		notification.PageCount = len(crawlRec.Pages)
+	}
+	if err != nil {
+		notification.Event = EventCrawlFailed
+		notification.Error = err.Error()
+	}
+
+	if prev, ok := s.previousCrawl(crawlRec); ok && err == nil {
+		diff := DiffCrawls(prev, crawlRec)
+		notification.Diff = &diff
+	}
+
+	if err = s.notifier.notify(notification); err != nil {
+		s.logger.Sugar().Warnf("unable to send notifications for crawl %v: %v", crawlRec.ID, err.Error())
+	}
+}
+
+// Finds the most recent stored crawl of the same target, other than the
+// crawl itself, along with its pages
+func (s *crawlerService) previousCrawl(crawlRec Metadata) (Metadata, bool) {
+	crawls, err := s.crawlerRepo.GetCrawlsByHost(context.Background(), crawlRec.Host)
+	if err != nil {
+		return Metadata{}, false
+	}
+
+	// crawls of the host are sorted most recent first
+	for _, prev := range crawls {
+		if prev.ID != crawlRec.ID && crawlFingerprint(prev) == crawlRec.Fingerprint {
+			if err = s.loadPages(&prev); err != nil {
+				return Metadata{}, false
+			}
+			return prev, true
+		}
+	}
+	return Metadata{}, false
+}
+
+// Returns every webhook delivery made for a crawl along with its attempts.
+// Deliveries are kept in memory for the most recent 1000 crawls, and dropped
+// when the crawl is deleted
+func (s *crawlerService) GetWebhookDeliveries(crawlID string) []WebhookDelivery {
+	return s.notifier.get(crawlID)
+}
@@ -0,0 +1,251 @@
+package crawler_test
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sjain93/web-crawler-go/config"
+	"github.com/sjain93/web-crawler-go/src/crawler"
+	"github.com/sjain93/web-crawler-go/src/crawler/instance/instancetest"
+	"github.com/stretchr/testify/assert"
+)
+
+// Receives notifications, failing the first few requests it is sent
+type webhookReceiver struct {
+	mu            sync.Mutex
+	failures      int
+	status        int
+	notifications []crawler.CrawlNotification
+	signatures    []bool
+}
+
+func (rcv *webhookReceiver) handler(secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		rcv.mu.Lock()
+		defer rcv.mu.Unlock()
+
+		if rcv.failures > 0 {
+			rcv.failures--
+			w.WriteHeader(rcv.status)
+			return
+		}
+
+		var notification crawler.CrawlNotification
+		if err := json.Unmarshal(body, &notification); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		expected := crawler.SignWebhookBody(secret, body)
+		rcv.signatures = append(rcv.signatures, hmac.Equal(
+			[]byte(expected),
+			[]byte(r.Header.Get(crawler.WebhookSignatureHeader)),
+		))
+		rcv.notifications = append(rcv.notifications, notification)
+	}
+}
+
+func (rcv *webhookReceiver) received() []crawler.CrawlNotification {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	return append([]crawler.CrawlNotification{}, rcv.notifications...)
+}
+
+func TestWebhookNotifications(t *testing.T) {
+	const secret = "s3cret"
+
+	newService := func(factory *instancetest.Factory, hook crawler.WebhookConfig) crawler.CrawlerServiceManager {
+		crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+		assert.NoError(t, err)
+		crawlerSvc, err := crawler.NewCrawlerService(
+			crawler.WithRepository(crawlerRepo),
+			crawler.WithCrawlerFactory(factory),
+			crawler.WithWebhooks(hook),
+		)
+		assert.NoError(t, err)
+		return crawlerSvc
+	}
+
+	waitForDelivery := func(crawlerSvc crawler.CrawlerServiceManager, crawlID string) crawler.WebhookDelivery {
+		var delivery crawler.WebhookDelivery
+		assert.Eventually(t, func() bool {
+			deliveries := crawlerSvc.GetWebhookDeliveries(crawlID)
+			if len(deliveries) != 1 {
+				return false
+			}
+			delivery = deliveries[0]
+			return delivery.Delivered || len(delivery.Attempts) == 3
+		}, 2*time.Second, 5*time.Millisecond)
+		return delivery
+	}
+
+	t.Run("Completed crawls are signed and diffed against the previous crawl", func(t *testing.T) {
+		rcv := &webhookReceiver{failures: 2, status: http.StatusServiceUnavailable}
+		server := httptest.NewServer(rcv.handler(secret))
+		defer server.Close()
+
+		factory := &instancetest.Factory{Links: []string{"https://monzo.com/"}}
+		crawlerSvc := newService(factory, crawler.WebhookConfig{
+			URL:         server.URL,
+			Secret:      secret,
+			MaxAttempts: 3,
+			Backoff:     time.Millisecond,
+		})
+
+		first, err := crawlerSvc.CrawlSite(crawler.Metadata{InitialURL: "https://monzo.com/"}, crawler.CrawlOptions{})
+		assert.NoError(t, err)
+		delivery := waitForDelivery(crawlerSvc, first[0].ID)
+		assert.True(t, delivery.Delivered)
+		assert.Equal(t, crawler.EventCrawlCompleted, delivery.Event)
+		// the first two attempts are rejected and retried
+		assert.Len(t, delivery.Attempts, 3)
+		assert.Equal(t, http.StatusServiceUnavailable, delivery.Attempts[0].StatusCode)
+		assert.NotEmpty(t, delivery.Attempts[0].Error)
+		assert.Equal(t, http.StatusOK, delivery.Attempts[2].StatusCode)
+
+		factory.Links = []string{"https://monzo.com/", "https://monzo.com/isa/"}
+		second, err := crawlerSvc.CrawlSite(
+			crawler.Metadata{InitialURL: "https://monzo.com/"},
+			crawler.CrawlOptions{ForceRefresh: true},
+		)
+		assert.NoError(t, err)
+		assert.True(t, waitForDelivery(crawlerSvc, second[0].ID).Delivered)
+
+		received := rcv.received()
+		assert.Len(t, received, 2)
+		assert.Equal(t, []bool{true, true}, rcv.signatures)
+
+		assert.Equal(t, first[0].ID, received[0].CrawlID)
+		assert.Equal(t, "monzo.com", received[0].Host)
+		assert.Equal(t, 1, received[0].PageCount)
+		assert.Nil(t, received[0].Diff)
+
+		assert.Equal(t, second[0].ID, received[1].CrawlID)
+		assert.Equal(t, 2, received[1].PageCount)
+		assert.Equal(t, first[0].ID, received[1].Diff.BaseID)
+		assert.Equal(t, []string{"https://monzo.com/isa/"}, received[1].Diff.Added)
+
+		// the deliveries of a deleted crawl are dropped with it
+		assert.NoError(t, crawlerSvc.DeleteCrawl(first[0].ID))
+		assert.Empty(t, crawlerSvc.GetWebhookDeliveries(first[0].ID))
+		assert.Len(t, crawlerSvc.GetWebhookDeliveries(second[0].ID), 1)
+	})
+
+	t.Run("Failed crawls are reported", func(t *testing.T) {
+		rcv := &webhookReceiver{}
+		server := httptest.NewServer(rcv.handler(secret))
+		defer server.Close()
+
+		crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+		assert.NoError(t, err)
+		crawlerSvc, err := crawler.NewCrawlerService(
+			crawler.WithRepository(&failingSaveRepo{
+				CrawlerRepoManager: crawlerRepo,
+				err:                crawler.ErrInvalidDataType,
+			}),
+			crawler.WithCrawlerFactory(&instancetest.Factory{}),
+			crawler.WithWebhooks(crawler.WebhookConfig{URL: server.URL, Secret: secret}),
+		)
+		assert.NoError(t, err)
+
+		crawls, err := crawlerSvc.CrawlSite(crawler.Metadata{InitialURL: "https://monzo.com/"}, crawler.CrawlOptions{})
+		assert.ErrorIs(t, err, crawler.ErrInvalidDataType)
+		assert.True(t, waitForDelivery(crawlerSvc, crawls[0].ID).Delivered)
+
+		received := rcv.received()
+		assert.Len(t, received, 1)
+		assert.Equal(t, crawler.EventCrawlFailed, received[0].Event)
+		assert.Equal(t, crawler.ErrInvalidDataType.Error(), received[0].Error)
+	})
+
+	t.Run("Close waits for deliveries in progress", func(t *testing.T) {
+		rcv := &webhookReceiver{failures: 2, status: http.StatusServiceUnavailable}
+		server := httptest.NewServer(rcv.handler(secret))
+		defer server.Close()
+
+		crawlerSvc := newService(&instancetest.Factory{}, crawler.WebhookConfig{
+			URL:         server.URL,
+			Secret:      secret,
+			MaxAttempts: 3,
+			Backoff:     20 * time.Millisecond,
+		})
+
+		crawls, err := crawlerSvc.CrawlSite(crawler.Metadata{InitialURL: "https://monzo.com/"}, crawler.CrawlOptions{})
+		assert.NoError(t, err)
+		crawlerSvc.Close()
+
+		// the retries ran before Close returned
+		assert.Len(t, rcv.received(), 1)
+		assert.True(t, crawlerSvc.GetWebhookDeliveries(crawls[0].ID)[0].Delivered)
+	})
+
+	t.Run("Close gives up after the drain timeout", func(t *testing.T) {
+		rcv := &webhookReceiver{failures: 10, status: http.StatusServiceUnavailable}
+		server := httptest.NewServer(rcv.handler(secret))
+		defer server.Close()
+
+		crawlerRepo, err := crawler.NewCrawlerRepository(config.GetInMemoryStore[crawler.Metadata]())
+		assert.NoError(t, err)
+		cfg := crawler.NewDefaultServiceConfig()
+		cfg.WebhookDrainTimeout = 50 * time.Millisecond
+		crawlerSvc, err := crawler.NewCrawlerService(
+			crawler.WithRepository(crawlerRepo),
+			crawler.WithCrawlerFactory(&instancetest.Factory{}),
+			crawler.WithConfig(*cfg),
+			crawler.WithWebhooks(crawler.WebhookConfig{URL: server.URL, Backoff: time.Hour}),
+		)
+		assert.NoError(t, err)
+
+		crawls, err := crawlerSvc.CrawlSite(crawler.Metadata{InitialURL: "https://monzo.com/"}, crawler.CrawlOptions{})
+		assert.NoError(t, err)
+		start := time.Now()
+		crawlerSvc.Close()
+		assert.Less(t, time.Since(start), time.Second)
+
+		delivery := crawlerSvc.GetWebhookDeliveries(crawls[0].ID)[0]
+		assert.False(t, delivery.Delivered)
+		assert.Len(t, delivery.Attempts, 1)
+
+		// notifications are refused once closed
+		crawls, err = crawlerSvc.CrawlSite(
+			crawler.Metadata{InitialURL: "https://monzo.com/"},
+			crawler.CrawlOptions{ForceRefresh: true},
+		)
+		assert.NoError(t, err)
+		assert.Empty(t, crawlerSvc.GetWebhookDeliveries(crawls[0].ID))
+	})
+
+	t.Run("Client errors are not retried", func(t *testing.T) {
+		rcv := &webhookReceiver{failures: 1, status: http.StatusUnauthorized}
+		server := httptest.NewServer(rcv.handler(secret))
+		defer server.Close()
+
+		crawlerSvc := newService(&instancetest.Factory{}, crawler.WebhookConfig{
+			URL:         server.URL,
+			MaxAttempts: 3,
+			Backoff:     time.Millisecond,
+		})
+
+		crawls, err := crawlerSvc.CrawlSite(crawler.Metadata{InitialURL: "https://monzo.com/"}, crawler.CrawlOptions{})
+		assert.NoError(t, err)
+
+		assert.Eventually(t, func() bool {
+			deliveries := crawlerSvc.GetWebhookDeliveries(crawls[0].ID)
+			return len(deliveries) == 1 && len(deliveries[0].Attempts) == 1
+		}, time.Second, 5*time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+
+		delivery := crawlerSvc.GetWebhookDeliveries(crawls[0].ID)[0]
+		assert.False(t, delivery.Delivered)
+		assert.Len(t, delivery.Attempts, 1)
+		assert.Equal(t, http.StatusUnauthorized, delivery.Attempts[0].StatusCode)
+	})
+}
@@ -1,5 +1,7 @@
 package util
 
+import "context"
+
 const (
 	defaultTotalWorkers = 650
 )
@@ -21,3 +23,26 @@ func SetupConcurrency(customWorkerCount uint) *ConcurrencyConfig {
 
 	return &cs
 }
+
+// A counting semaphore backed by a buffered channel, it can be shared across
+// goroutines (or crawler instances) to cap how much work is in flight
+type Semaphore chan struct{}
+
+func NewSemaphore(size uint) Semaphore {
+	return make(Semaphore, size)
+}
+
+// Blocks until a slot is free or the context is done, false means no slot was
+// taken and Release must not be called
+func (s Semaphore) Acquire(ctx context.Context) bool {
+	select {
+	case s <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s Semaphore) Release() {
+	<-s
+}
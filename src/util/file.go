@@ -0,0 +1,32 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Writes the data to a temporary file in the same directory first, flushes it
+// to disk and renames it into place, so a crash or power loss mid-write never
+// leaves a partial file behind
+func WriteFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	// the rename may otherwise reach the disk before the data does
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -1,7 +1,11 @@
 package util_test
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/sjain93/web-crawler-go/src/util"
 	"github.com/stretchr/testify/assert"
@@ -164,3 +168,158 @@ func TestIsSameDomain(t *testing.T) {
 		})
 	}
 }
+
+func TestNormalizeURL(t *testing.T) {
+	testCases := map[string]struct {
+		rawURL    string
+		expected  string
+		wantError bool
+	}{
+		"Empty path": {
+			rawURL:   "https://monzo.com",
+			expected: "https://monzo.com/",
+		},
+		"Case, default port and fragment": {
+			rawURL:   "HTTPS://Monzo.com:443/blog/#mainContent",
+			expected: "https://monzo.com/blog/",
+		},
+		"Non default port and query order": {
+			rawURL:   "http://localhost:8080/search?q=a&b=c",
+			expected: "http://localhost:8080/search?b=c&q=a",
+		},
+		"Invalid Host": {
+			rawURL:    "ww.monzo.com",
+			wantError: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			res, err := util.NormalizeURL(tc.rawURL)
+			if tc.wantError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expected, res)
+			}
+		})
+	}
+}
+
+func TestSemaphore(t *testing.T) {
+	testCases := map[string]struct {
+		size     uint
+		acquires int
+		expected bool
+	}{
+		"Slot available": {
+			size:     2,
+			acquires: 1,
+			expected: true,
+		},
+		"Full - context done": {
+			size:     1,
+			acquires: 1,
+			expected: false,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			sem := util.NewSemaphore(tc.size)
+			for i := 0; i < tc.acquires; i++ {
+				assert.True(t, sem.Acquire(context.Background()))
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			assert.Equal(t, tc.expected, sem.Acquire(ctx))
+
+			sem.Release()
+			assert.True(t, sem.Acquire(context.Background()))
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// a Wednesday
+	from := time.Date(2023, time.March, 15, 10, 7, 30, 0, time.UTC)
+
+	testCases := map[string]struct {
+		expr      string
+		expected  time.Time
+		wantError bool
+	}{
+		"Every minute": {
+			expr:     "* * * * *",
+			expected: time.Date(2023, time.March, 15, 10, 8, 0, 0, time.UTC),
+		},
+		"Step": {
+			expr:     "*/15 * * * *",
+			expected: time.Date(2023, time.March, 15, 10, 15, 0, 0, time.UTC),
+		},
+		"Daily macro rolls over to the next day": {
+			expr:     "@daily",
+			expected: time.Date(2023, time.March, 16, 0, 0, 0, 0, time.UTC),
+		},
+		"Weekday range": {
+			expr:     "30 9 * * 1-5",
+			expected: time.Date(2023, time.March, 16, 9, 30, 0, 0, time.UTC),
+		},
+		"List and month rollover": {
+			expr:     "0 3 1,15 4 *",
+			expected: time.Date(2023, time.April, 1, 3, 0, 0, 0, time.UTC),
+		},
+		"Day of month or day of week": {
+			expr:     "0 0 20 * 5",
+			expected: time.Date(2023, time.March, 17, 0, 0, 0, 0, time.UTC),
+		},
+		"Never matches": {
+			expr:     "0 0 31 2 *",
+			expected: time.Time{},
+		},
+		"Wrong number of fields": {
+			expr:      "* * * *",
+			wantError: true,
+		},
+		"Out of range": {
+			expr:      "60 * * * *",
+			wantError: true,
+		},
+		"Invalid step": {
+			expr:      "*/0 * * * *",
+			wantError: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cron, err := util.ParseCron(tc.expr)
+			if tc.wantError {
+				assert.ErrorIs(t, err, util.ErrUtilInvalidCron)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, cron.Next(from))
+		})
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "crawl.json")
+
+	assert.NoError(t, util.WriteFileAtomic(path, []byte("first")))
+	assert.NoError(t, util.WriteFileAtomic(path, []byte("second")))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	assert.Error(t, util.WriteFileAtomic(filepath.Join(dir, "missing", "crawl.json"), []byte("x")))
+}
@@ -2,8 +2,10 @@ package util
 
 import (
 	"errors"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 )
 
@@ -83,3 +85,37 @@ func IsSameDomain(link, rootURL string) bool {
 
 	return u.Hostname() == domain
 }
+
+// Returns a canonical form of the URL so that equivalent URLs compare equal,
+// the scheme and host are lower cased, default ports and fragments are
+// dropped and an empty path becomes "/"
+func NormalizeURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Hostname() == "" {
+		return "", ErrUtilInvalidHost
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	u.Host = host
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.RawQuery = u.Query().Encode()
+
+	return u.String(), nil
+}